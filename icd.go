@@ -7,6 +7,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -61,6 +62,7 @@ func main() {
 	ecFlag := flag.Bool("genec", false, "generate ECDSA identity keys (these are using NIST curve SecP384")
 	saecFlag := flag.Bool("gen25519", false, "generate EC 25519 identify keys")
 	dbgFlag := flag.Bool("debug", false, "activate debug log")
+	vecFlag := flag.String("testvectors", "", "print the test vectors derived from this seed (JSON) and exit")
 	//jsonFlag := flag.Bool("json", true, "use json communication channel")
 
 	// we cannot use more than 2048K anyway why bother with a flag then
@@ -75,6 +77,17 @@ func main() {
 		icutl.InitDebugLog(ioutil.Discard)
 	}
 
+	if len(*vecFlag) > 0 {
+		vectors, err := ickp.GenerateTestVectors([]byte(*vecFlag))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "test vectors error: %v\n", err)
+			os.Exit(1)
+		}
+		out, _ := json.MarshalIndent(vectors, "", "\t")
+		fmt.Printf("%s\n", out)
+		os.Exit(0)
+	}
+
 	if *rsaFlag == true || *ecFlag == true || *saecFlag == true {
 		// generate a set of identity RSA keys and save them to file encrypted
 		//accp.GenRSAKeys()
@@ -5,66 +5,242 @@ package ickp
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/sha3"
 	"io"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
-// DecryptPEMBlock takes a password encrypted PEM block and the password used to
-// encrypt it and returns a slice of decrypted DER encoded bytes. It inspects
-// the DEK-Info header to determine the algorithm used for decryption. If no
-// DEK-Info header is present, an error is returned. If an incorrect password
-// is detected an IncorrectPasswordError is returned.
-func AEADDecryptPEMBlock(b *pem.Block, password []byte) ([]byte, error) {
-	AesHash := sha3.New256
+// KDFParams are the Argon2id parameters used to derive the AES key from the
+// password, they are written in the "KDF-Info" PEM header.
+type KDFParams struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+	// Memory is the memory size in KiB.
+	Memory uint32
+	// Threads is the parallelism degree.
+	Threads uint8
+}
+
+const (
+	kdfArgon2id = "argon2id"
+
+	// salt sizes, the PBKDF2 one is what keys without KDF-Info header use.
+	pbkdf2SaltSize = 8
+	argon2SaltSize = 16
+)
+
+// MessageTooLongError is returned when the data is longer than Max bytes,
+// check for it with errors.As.
+type MessageTooLongError struct {
+	Max int
+}
+
+func (e *MessageTooLongError) Error() string {
+	return fmt.Sprintf("message too long: %d bytes max", e.Max)
+}
 
-	dek, ok := b.Headers["DEK-Info"]
-	if !ok {
-		return nil, errors.New("AEADDecryptPEMBlock: no DEK-Info header in block")
+// ErrBadPassphrase is returned by AEADDecryptPEMBlock whatever went wrong:
+// malformed header, wrong passphrase, wrong associated data or corrupted
+// data.
+var ErrBadPassphrase = errors.New("AEADDecryptPEMBlock: bad passphrase or corrupted block")
+
+// kdfPepper is the application pepper mixed into the derived AES key, only
+// its ID is written in the "KDF-Pepper" PEM header.
+type kdfPepper struct {
+	id     string
+	secret []byte
+}
+
+var currentPepper atomic.Value
+
+// SetKDFPepper sets the package wide pepper: every block written afterwards
+// is tagged with id and its key is mixed with secret, and blocks tagged with
+// a pepper ID can only be decrypted while the same id and secret are set.
+// Blocks without KDF-Pepper header are not affected. An empty id removes the
+// pepper.
+func SetKDFPepper(id string, secret []byte) error {
+	if len(id) == 0 {
+		currentPepper.Store(kdfPepper{})
+		return nil
+	}
+	if strings.ContainsAny(id, ",\r\n") || len(secret) == 0 {
+		return errors.New("SetKDFPepper: invalid pepper")
 	}
+	currentPepper.Store(kdfPepper{id: id, secret: append([]byte(nil), secret...)})
+	return nil
+}
 
-	dekData := strings.Split(dek, ",")
-	if len(dekData) != 3 {
-		return nil, errors.New("AEADDecryptPEMBlock: malformed DEK-Info header")
+// getKDFPepper returns the pepper set with SetKDFPepper, the id is empty if
+// none is.
+func getKDFPepper() kdfPepper {
+	p, _ := currentPepper.Load().(kdfPepper)
+	return p
+}
+
+// aadDigest is what goes in the AEAD additional data for the caller's
+// associated data, hashed so that it can't be confused with the headers.
+func aadDigest(aad []byte) string {
+	d := sha3.Sum256(aad)
+	return hex.EncodeToString(d[:])
+}
+
+// pepperKey mixes the pepper secret into the derived key.
+func pepperKey(key, secret []byte) []byte {
+	mac := hmac.New(sha3.New256, secret)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
+
+func (p KDFParams) String() string {
+	return fmt.Sprintf("%s,%d,%d,%d", kdfArgon2id, p.Time, p.Memory, p.Threads)
+}
+
+// parseKDFInfo parses the "KDF-Info" header: argon2id,<time>,<memory>,<threads>
+func parseKDFInfo(kdf string) (p KDFParams, ok bool) {
+	kdfData := strings.Split(kdf, ",")
+	if len(kdfData) != 4 || kdfData[0] != kdfArgon2id {
+		return p, false
 	}
 
-	hexNonce, hexSalt := dekData[1], dekData[2]
-	nonce, err := hex.DecodeString(hexNonce)
-	if err != nil {
-		return nil, err
+	t, errT := strconv.ParseUint(kdfData[1], 10, 32)
+	m, errM := strconv.ParseUint(kdfData[2], 10, 32)
+	th, errTh := strconv.ParseUint(kdfData[3], 10, 8)
+	if errT != nil || errM != nil || errTh != nil {
+		return p, false
 	}
 
-	salt, err := hex.DecodeString(hexSalt)
-	if err != nil {
-		return nil, err
+	p = KDFParams{Time: uint32(t), Memory: uint32(m), Threads: uint8(th)}
+	if p.Time < 1 || p.Memory < 8*uint32(p.Threads) || p.Threads < 1 {
+		return p, false
 	}
+	return p, true
+}
 
-	if len(salt) != 8 {
-		return nil, errors.New("AEADDecryptPEMBlock: incorrect salt size")
+// withinLimits reports whether p stays under MaxKDFTime and MaxKDFMemory,
+// we won't run Argon2 with more than that, whatever a header says.
+func (p KDFParams) withinLimits() bool {
+	return p.Time <= MaxKDFTime && p.Memory <= MaxKDFMemory
+}
+
+// deriveKey derives the AES-256 key, with Argon2id when params is set and
+// the historical PBKDF2-SHA3-256 otherwise.
+func deriveKey(password, salt []byte, params *KDFParams) []byte {
+	if params != nil {
+		return argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, 32)
 	}
+	return pbkdf2.Key(password, salt, 16384, 32, sha3.New256)
+}
 
-	/* let's PBKDF2 first.. */
-	ourKey := pbkdf2.Key(password, salt, 16384, 32, AesHash)
+// AEADDecryptPEMBlock takes a password encrypted PEM block and the password
+// used to encrypt it and returns a slice of decrypted DER encoded bytes. It
+// inspects the DEK-Info (and KDF-Info if any) header to determine the
+// parameters used for decryption, blocks without KDF-Info are PBKDF2 ones.
+// Blocks with a KDF-Pepper header need the same pepper set with SetKDFPepper.
+// Every failure returns ErrBadPassphrase, and the key derivation and AEAD
+// open are always run, so that neither the error nor the timing tell which
+// stage failed.
+func AEADDecryptPEMBlock(b *pem.Block, password []byte) ([]byte, error) {
+	return AEADDecryptPEMBlockWithAAD(b, password, nil)
+}
+
+// AEADDecryptPEMBlockWithAAD is AEADDecryptPEMBlock for blocks written by
+// AEADEncryptPEMBlockWithAAD, aad must be the associated data they were
+// written with. A block with an AAD-Label header opened without (or with
+// other) associated data, or a block without one opened with associated
+// data, fails with ErrBadPassphrase.
+func AEADDecryptPEMBlockWithAAD(b *pem.Block, password, aad []byte) ([]byte, error) {
+	// placeholders used when the header is unusable.
+	salt := make([]byte, pbkdf2SaltSize)
+	nonce := make([]byte, 12)
+	valid := true
+
+	dek := b.Headers["DEK-Info"]
+	ad := dek
+
+	var params *KDFParams
+	iter := 0
+	if kdf, ok := b.Headers["KDF-Info"]; ok {
+		if p, ok := parseKDFInfo(kdf); ok {
+			// only the headers tell, nothing about the passphrase, and
+			// the placeholder key derivation would be more than asked.
+			if !p.withinLimits() {
+				return nil, ErrBadPassphrase
+			}
+			params = &p
+			salt = make([]byte, argon2SaltSize)
+		} else if n, ok := parsePBKDF2Info(kdf); ok {
+			iter = n
+			salt = make([]byte, fipsPBKDF2SaltSize)
+		} else {
+			valid = false
+		}
+		ad = dek + "," + kdf
+	}
+	// only the headers tell, nothing about the passphrase.
+	if FIPSMode() && iter == 0 {
+		return nil, ErrNotFIPSApproved
+	}
+
+	var pepper []byte
+	if id, ok := b.Headers["KDF-Pepper"]; ok {
+		p := getKDFPepper()
+		pepper = p.secret
+		if len(id) == 0 || id != p.id {
+			// we still run it, with a placeholder.
+			pepper = make([]byte, 32)
+			valid = false
+		}
+		ad = ad + "," + id
+	}
+
+	if label, ok := b.Headers["AAD-Label"]; ok {
+		valid = valid && len(label) > 0
+		ad = ad + "," + label + "," + aadDigest(aad)
+	} else if aad != nil {
+		valid = false
+	}
+
+	dekData := strings.Split(dek, ",")
+	valid = valid && len(dekData) == 3
+	if valid {
+		hexNonce, errNonce := hex.DecodeString(dekData[1])
+		hexSalt, errSalt := hex.DecodeString(dekData[2])
+		valid = errNonce == nil && errSalt == nil && len(hexNonce) == len(nonce) && len(hexSalt) == len(salt)
+		if valid {
+			nonce, salt = hexNonce, hexSalt
+		}
+	}
+
+	var ourKey []byte
+	if iter > 0 {
+		ourKey = pbkdf2.Key(password, salt, iter, 32, sha3.New256)
+	} else {
+		ourKey = deriveKey(password, salt, params)
+	}
+	if pepper != nil {
+		ourKey = pepperKey(ourKey, pepper)
+	}
 	aesraw, err := aes.NewCipher(ourKey)
 	if err != nil {
-		return nil, errors.New("AEADEncryptPEMBlock: AES key setup failed: " + err.Error())
+		return nil, ErrBadPassphrase
 	}
 	aesgcm, err := cipher.NewGCM(aesraw)
 	if err != nil {
-		return nil, errors.New("AEADEncryptPEMBlock: GCM failed: " + err.Error())
+		return nil, ErrBadPassphrase
 	}
 
-	if len(nonce) != aesgcm.NonceSize() {
-		return nil, errors.New("AEADDecryptPEMBlock: incorrect nonce size")
-	}
-
-	plaintext, err := aesgcm.Open(nil, nonce, b.Bytes, []byte(dek))
-	if err != nil {
-		return nil, errors.New("AEADDecryptPEMBlock: wrong parameters")
+	plaintext, err := aesgcm.Open(nil, nonce, b.Bytes, []byte(ad))
+	if err != nil || !valid {
+		return nil, ErrBadPassphrase
 	}
 
 	return plaintext, nil
@@ -72,19 +248,88 @@ func AEADDecryptPEMBlock(b *pem.Block, password []byte) ([]byte, error) {
 
 // AEADEncryptPEMBlock returns a PEM block of the specified type holding the
 // given DER-encoded data encrypted with AES-GCM256 algorithm, key is derived
-// using PBKDF2 on the password.
-// Header will be :
+// using Argon2id with DefaultKDFParams on the password, PBKDF2-SHA3-256 in
+// FIPS mode (see EnableFIPSMode).
+// Data longer than MaxSealSize is refused with a MessageTooLongError.
 func AEADEncryptPEMBlock(rand io.Reader, blockType string, data, password []byte) (*pem.Block, error) {
-	AesHash := sha3.New256
+	return aeadEncryptPEMBlock(rand, blockType, data, password, encryptKDFParams(), "", nil)
+}
 
-	salt := make([]byte, 8)
+// AEADEncryptPEMBlockWithAAD is AEADEncryptPEMBlock also authenticating the
+// associated data aad, i.e. the hostname or role the key is meant for. aad
+// itself is not stored, only label is, in the "AAD-Label" header, and the
+// same aad has to be given to AEADDecryptPEMBlockWithAAD.
+func AEADEncryptPEMBlockWithAAD(rand io.Reader, blockType string, data, password []byte, label string, aad []byte) (*pem.Block, error) {
+	if len(label) == 0 || strings.ContainsAny(label, ",\r\n") {
+		return nil, errors.New("AEADEncryptPEMBlock: invalid AAD label")
+	}
+	if len(aad) == 0 {
+		return nil, errors.New("AEADEncryptPEMBlock: empty associated data")
+	}
+	return aeadEncryptPEMBlock(rand, blockType, data, password, encryptKDFParams(), label, aad)
+}
+
+// AEADEncryptPEMBlockWithKDF is AEADEncryptPEMBlock with the given Argon2id
+// parameters, it fails with ErrNotFIPSApproved in FIPS mode.
+// Headers will be :
+// Proc-Type: 4,ENCRYPTED
+// DEK-Info: AES-256-GCM,<hex nonce>,<hex salt>
+// KDF-Info: argon2id,<time>,<memory KiB>,<threads> (pbkdf2-sha3-256,<iterations> in FIPS mode)
+// KDF-Pepper: <pepper id> (only when SetKDFPepper was used)
+// AAD-Label: <label> (only with AEADEncryptPEMBlockWithAAD)
+// all of them are authenticated, along with the associated data if any.
+func AEADEncryptPEMBlockWithKDF(rand io.Reader, blockType string, data, password []byte, params KDFParams) (*pem.Block, error) {
+	if FIPSMode() {
+		return nil, ErrNotFIPSApproved
+	}
+	return aeadEncryptPEMBlock(rand, blockType, data, password, &params, "", nil)
+}
+
+// encryptKDFParams are the Argon2id parameters newly written blocks use, nil
+// for PBKDF2 in FIPS mode.
+func encryptKDFParams() *KDFParams {
+	if FIPSMode() {
+		return nil
+	}
+	params := DefaultKDFParams
+	return &params
+}
+
+// aeadEncryptPEMBlock does the work, aad is only used when label is set and
+// the key is derived with PBKDF2 when params is nil.
+func aeadEncryptPEMBlock(rand io.Reader, blockType string, data, password []byte, params *KDFParams, label string, aad []byte) (*pem.Block, error) {
+	if len(data) > MaxSealSize {
+		return nil, &MessageTooLongError{Max: MaxSealSize}
+	}
+
+	kdf := kdfPBKDF2 + "," + strconv.Itoa(fipsPBKDF2Iterations)
+	saltSize := fipsPBKDF2SaltSize
+	if params != nil {
+		kdf = params.String()
+		p, ok := parseKDFInfo(kdf)
+		if !ok || !p.withinLimits() {
+			return nil, errors.New("AEADEncryptPEMBlock: invalid KDF parameters")
+		}
+		saltSize = argon2SaltSize
+	}
+
+	salt := make([]byte, saltSize)
 	_, err := io.ReadFull(rand, salt)
 	if err != nil {
 		return nil, errors.New("AEADEncryptPEMBlock: no rand: " + err.Error())
 	}
 
-	/* let's PBKDF2 first.. */
-	ourKey := pbkdf2.Key(password, salt, 16384, 32, AesHash)
+	/* let's Argon2 first.. */
+	var ourKey []byte
+	if params != nil {
+		ourKey = deriveKey(password, salt, params)
+	} else {
+		ourKey = pbkdf2.Key(password, salt, fipsPBKDF2Iterations, 32, sha3.New256)
+	}
+	pepper := getKDFPepper()
+	if len(pepper.id) > 0 {
+		ourKey = pepperKey(ourKey, pepper.secret)
+	}
 	aesraw, err := aes.NewCipher(ourKey)
 	if err != nil {
 		return nil, errors.New("AEADEncryptPEMBlock: AES key setup failed: " + err.Error())
@@ -107,9 +352,19 @@ func AEADEncryptPEMBlock(rand io.Reader, blockType string, data, password []byte
 	ourHeader := make(map[string]string)
 	ourHeader["Proc-Type"] = "4,ENCRYPTED"
 	ourHeader["DEK-Info"] = "AES-256-GCM" + "," + hex.EncodeToString(nonce) + "," + hex.EncodeToString(salt)
+	ourHeader["KDF-Info"] = kdf
+	ad := ourHeader["DEK-Info"] + "," + kdf
+	if len(pepper.id) > 0 {
+		ourHeader["KDF-Pepper"] = pepper.id
+		ad = ad + "," + pepper.id
+	}
+	if len(label) > 0 {
+		ourHeader["AAD-Label"] = label
+		ad = ad + "," + label + "," + aadDigest(aad)
+	}
 
 	/* encrypt & authenticate */
-	encrypted := aesgcm.Seal(nil, nonce, data, []byte(ourHeader["DEK-Info"]))
+	encrypted := aesgcm.Seal(nil, nonce, data, []byte(ad))
 
 	/* we're done. */
 	return &pem.Block{
@@ -0,0 +1,479 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// every decryption failure must end up in the same error.
+func TestAEADDecryptPEMBlockUniformError(t *testing.T) {
+	passwd := []byte("passphrase")
+
+	block, err := AEADEncryptPEMBlock(rand.Reader, PEMHDR_25519, []byte("some secret data"), passwd)
+	if err != nil {
+		t.Fatalf("AEADEncryptPEMBlock() error: %v\n", err)
+	}
+
+	plain, err := AEADDecryptPEMBlock(block, passwd)
+	if err != nil || string(plain) != "some secret data" {
+		t.Fatalf("AEADDecryptPEMBlock() error: %v\n", err)
+	}
+
+	dek := block.Headers["DEK-Info"]
+	corrupt := func(f func(b *pem.Block)) *pem.Block {
+		b := &pem.Block{
+			Type:    block.Type,
+			Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": dek},
+			Bytes:   append([]byte(nil), block.Bytes...),
+		}
+		f(b)
+		return b
+	}
+
+	tests := map[string]*pem.Block{
+		"no DEK-Info":      corrupt(func(b *pem.Block) { delete(b.Headers, "DEK-Info") }),
+		"malformed header": corrupt(func(b *pem.Block) { b.Headers["DEK-Info"] = "AES-256-GCM" }),
+		"bad nonce hex":    corrupt(func(b *pem.Block) { b.Headers["DEK-Info"] = "AES-256-GCM,zz" + dek[14:] }),
+		"short salt":       corrupt(func(b *pem.Block) { b.Headers["DEK-Info"] = dek[:len(dek)-2] }),
+		"ciphertext":       corrupt(func(b *pem.Block) { b.Bytes[0] ^= 0x01 }),
+		"tag":              corrupt(func(b *pem.Block) { b.Bytes[len(b.Bytes)-1] ^= 0x01 }),
+	}
+
+	for name, b := range tests {
+		_, err := AEADDecryptPEMBlock(b, passwd)
+		if err != ErrBadPassphrase {
+			t.Logf("%s: AEADDecryptPEMBlock() error: %v\n", name, err)
+			t.Fail()
+		}
+	}
+
+	_, err = AEADDecryptPEMBlock(block, []byte("wrong"))
+	if err != ErrBadPassphrase {
+		t.Logf("wrong passphrase: AEADDecryptPEMBlock() error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestAEADEncryptPEMBlockMaxSize(t *testing.T) {
+	defer ResetDefaults()
+	MaxSealSize = 16
+
+	_, err := AEADEncryptPEMBlock(rand.Reader, PEMHDR_25519, make([]byte, 16), []byte("passphrase"))
+	if err != nil {
+		t.Logf("AEADEncryptPEMBlock() error: %v\n", err)
+		t.Fail()
+	}
+
+	_, err = AEADEncryptPEMBlock(rand.Reader, PEMHDR_25519, make([]byte, 17), []byte("passphrase"))
+	var tooLong *MessageTooLongError
+	if !errors.As(err, &tooLong) || tooLong.Max != 16 {
+		t.Logf("AEADEncryptPEMBlock() SHOULD fail with MessageTooLongError: %v\n", err)
+		t.Fail()
+	}
+}
+
+// blocks written before KDF-Info (PBKDF2, 8 bytes salt) must still decrypt.
+func TestAEADDecryptPEMBlockPBKDF2(t *testing.T) {
+	passwd := []byte("passphrase")
+	salt := []byte("saltsalt")
+	nonce := make([]byte, 12)
+
+	aesraw, _ := aes.NewCipher(deriveKey(passwd, salt, nil))
+	aesgcm, _ := cipher.NewGCM(aesraw)
+	dek := "AES-256-GCM," + hex.EncodeToString(nonce) + "," + hex.EncodeToString(salt)
+
+	block := &pem.Block{
+		Type:    PEMHDR_25519,
+		Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": dek},
+		Bytes:   aesgcm.Seal(nil, nonce, []byte("old data"), []byte(dek)),
+	}
+
+	plain, err := AEADDecryptPEMBlock(block, passwd)
+	if err != nil || string(plain) != "old data" {
+		t.Logf("AEADDecryptPEMBlock() error: %v\n", err)
+		t.Fail()
+	}
+
+	// the KDF cannot be swapped on an existing block.
+	block.Headers["KDF-Info"] = DefaultKDFParams.String()
+	_, err = AEADDecryptPEMBlock(block, passwd)
+	if err != ErrBadPassphrase {
+		t.Logf("AEADDecryptPEMBlock() SHOULD fail with an added KDF-Info: %v\n", err)
+		t.Fail()
+	}
+}
+
+// a header above the limits fails before the KDF runs: deriving with them
+// would take 4GiB and minutes here.
+func TestAEADPEMBlockKDFLimits(t *testing.T) {
+	passwd := []byte("passphrase")
+	light := KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	defer ResetDefaults()
+
+	block, err := AEADEncryptPEMBlockWithKDF(rand.Reader, PEMHDR_25519, []byte("data"), passwd, light)
+	if err != nil {
+		t.Fatalf("AEADEncryptPEMBlockWithKDF() error: %v\n", err)
+	}
+
+	for _, kdf := range []string{"argon2id,1,4194304,1", "argon2id,1000,8192,1"} {
+		crafted := &pem.Block{Type: block.Type, Headers: map[string]string{}, Bytes: block.Bytes}
+		for k, v := range block.Headers {
+			crafted.Headers[k] = v
+		}
+		crafted.Headers["KDF-Info"] = kdf
+		_, err = AEADDecryptPEMBlock(crafted, passwd)
+		if err != ErrBadPassphrase {
+			t.Logf("AEADDecryptPEMBlock(%s) error: %v\n", kdf, err)
+			t.Fail()
+		}
+	}
+
+	// the limits are tunables.
+	MaxKDFMemory = 4 * 1024
+	_, err = AEADDecryptPEMBlock(block, passwd)
+	if err != ErrBadPassphrase {
+		t.Logf("AEADDecryptPEMBlock() over MaxKDFMemory error: %v\n", err)
+		t.Fail()
+	}
+	_, err = AEADEncryptPEMBlockWithKDF(rand.Reader, PEMHDR_25519, []byte("data"), passwd, light)
+	if err == nil {
+		t.Logf("AEADEncryptPEMBlockWithKDF() SHOULD fail over MaxKDFMemory\n")
+		t.Fail()
+	}
+
+	ResetDefaults()
+	data, err := AEADDecryptPEMBlock(block, passwd)
+	if err != nil || string(data) != "data" {
+		t.Logf("AEADDecryptPEMBlock() error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestAEADPEMBlockPepper(t *testing.T) {
+	passwd := []byte("passphrase")
+	light := KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	defer ResetDefaults()
+
+	plainBlock, err := AEADEncryptPEMBlockWithKDF(rand.Reader, PEMHDR_25519, []byte("data"), passwd, light)
+	if err != nil {
+		t.Fatalf("AEADEncryptPEMBlockWithKDF() error: %v\n", err)
+	}
+
+	err = SetKDFPepper("prod-2024", []byte("pepper secret"))
+	if err != nil {
+		t.Fatalf("SetKDFPepper() error: %v\n", err)
+	}
+	block, err := AEADEncryptPEMBlockWithKDF(rand.Reader, PEMHDR_25519, []byte("data"), passwd, light)
+	if err != nil {
+		t.Fatalf("AEADEncryptPEMBlockWithKDF() error: %v\n", err)
+	}
+	if block.Headers["KDF-Pepper"] != "prod-2024" {
+		t.Logf("KDF-Pepper header: %q\n", block.Headers["KDF-Pepper"])
+		t.Fail()
+	}
+
+	plain, err := AEADDecryptPEMBlock(block, passwd)
+	if err != nil || string(plain) != "data" {
+		t.Logf("AEADDecryptPEMBlock() error: %v\n", err)
+		t.Fail()
+	}
+
+	// blocks without pepper are still readable.
+	_, err = AEADDecryptPEMBlock(plainBlock, passwd)
+	if err != nil {
+		t.Logf("AEADDecryptPEMBlock() without pepper error: %v\n", err)
+		t.Fail()
+	}
+
+	SetKDFPepper("prod-2024", []byte("another secret"))
+	_, err = AEADDecryptPEMBlock(block, passwd)
+	if err != ErrBadPassphrase {
+		t.Logf("AEADDecryptPEMBlock() SHOULD fail with the wrong pepper: %v\n", err)
+		t.Fail()
+	}
+
+	SetKDFPepper("", nil)
+	_, err = AEADDecryptPEMBlock(block, passwd)
+	if err != ErrBadPassphrase {
+		t.Logf("AEADDecryptPEMBlock() SHOULD fail without pepper: %v\n", err)
+		t.Fail()
+	}
+
+	if SetKDFPepper("bad,id", []byte("secret")) == nil {
+		t.Logf("SetKDFPepper() SHOULD fail with a comma in the id\n")
+		t.Fail()
+	}
+}
+
+func benchmarkAEADDecryptPEMBlock(b *testing.B, params KDFParams) {
+	passwd := []byte("passphrase")
+
+	block, err := AEADEncryptPEMBlockWithKDF(rand.Reader, PEMHDR_25519, benchMsg[:64], passwd, params)
+	if err != nil {
+		b.Fatalf("AEADEncryptPEMBlockWithKDF() error: %v\n", err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, err = AEADDecryptPEMBlock(block, passwd)
+		if err != nil {
+			b.Fatalf("AEADDecryptPEMBlock() error: %v\n", err)
+		}
+	}
+}
+
+func BenchmarkAEADDecryptPEMBlockDefault(b *testing.B) {
+	benchmarkAEADDecryptPEMBlock(b, DefaultKDFParams)
+}
+
+func BenchmarkAEADDecryptPEMBlockLight(b *testing.B) {
+	benchmarkAEADDecryptPEMBlock(b, KDFParams{Time: 1, Memory: 32 * 1024, Threads: 2})
+}
+
+func TestPromptNewPassphrase(t *testing.T) {
+	out := new(bytes.Buffer)
+	passwd, err := PromptNewPassphrase(strings.NewReader("secret\r\nsecret"), out)
+	if err != nil || string(passwd) != "secret" {
+		t.Logf("PromptNewPassphrase() error: %v [%q]\n", err, passwd)
+		t.Fail()
+	}
+	if !strings.Contains(out.String(), "Confirm") {
+		t.Logf("PromptNewPassphrase() prompt: %q\n", out.String())
+		t.Fail()
+	}
+
+	_, err = PromptNewPassphrase(strings.NewReader("secret\nsecrets\n"), out)
+	if err != ErrPassphraseMismatch {
+		t.Logf("PromptNewPassphrase() SHOULD fail with ErrPassphraseMismatch: %v\n", err)
+		t.Fail()
+	}
+
+	_, err = PromptNewPassphrase(strings.NewReader("secret\n"), out)
+	if err == nil {
+		t.Logf("PromptNewPassphrase() SHOULD fail without confirmation\n")
+		t.Fail()
+	}
+}
+
+func TestPassphraseSources(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "keyfile")
+	ioutil.WriteFile(keyFile, []byte("secret\n"), 0600)
+
+	// the key file content is the passphrase as is, newline included.
+	passwd, err := PassphraseFile(keyFile).Passphrase()
+	if err != nil || string(passwd) != "secret\n" {
+		t.Logf("PassphraseFile() = %q, %v\n", passwd, err)
+		t.Fail()
+	}
+	_, err = PassphraseFile(filepath.Join(dir, "missing")).Passphrase()
+	if err == nil {
+		t.Logf("PassphraseFile() SHOULD fail on a missing file\n")
+		t.Fail()
+	}
+	_, err = PassphraseFile(dir).Passphrase()
+	if err == nil {
+		t.Logf("PassphraseFile() SHOULD fail on an unreadable file\n")
+		t.Fail()
+	}
+
+	t.Setenv("IC_TEST_PASSPHRASE", "")
+	_, err = PassphraseEnv("IC_TEST_PASSPHRASE").Passphrase()
+	if err == nil {
+		t.Logf("PassphraseEnv() SHOULD fail on an empty variable\n")
+		t.Fail()
+	}
+	_, err = PassphraseEnv("IC_TEST_PASSPHRASE_UNSET").Passphrase()
+	if err == nil {
+		t.Logf("PassphraseEnv() SHOULD fail on a missing variable\n")
+		t.Fail()
+	}
+
+	// the same key under every source, all give the same passphrase.
+	t.Setenv("IC_TEST_PASSPHRASE", "secret\n")
+	prefix := filepath.Join(dir, "key")
+	i, err := GenerateAndSave(prefix, KEYEC25519, KeyParams{}, []byte("secret\n"), false)
+	if err != nil {
+		t.Fatalf("GenerateAndSave() error: %v\n", err)
+	}
+	fp, _ := i.Fingerprint()
+	sources := []PassphraseSource{
+		PassphraseBytes("secret\n"),
+		PassphraseFile(keyFile),
+		PassphraseEnv("IC_TEST_PASSPHRASE"),
+		PassphraseFunc(func() ([]byte, error) { return []byte("secret\n"), nil }),
+	}
+	for k, src := range sources {
+		loaded := new(IdentityKey)
+		err = loaded.FromKeyFilesSource(prefix, src)
+		if err != nil {
+			t.Logf("source %d FromKeyFilesSource() error: %v\n", k, err)
+			t.Fail()
+			continue
+		}
+		if lfp, _ := loaded.Fingerprint(); lfp != fp {
+			t.Logf("source %d FromKeyFilesSource() loaded another key\n", k)
+			t.Fail()
+		}
+
+		buf := new(bytes.Buffer)
+		err = loaded.PrivToPKIXSource(buf, src)
+		if err != nil {
+			t.Fatalf("source %d PrivToPKIXSource() error: %v\n", k, err)
+		}
+		err = new(IdentityKey).PKIXToPriv(buf, []byte("secret\n"))
+		if err != nil {
+			t.Logf("source %d PrivToPKIXSource() output does not load: %v\n", k, err)
+			t.Fail()
+		}
+	}
+
+	failing := PassphraseFunc(func() ([]byte, error) { return nil, errors.New("no tty") })
+	if new(IdentityKey).FromKeyFilesSource(prefix, failing) == nil {
+		t.Logf("FromKeyFilesSource() SHOULD fail when the source does\n")
+		t.Fail()
+	}
+	if i.PrivToPKIXSource(new(bytes.Buffer), failing) == nil {
+		t.Logf("PrivToPKIXSource() SHOULD fail when the source does\n")
+		t.Fail()
+	}
+}
+
+// `echo pw | cmd`: a pipe is not a terminal, we read a line.
+func TestReadPassphrasePipe(t *testing.T) {
+	for _, input := range []string{"secret\n", "secret\r\n", "secret"} {
+		rd, wr, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("os.Pipe() error: %v\n", err)
+		}
+		go func() {
+			wr.Write([]byte(input))
+			wr.Close()
+		}()
+
+		passwd, err := ReadPassphrase(rd, ioutil.Discard)
+		rd.Close()
+		if err != nil || string(passwd) != "secret" {
+			t.Logf("ReadPassphrase(%q) error: %v [%q]\n", input, err, passwd)
+			t.Fail()
+		}
+	}
+
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() error: %v\n", err)
+	}
+	go func() {
+		wr.Write([]byte("secret\nsecret\n"))
+		wr.Close()
+	}()
+	passwd, err := PromptNewPassphrase(rd, ioutil.Discard)
+	rd.Close()
+	if err != nil || string(passwd) != "secret" {
+		t.Logf("PromptNewPassphrase() pipe error: %v [%q]\n", err, passwd)
+		t.Fail()
+	}
+}
+
+func TestResetDefaults(t *testing.T) {
+	MaxSealSize = 1
+	DefaultKDFParams.Time = 1
+	SSHSigNamespace = "git"
+	MaxKDFMemory = 1
+	SetKDFPepper("id", []byte("secret"))
+	keyType, err := RegisterKeyType("ic-reset", dummyProvider{})
+	if err != nil {
+		t.Fatalf("RegisterKeyType() error: %v\n", err)
+	}
+
+	ResetDefaults()
+	if MaxSealSize != 64<<20 || DefaultKDFParams.Time != 3 || SSHSigNamespace != "file" || MaxKDFMemory != 1<<20 || len(getKDFPepper().id) > 0 {
+		t.Logf("ResetDefaults() left modified tunables\n")
+		t.Fail()
+	}
+	if _, ok := S2K["ic-reset"]; ok || customKeyType(keyType) != nil || len(K2S) != 3 {
+		t.Logf("ResetDefaults() left registered key types\n")
+		t.Fail()
+	}
+
+	// the key types are given again.
+	again, err := RegisterKeyType("ic-reset", dummyProvider{})
+	if err != nil || again != keyType {
+		t.Logf("RegisterKeyType() after ResetDefaults() = %d, %v\n", again, err)
+		t.Fail()
+	}
+	ResetDefaults()
+}
+
+func TestAEADPEMBlockAAD(t *testing.T) {
+	passwd := []byte("passphrase")
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+
+	block, err := AEADEncryptPEMBlockWithAAD(rand.Reader, PEMHDR_25519, []byte("data"), passwd, "host", []byte("web01.example.com"))
+	if err != nil {
+		t.Fatalf("AEADEncryptPEMBlockWithAAD() error: %v\n", err)
+	}
+	if block.Headers["AAD-Label"] != "host" {
+		t.Logf("AAD-Label header: %q\n", block.Headers["AAD-Label"])
+		t.Fail()
+	}
+	if strings.Contains(string(pem.EncodeToMemory(block)), "web01") {
+		t.Logf("the associated data SHOULD NOT be stored\n")
+		t.Fail()
+	}
+
+	plain, err := AEADDecryptPEMBlockWithAAD(block, passwd, []byte("web01.example.com"))
+	if err != nil || string(plain) != "data" {
+		t.Fatalf("AEADDecryptPEMBlockWithAAD() error: %v\n", err)
+	}
+
+	tests := map[string][]byte{
+		"other aad": []byte("web02.example.com"),
+		"no aad":    nil,
+	}
+	for name, aad := range tests {
+		_, err = AEADDecryptPEMBlockWithAAD(block, passwd, aad)
+		if err != ErrBadPassphrase {
+			t.Logf("%s: AEADDecryptPEMBlockWithAAD() error: %v\n", name, err)
+			t.Fail()
+		}
+	}
+
+	// the label is authenticated too.
+	block.Headers["AAD-Label"] = "role"
+	_, err = AEADDecryptPEMBlockWithAAD(block, passwd, []byte("web01.example.com"))
+	if err != ErrBadPassphrase {
+		t.Logf("changed label: AEADDecryptPEMBlockWithAAD() error: %v\n", err)
+		t.Fail()
+	}
+
+	// and a block without AAD can't be opened with one.
+	plainBlock, err := AEADEncryptPEMBlock(rand.Reader, PEMHDR_25519, []byte("data"), passwd)
+	if err != nil {
+		t.Fatalf("AEADEncryptPEMBlock() error: %v\n", err)
+	}
+	_, err = AEADDecryptPEMBlockWithAAD(plainBlock, passwd, []byte("web01.example.com"))
+	if err != ErrBadPassphrase {
+		t.Logf("unexpected aad: AEADDecryptPEMBlockWithAAD() error: %v\n", err)
+		t.Fail()
+	}
+
+	_, err = AEADEncryptPEMBlockWithAAD(rand.Reader, PEMHDR_25519, []byte("data"), passwd, "bad,label", []byte("aad"))
+	if err == nil {
+		t.Logf("AEADEncryptPEMBlockWithAAD() SHOULD fail with a comma in the label\n")
+		t.Fail()
+	}
+}
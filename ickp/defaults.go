@@ -0,0 +1,79 @@
+package ickp
+
+import (
+	"time"
+)
+
+// The package tunables, all of them are set back to these values by
+// ResetDefaults.
+const (
+	defaultMaxSealSize      = 64 << 20
+	defaultMaxPublicKeySize = 64 << 10
+	defaultSSHSigNamespace  = "file"
+	defaultWatchInterval    = 2 * time.Second
+	defaultMaxRSAKeyBits    = 16384
+	defaultMaxKDFTime       = 16
+	defaultMaxKDFMemory     = 1 << 20
+)
+
+var (
+	// MaxSealSize caps the data AEADEncryptPEMBlock accepts, AES-GCM itself
+	// has no practical limit but everything is held in memory (and base64'ed
+	// again in the PEM) so we don't want to take anything.
+	MaxSealSize = defaultMaxSealSize
+
+	// DefaultKDFParams are used for newly written keys, it is the RFC 9106
+	// second recommended option (t=3, m=64MiB, p=4): about 100ms on a current
+	// desktop. Lower end devices may want t=1, m=32MiB, p=2.
+	DefaultKDFParams = defaultKDFParams()
+
+	// MaxPublicKeySize caps the decompressed size of a public key line
+	// payload, a 4096 bits RSA key is about 550 bytes.
+	MaxPublicKeySize int64 = defaultMaxPublicKeySize
+
+	// SSHSigNamespace is the namespace SignSSHSig and VerifySSHSig work
+	// under, it is the -n argument of ssh-keygen -Y sign / verify. The
+	// WithNamespace variants take theirs per call.
+	SSHSigNamespace = defaultSSHSigNamespace
+
+	// WatchInterval is the polling interval used by WatchKeyFiles.
+	WatchInterval = defaultWatchInterval
+
+	// MaxRSAKeyBits caps the modulus size of the RSA keys we load, private
+	// or public, a crafted key with a huge modulus would otherwise have us
+	// spend a lot of CPU on it. Larger keys fail with ErrKeyTooLarge.
+	MaxRSAKeyBits = defaultMaxRSAKeyBits
+
+	// MaxKDFTime and MaxKDFMemory (in KiB, 1GiB) cap the Argon2id parameters
+	// a KDF-Info header (or a sealed stream, or key files digest) can ask
+	// for, blocks asking for more fail right away, without running the KDF:
+	// key files from untrusted places could otherwise have us allocate and
+	// hash for minutes.
+	MaxKDFTime   uint32 = defaultMaxKDFTime
+	MaxKDFMemory uint32 = defaultMaxKDFMemory
+)
+
+func defaultKDFParams() KDFParams {
+	return KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+}
+
+// ResetDefaults sets every package tunable above back to its default, removes
+// the KeyHook (SetKeyHook), KDF pepper (SetKDFPepper) and registered key types
+// (RegisterKeyType), and turns FIPS mode (EnableFIPSMode) off. It is meant for
+// test isolation, production code should not call it at runtime: it races
+// with any concurrent use of the package.
+func ResetDefaults() {
+	MaxSealSize = defaultMaxSealSize
+	DefaultKDFParams = defaultKDFParams()
+	MaxPublicKeySize = defaultMaxPublicKeySize
+	SSHSigNamespace = defaultSSHSigNamespace
+	WatchInterval = defaultWatchInterval
+	MaxRSAKeyBits = defaultMaxRSAKeyBits
+	MaxKDFTime = defaultMaxKDFTime
+	MaxKDFMemory = defaultMaxKDFMemory
+
+	SetKeyHook(nil)
+	SetKDFPepper("", nil)
+	resetKeyTypes()
+	disableFIPSMode()
+}
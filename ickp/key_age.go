@@ -0,0 +1,75 @@
+package ickp
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"filippo.io/age"
+)
+
+// ToAgeEncrypted writes the private key encrypted to the age recipients, the
+// age payload is the key PEM block without passphrase encryption, age is the
+// only protection.
+func (i *IdentityKey) ToAgeEncrypted(wr io.Writer, recipients ...age.Recipient) error {
+	keyHeader, keyDer, err := i.privKeyDer()
+	if err != nil {
+		return err
+	}
+	defer wipeBytes(keyDer)
+
+	aw, err := age.Encrypt(wr, recipients...)
+	if err != nil {
+		return err
+	}
+
+	err = pem.Encode(aw, &pem.Block{Type: keyHeader, Bytes: keyDer})
+	if err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// FromAgeEncrypted loads a private key written by ToAgeEncrypted, decrypted
+// with one of the age identities.
+func FromAgeEncrypted(rd io.Reader, identities []age.Identity) (*IdentityKey, error) {
+	ar, err := age.Decrypt(rd, identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ioutil.ReadAll(ar)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(buf)
+
+	block, _ := pem.Decode(normalizeNewlines(buf))
+	if block == nil {
+		return nil, errors.New("no PEM found")
+	}
+	defer wipeBytes(block.Bytes)
+
+	if _, ok := block.Headers["DEK-Info"]; ok {
+		return nil, errors.New("passphrase encrypted key, use FromKeyFiles")
+	}
+
+	i := new(IdentityKey)
+	err = i.derToPriv(block.Type, block.Bytes)
+	if err == nil {
+		err = i.Validate()
+	}
+	if err != nil {
+		i.wipe()
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func wipeBytes(b []byte) {
+	for k := range b {
+		b[k] = 0
+	}
+}
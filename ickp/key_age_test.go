@@ -0,0 +1,82 @@
+package ickp
+
+import (
+	"bytes"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestAgeEncrypted(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() error: %v\n", err)
+	}
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, err := NewIdentityKey(keytype)
+		if err != nil {
+			t.Fatalf("NewIdentityKey() error: %v\n", err)
+		}
+
+		buf := new(bytes.Buffer)
+		err = i.ToAgeEncrypted(buf, identity.Recipient())
+		if err != nil {
+			t.Fatalf("ToAgeEncrypted() error: %v\n", err)
+		}
+
+		j, err := FromAgeEncrypted(buf, []age.Identity{identity})
+		if err != nil {
+			t.Logf("%s FromAgeEncrypted() error: %v\n", i.Type(), err)
+			t.Fail()
+			continue
+		}
+
+		fpI, _ := i.Fingerprint()
+		fpJ, _ := j.Fingerprint()
+		if fpI != fpJ || i.keyOwner.String() != j.keyOwner.String() {
+			t.Logf("%s FromAgeEncrypted() loaded another key\n", i.Type())
+			t.Fail()
+		}
+	}
+}
+
+func TestPrivToRecipient(t *testing.T) {
+	i, _ := NewIdentityKey(KEYECDSA)
+	iFP, _ := i.Fingerprint()
+
+	for _, keytype := range []int{KEYRSA, KEYEC25519} {
+		recipient, _ := NewIdentityKey(keytype)
+		keyBin, _ := recipient.pubKeyBin()
+
+		buf := new(bytes.Buffer)
+		err := i.PrivToRecipient(buf, &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin})
+		if err != nil {
+			t.Fatalf("%s PrivToRecipient() error: %v\n", recipient.Type(), err)
+		}
+
+		other, _ := NewIdentityKey(keytype)
+		_, err = FromRecipient(bytes.NewReader(buf.Bytes()), other)
+		if err == nil {
+			t.Logf("%s FromRecipient() SHOULD fail with another key\n", recipient.Type())
+			t.Fail()
+		}
+
+		loaded, err := FromRecipient(buf, recipient)
+		if err != nil {
+			t.Fatalf("%s FromRecipient() error: %v\n", recipient.Type(), err)
+		}
+		fp, _ := loaded.Fingerprint()
+		if fp != iFP {
+			t.Logf("%s FromRecipient() loaded another key\n", recipient.Type())
+			t.Fail()
+		}
+	}
+
+	ecBin, _ := i.pubKeyBin()
+	err := i.PrivToRecipient(new(bytes.Buffer), &IdentityPublicKey{KeyType: KEYECDSA, KeyBin: ecBin})
+	if err != ErrUnsupportedRecipient {
+		t.Logf("PrivToRecipient() SHOULD fail with an ECDSA recipient: %v\n", err)
+		t.Fail()
+	}
+}
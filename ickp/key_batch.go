@@ -0,0 +1,74 @@
+package ickp
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// KeySpec describes one key of a GenerateBatch.
+type KeySpec struct {
+	KeyType int
+	Params  KeyParams
+}
+
+// BatchError is returned by GenerateBatch when some of the keys could not be
+// generated, Errs has one entry per spec, nil for the keys that were.
+type BatchError struct {
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	for _, err := range e.Errs {
+		if err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("GenerateBatch: %d of %d keys failed", failed, len(e.Errs))
+}
+
+// GenerateBatch generates the keys described by specs on a pool of
+// GOMAXPROCS workers, keys are returned in the specs order. When any key
+// fails, its slot is nil and the error is a *BatchError telling why. Specs
+// not started yet when ctx is done fail with ctx.Err().
+// A KeyParams.Rand shared between specs must be safe for concurrent use.
+func GenerateBatch(ctx context.Context, specs []KeySpec) ([]*IdentityKey, error) {
+	keys := make([]*IdentityKey, len(specs))
+	errs := make([]error, len(specs))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(specs) {
+		workers = len(specs)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				if err := ctx.Err(); err != nil {
+					errs[n] = err
+					continue
+				}
+				keys[n], errs[n] = NewIdentityKeyWithParams(specs[n].KeyType, specs[n].Params)
+			}
+		}()
+	}
+
+	for n := range specs {
+		next <- n
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return keys, &BatchError{Errs: errs}
+		}
+	}
+	return keys, nil
+}
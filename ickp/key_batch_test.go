@@ -0,0 +1,95 @@
+package ickp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGenerateBatch(t *testing.T) {
+	specs := []KeySpec{
+		{KeyType: KEYEC25519},
+		{KeyType: KEYECDSA},
+		{KeyType: 42},
+		{KeyType: KEYEC25519},
+	}
+
+	keys, err := GenerateBatch(context.Background(), specs)
+	batchErr, ok := err.(*BatchError)
+	if !ok {
+		t.Fatalf("GenerateBatch() error: %v\n", err)
+	}
+	for n, spec := range specs {
+		if n == 2 {
+			if keys[n] != nil || batchErr.Errs[n] == nil {
+				t.Logf("GenerateBatch() SHOULD fail the invalid key type\n")
+				t.Fail()
+			}
+			continue
+		}
+		if batchErr.Errs[n] != nil || keys[n] == nil || keys[n].keyType != spec.KeyType {
+			t.Logf("GenerateBatch() key %d: %v\n", n, batchErr.Errs[n])
+			t.Fail()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = GenerateBatch(ctx, specs)
+	batchErr, ok = err.(*BatchError)
+	if !ok {
+		t.Fatalf("GenerateBatch() error: %v\n", err)
+	}
+	for n := range specs {
+		if batchErr.Errs[n] != context.Canceled {
+			t.Logf("GenerateBatch() canceled key %d: %v\n", n, batchErr.Errs[n])
+			t.Fail()
+		}
+	}
+}
+
+func rsaSpecs(n int) []KeySpec {
+	specs := make([]KeySpec, n)
+	for k := range specs {
+		specs[k].KeyType = KEYRSA
+	}
+	return specs
+}
+
+func BenchmarkGenerateBatchRSA50(b *testing.B) {
+	specs := rsaSpecs(50)
+	for n := 0; n < b.N; n++ {
+		_, err := GenerateBatch(context.Background(), specs)
+		if err != nil {
+			b.Fatalf("GenerateBatch() error: %v\n", err)
+		}
+	}
+}
+
+func BenchmarkGenerateSequentialRSA50(b *testing.B) {
+	specs := rsaSpecs(50)
+	for n := 0; n < b.N; n++ {
+		for _, spec := range specs {
+			_, err := NewIdentityKeyWithParams(spec.KeyType, spec.Params)
+			if err != nil {
+				b.Fatalf("NewIdentityKeyWithParams() error: %v\n", err)
+			}
+		}
+	}
+}
+
+func TestEstimateGenDuration(t *testing.T) {
+	rsaEst := EstimateGenDuration(KEYRSA, KeyParams{})
+	edEst := EstimateGenDuration(KEYEC25519, KeyParams{})
+	if edEst <= 0 || rsaEst < 1000*edEst {
+		t.Logf("EstimateGenDuration() RSA %v, EC25519 %v\n", rsaEst, edEst)
+		t.Fail()
+	}
+	if EstimateGenDuration(KEYEC25519, KeyParams{RNGHealthCheck: true}) <= edEst {
+		t.Logf("EstimateGenDuration() SHOULD account for the RNG health check\n")
+		t.Fail()
+	}
+	if EstimateGenDuration(42, KeyParams{}) != 0 {
+		t.Logf("EstimateGenDuration() SHOULD be 0 for unknown key types\n")
+		t.Fail()
+	}
+}
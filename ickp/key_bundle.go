@@ -0,0 +1,86 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// bundleContext is the SignWithContext label of public bundles.
+const bundleContext = "ic-public-bundle"
+
+// BundleInfo is the metadata of a signed public bundle.
+type BundleInfo struct {
+	Created time.Time `json:"created"`
+	Usage   string    `json:"usage"`
+}
+
+// publicBundle is what the key signs, Key is the public key line.
+type publicBundle struct {
+	Key string `json:"key"`
+	BundleInfo
+}
+
+type signedPublicBundle struct {
+	Bundle    publicBundle `json:"bundle"`
+	Signature []byte       `json:"signature"`
+}
+
+// SignedPublicBundle returns the public key line, the creation time (now)
+// and usage, self-signed by the key, as JSON. The signature covers the
+// canonical JSON (see CanonicalJSON) of the bundle.
+func (i *IdentityKey) SignedPublicBundle(usage string) ([]byte, error) {
+	line := new(bytes.Buffer)
+	err := i.PubToPKIX(line)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := publicBundle{
+		Key: string(bytes.TrimSpace(line.Bytes())),
+		BundleInfo: BundleInfo{
+			Created: time.Now().UTC().Truncate(time.Second),
+			Usage:   usage,
+		},
+	}
+
+	msg, err := CanonicalJSON(bundle)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := i.SignWithContext(bundleContext, rand.Reader, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(signedPublicBundle{Bundle: bundle, Signature: sig})
+}
+
+// VerifySignedPublicBundle checks the self-signature of a SignedPublicBundle
+// and returns the public key and metadata it holds.
+func VerifySignedPublicBundle(data []byte) (*IdentityPublicKey, *BundleInfo, error) {
+	var signed signedPublicBundle
+	err := json.Unmarshal(data, &signed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub, err := parsePublicFile([]byte(signed.Bundle.Key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := CanonicalJSON(signed.Bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = pub.VerifyWithContext(bundleContext, msg, signed.Signature)
+	if err != nil {
+		return nil, nil, errors.New("invalid public bundle signature")
+	}
+
+	info := signed.Bundle.BundleInfo
+	return pub, &info, nil
+}
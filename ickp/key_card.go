@@ -0,0 +1,87 @@
+package ickp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	cardComment = "# ic identity card:"
+
+	// the signed metadata keys of a card.
+	cardName    = "card.name"
+	cardEmail   = "card.email"
+	cardCreated = "card.created"
+)
+
+// CardInfo is the display information of an identity card.
+type CardInfo struct {
+	Name    string
+	Email   string
+	Created time.Time
+}
+
+// MakeCard returns the identity card of the key: its public key line signed
+// along with name, email and the creation time (see PubToPKIXWithMetadata),
+// preceded by a comment line for humans. It is a single text blob to share
+// out of band, ParseCard checks it. The key must hold its private part.
+func (i *IdentityKey) MakeCard(name, email string) ([]byte, error) {
+	if len(name) == 0 {
+		return nil, errors.New("empty card name")
+	}
+	// the name goes in the comment line as is, a newline would end it.
+	if !utf8.ValidString(name) || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return nil, errors.New("invalid card name")
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return nil, errors.New("invalid card email")
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s %s <%s>\n", cardComment, name, email)
+	err = i.PubToPKIXWithMetadata(buf, map[string]string{
+		cardName:    name,
+		cardEmail:   email,
+		cardCreated: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// ParseCard parses and verifies a MakeCard identity card, the CardInfo
+// comes from the signed metadata only, never from the comment line.
+func ParseCard(data []byte) (*IdentityPublicKey, CardInfo, error) {
+	var info CardInfo
+
+	pub, err := parsePublicFile(data)
+	if err != nil {
+		return nil, info, err
+	}
+	// make sure the key itself is usable.
+	_, err = parsePubKeyBin(pub.KeyType, pub.KeyBin)
+	if err != nil {
+		return nil, info, err
+	}
+
+	info.Name = pub.Metadata[cardName]
+	info.Email = pub.Metadata[cardEmail]
+	created, ok := pub.Metadata[cardCreated]
+	if len(info.Name) == 0 || len(info.Email) == 0 || !ok {
+		return nil, info, errors.New("not an identity card")
+	}
+	info.Created, err = time.Parse(time.RFC3339, created)
+	if err != nil {
+		return nil, info, err
+	}
+	return pub, info, nil
+}
@@ -0,0 +1,66 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// challengeContext is the SignWithContext label of challenge responses, a
+// response cannot be replayed as a signature of anything else.
+const challengeContext = "ic-challenge-response"
+
+// fingerprintSize is the size of a binary (not hex) fingerprint.
+const fingerprintSize = 32
+
+// RespondChallenge signs challenge for a challenge-response authentication.
+// The response is the signer fingerprint followed by the signature of
+// fingerprint || challenge, under a dedicated context label.
+func (i *IdentityKey) RespondChallenge(challenge []byte) ([]byte, error) {
+	fp, err := i.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	fpBin, err := hex.DecodeString(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := i.SignWithContext(challengeContext, rand.Reader, challengeMessage(fpBin, challenge))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(fpBin, sig...), nil
+}
+
+// VerifyChallengeResponse checks response is a RespondChallenge of challenge
+// by one of the trusted keys, and returns that key.
+func VerifyChallengeResponse(challenge, response []byte, trusted []*IdentityPublicKey) (*IdentityPublicKey, error) {
+	if len(response) <= fingerprintSize {
+		return nil, errors.New("invalid challenge response")
+	}
+	fpBin, sig := response[:fingerprintSize], response[fingerprintSize:]
+	fp := hex.EncodeToString(fpBin)
+
+	for _, pub := range trusted {
+		pubFP, err := pubFingerprint(pub.KeyBin)
+		if err != nil || !FingerprintEqual(fp, pubFP) {
+			continue
+		}
+
+		err = pub.VerifyWithContext(challengeContext, challengeMessage(fpBin, challenge), sig)
+		if err != nil {
+			return nil, err
+		}
+		return pub, nil
+	}
+
+	return nil, errors.New("challenge response from an untrusted key")
+}
+
+func challengeMessage(fpBin, challenge []byte) []byte {
+	msg := make([]byte, 0, len(fpBin)+len(challenge))
+	msg = append(msg, fpBin...)
+	return append(msg, challenge...)
+}
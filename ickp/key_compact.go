@@ -0,0 +1,113 @@
+package ickp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// compactPrefix starts compact public key strings.
+const compactPrefix = "IC1"
+
+// compactEncoding only uses characters of the QR code alphanumeric mode.
+var compactEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// PubToCompactString returns the public key as a short string meant for QR
+// codes: "IC1" || base32(key type byte || key), without padding, where key
+// is the raw 32 bytes for EC25519, the compressed point for ECDSA and the
+// PKIX DER for RSA. It only uses the QR alphanumeric character set:
+// EC25519 gives 56 characters and ECDSA P-256 58, that fits a version 3 QR
+// code with L or M error correction (77 / 61 characters), RSA 4096 gives 885
+// characters and needs a version 19-L QR code or above.
+func (i *IdentityKey) PubToCompactString() (string, error) {
+	return compactString(i.public())
+}
+
+// PubToCompactString is the IdentityKey one for public keys.
+func (p *IdentityPublicKey) PubToCompactString() (string, error) {
+	pub, err := p.CryptoPublicKey()
+	if err != nil {
+		return "", err
+	}
+	return compactString(pub)
+}
+
+func compactString(pub crypto.PublicKey) (string, error) {
+	var keyType int
+	var raw []byte
+	var err error
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		keyType = KEYRSA
+		raw, err = x509.MarshalPKIXPublicKey(k)
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return "", errors.New("unsupported ECDSA curve")
+		}
+		keyType = KEYECDSA
+		raw = elliptic.MarshalCompressed(k.Curve, k.X, k.Y)
+	case ed25519.PublicKey:
+		keyType = KEYEC25519
+		raw = k
+	default:
+		err = errors.New("unsupported public key type")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	buf := append([]byte{byte(keyType)}, raw...)
+	return compactPrefix + compactEncoding.EncodeToString(buf), nil
+}
+
+// ParseCompactString parses a PubToCompactString string, lowercase is
+// accepted too. The key owner is not part of the compact string.
+func ParseCompactString(s string) (*IdentityPublicKey, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if !strings.HasPrefix(s, compactPrefix) {
+		return nil, errors.New("invalid compact public key")
+	}
+
+	buf, err := compactEncoding.DecodeString(s[len(compactPrefix):])
+	if err != nil || len(buf) < 2 {
+		return nil, errors.New("invalid compact public key")
+	}
+
+	var pub crypto.PublicKey
+	raw := buf[1:]
+	switch int(buf[0]) {
+	case KEYRSA:
+		pub, err = parsePKIXPublicKey(raw)
+		if err == nil {
+			if _, ok := pub.(*rsa.PublicKey); !ok {
+				err = errors.New("keytype confusion or invalid")
+			}
+		}
+	case KEYECDSA:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), raw)
+		if x == nil {
+			return nil, errors.New("invalid compact ECDSA key")
+		}
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	case KEYEC25519:
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid compact EC25519 key")
+		}
+		pub = ed25519.PublicKey(raw)
+	default:
+		return nil, errors.New("keytype confusion or invalid")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return IdentityPublicKeyFromCrypto(pub)
+}
@@ -0,0 +1,272 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// Format is a public key encoding.
+type Format int
+
+const (
+	// FormatICLine is the "ic-* <payload> <owner>" public key line.
+	FormatICLine Format = iota
+	// FormatOpenSSH is the OpenSSH authorized_keys line.
+	FormatOpenSSH
+	// FormatPEM is the "PUBLIC KEY" PEM block holding the PKIX DER.
+	FormatPEM
+	// FormatJWK is the JSON Web Key (RFC 7517).
+	FormatJWK
+	// FormatDER is the raw PKIX (SubjectPublicKeyInfo) DER.
+	FormatDER
+)
+
+const (
+	pemPublicKey = "PUBLIC KEY"
+
+	// nilOwner is written in ic public key lines when the owner is unknown,
+	// i.e. when converting from a format without owner.
+	nilOwner = "00000000-0000-0000-0000-000000000000"
+)
+
+// jwk holds the JSON Web Key members we use.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+var b64url = base64.RawURLEncoding
+
+// ConvertPublic re-encodes a public key in the out format, the input format
+// (any of the Format ones) is detected automatically. The owner of an ic
+// public key line is kept when converting to another ic line, it is written
+// as the nil UUID when unknown.
+func ConvertPublic(in []byte, out Format) ([]byte, error) {
+	pub, err := parsePublicAny(in)
+	if err != nil {
+		return nil, err
+	}
+
+	cpub, err := pub.CryptoPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	switch out {
+	case FormatICLine:
+		owner := pub.KeyOwner
+		if len(owner) == 0 {
+			owner = nilOwner
+		}
+		buf := new(bytes.Buffer)
+		err = writePublicLine(buf, pub.KeyType, pub.KeyBin, owner, false)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatOpenSSH:
+		sshPub, err := ssh.NewPublicKey(cpub)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.MarshalAuthorizedKey(sshPub), nil
+	case FormatPEM:
+		der, err := x509.MarshalPKIXPublicKey(cpub)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+	case FormatJWK:
+		return marshalJWK(cpub)
+	case FormatDER:
+		return x509.MarshalPKIXPublicKey(cpub)
+	}
+
+	return nil, errors.New("invalid output format")
+}
+
+// parsePublicAny detects the public key encoding and parses it.
+func parsePublicAny(in []byte) (*IdentityPublicKey, error) {
+	trimmed := bytes.TrimSpace(in)
+
+	var cpub crypto.PublicKey
+	var err error
+
+	switch {
+	case len(trimmed) == 0:
+		return nil, errors.New("empty public key")
+	case bytes.HasPrefix(trimmed, []byte("ic-")) || trimmed[0] == '#':
+		return parsePublicFile(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("-----BEGIN")):
+		block, _ := pem.Decode(normalizeNewlines(trimmed))
+		if block == nil || block.Type != pemPublicKey {
+			return nil, errors.New("no PUBLIC KEY PEM block found")
+		}
+		cpub, err = parsePKIXPublicKey(block.Bytes)
+	case trimmed[0] == '{':
+		cpub, err = parseJWK(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("ssh-")) || bytes.HasPrefix(trimmed, []byte("ecdsa-sha2-")):
+		sshPub, _, _, _, perr := ssh.ParseAuthorizedKey(trimmed)
+		if perr != nil {
+			return nil, perr
+		}
+		cryptoPub, ok := sshPub.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, errors.New("unsupported OpenSSH key type")
+		}
+		cpub = cryptoPub.CryptoPublicKey()
+	default:
+		cpub, err = parsePKIXPublicKey(in)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return IdentityPublicKeyFromCrypto(cpub)
+}
+
+// SamePublicKey reports whether a and b hold the same public key, each of
+// them in any of the Format encodings, comments and owners are ignored.
+// An error is only returned when either input does not parse.
+func SamePublicKey(a, b []byte) (bool, error) {
+	pubA, err := parsePublicAny(a)
+	if err != nil {
+		return false, err
+	}
+	pubB, err := parsePublicAny(b)
+	if err != nil {
+		return false, err
+	}
+
+	cpubA, err := pubA.CryptoPublicKey()
+	if err != nil {
+		return false, err
+	}
+	cpubB, err := pubB.CryptoPublicKey()
+	if err != nil {
+		return false, err
+	}
+
+	k, ok := cpubA.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false, errors.New("unsupported public key type")
+	}
+	return k.Equal(cpubB), nil
+}
+
+// padBytes left pads b with zeroes to size bytes.
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
+func marshalJWK(cpub crypto.PublicKey) ([]byte, error) {
+	var k jwk
+
+	switch pub := cpub.(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = b64url.EncodeToString(pub.N.Bytes())
+		k.E = b64url.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		k.Kty = "EC"
+		k.Crv = pub.Curve.Params().Name
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		k.X = b64url.EncodeToString(padBytes(pub.X.Bytes(), size))
+		k.Y = b64url.EncodeToString(padBytes(pub.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		k.Kty = "OKP"
+		k.Crv = "Ed25519"
+		k.X = b64url.EncodeToString(pub)
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+
+	return json.Marshal(k)
+}
+
+func parseJWK(in []byte) (crypto.PublicKey, error) {
+	var k jwk
+
+	err := json.Unmarshal(in, &k)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := b64url.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64url.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		eInt := new(big.Int).SetBytes(e)
+		// as GenKeysRSAWithExponent: odd, at least 3, 31 bits.
+		if len(n) == 0 || !eInt.IsInt64() || eInt.Int64() < 3 || eInt.Int64() > 1<<31-1 || eInt.Bit(0) == 0 {
+			return nil, errors.New("invalid JWK RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(eInt.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported JWK curve")
+		}
+		x, err := b64url.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64url.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("invalid JWK EC point")
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.New("unsupported JWK curve")
+		}
+		x, err := b64url.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid JWK Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, errors.New("unsupported JWK key type")
+}
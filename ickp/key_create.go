@@ -0,0 +1,76 @@
+package ickp
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// how long LoadOrCreate waits for another process creating the key.
+	loadOrCreateTimeout = time.Minute
+	loadOrCreatePoll    = 100 * time.Millisecond
+)
+
+// LoadOrCreate loads the identity key from prefix / prefix.pub, or, when
+// there is none yet, generates a key of keytype with params and saves it
+// there. created tells which one happened.
+// Processes starting together are safe: the creation is done holding
+// prefix.lock, created with O_EXCL, the others wait for it to go away and
+// load the key the winner saved. A lock file left by a crashed process makes
+// LoadOrCreate fail after a minute, it has to be removed by hand.
+func LoadOrCreate(prefix string, keytype int, params KeyParams, passwd []byte) (i *IdentityKey, created bool, err error) {
+	lockPath := prefix + ".lock"
+	deadline := time.Now().Add(loadOrCreateTimeout)
+
+	for {
+		i, err = LoadIdentityKey(prefix, passwd)
+		if err == nil || !os.IsNotExist(err) {
+			return i, false, err
+		}
+
+		var lock *os.File
+		lock, err = os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			i, created, err = createLocked(prefix, keytype, params, passwd)
+			lock.Close()
+			os.Remove(lockPath)
+			return i, created, err
+		}
+		if !os.IsExist(err) {
+			return nil, false, err
+		}
+
+		// someone else is creating it.
+		if time.Now().After(deadline) {
+			return nil, false, fmt.Errorf("%s still held, remove it if no process is creating the key", lockPath)
+		}
+		time.Sleep(loadOrCreatePoll)
+	}
+}
+
+// createLocked is the LoadOrCreate creation, with the lock held. The key
+// may have been created between our load attempt and the lock, files that
+// exist are never overwritten.
+func createLocked(prefix string, keytype int, params KeyParams, passwd []byte) (*IdentityKey, bool, error) {
+	for _, f := range []string{prefix, prefix + ".pub"} {
+		_, err := os.Stat(f)
+		if err == nil {
+			i, err := LoadIdentityKey(prefix, passwd)
+			return i, false, err
+		}
+		if !os.IsNotExist(err) {
+			return nil, false, err
+		}
+	}
+
+	i, err := NewIdentityKeyWithParams(keytype, params)
+	if err != nil {
+		return nil, false, err
+	}
+	err = i.saveKeyFiles(prefix, passwd, false)
+	if err != nil {
+		return nil, false, err
+	}
+	return i, true, nil
+}
@@ -0,0 +1,86 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+)
+
+// aeadDER is the binary (no PEM armor) form of an AEAD encrypted private key,
+// it carries the same informations as the PEM block: the block type, the
+// DEK-Info header (which is also the AEAD additional data) and the ciphertext.
+// The KDF-Info and KDF-Pepper headers, when present, are also authenticated
+// and have to be kept, they are optional so that older blobs still parse.
+type aeadDER struct {
+	Type      string
+	DEKInfo   string
+	Data      []byte
+	KDFInfo   string `asn1:"optional,explicit,tag:0,utf8"`
+	KDFPepper string `asn1:"optional,explicit,tag:1,utf8"`
+}
+
+// PubToDER returns the public key in its binary DER/ASN.1 form, without the
+// compression and base64 encoding used in the public key file.
+func (i *IdentityKey) PubToDER() ([]byte, error) {
+	return i.pubKeyBin()
+}
+
+// DERToPub sets the public part of the identity key from its binary DER/ASN.1
+// form as returned by PubToDER.
+func (i *IdentityKey) DERToPub(der []byte) error {
+	return i.setPub(i.keyType, der)
+}
+
+// PrivToDER returns the AEAD encrypted private key in binary form, without the
+// PEM armor.
+func (i *IdentityKey) PrivToDER(passwd []byte) ([]byte, error) {
+	keyHeader, keyDer, err := i.privKeyDer()
+	if err != nil {
+		return nil, err
+	}
+
+	pemKey, err := AEADEncryptPEMBlock(rand.Reader, keyHeader, keyDer, passwd)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(aeadDER{
+		Type:      pemKey.Type,
+		DEKInfo:   pemKey.Headers["DEK-Info"],
+		Data:      pemKey.Bytes,
+		KDFInfo:   pemKey.Headers["KDF-Info"],
+		KDFPepper: pemKey.Headers["KDF-Pepper"],
+	})
+}
+
+// DERToPriv decrypts and sets the private key from its binary form as
+// returned by PrivToDER.
+func (i *IdentityKey) DERToPriv(der []byte, passwd []byte) error {
+	var blob aeadDER
+
+	rest, err := asn1.Unmarshal(der, &blob)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errors.New("trailing data after DER private key")
+	}
+
+	pemBlock := &pem.Block{
+		Type: blob.Type,
+		Headers: map[string]string{
+			"Proc-Type": "4,ENCRYPTED",
+			"DEK-Info":  blob.DEKInfo,
+		},
+		Bytes: blob.Data,
+	}
+	if len(blob.KDFInfo) > 0 {
+		pemBlock.Headers["KDF-Info"] = blob.KDFInfo
+	}
+	if len(blob.KDFPepper) > 0 {
+		pemBlock.Headers["KDF-Pepper"] = blob.KDFPepper
+	}
+
+	return i.pemToPriv(pemBlock, passwd)
+}
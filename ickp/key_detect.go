@@ -0,0 +1,94 @@
+package ickp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// pemHeaderMax is how much of a file IsICPrivateKey looks at, the BEGIN line
+// and the encryption headers are way shorter.
+const pemHeaderMax = 4096
+
+// KeyTypeUnknown is the key type IsICPrivateKey returns for AEAD encrypted
+// PKCS #8 keys, their type is only known once decrypted.
+const KeyTypeUnknown = -1
+
+// IsICPrivateKey tells whether the file at path looks like an ic private key
+// and of which key type, only the PEM BEGIN line and headers are read, no
+// passphrase is needed. A file that is not an ic key is not an error.
+// AEAD encrypted PKCS #8 keys count as ic keys, of type KeyTypeUnknown.
+func IsICPrivateKey(path string) (bool, int, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return false, 0, err
+	}
+	defer fd.Close()
+
+	rd := bufio.NewReader(io.LimitReader(fd, pemHeaderMax))
+
+	keyType, begun := KeyTypeUnknown, false
+	procType, dekInfo := false, false
+	for {
+		line, err := rd.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+
+		switch {
+		case !begun && len(line) == 0:
+			// blank lines before the PEM block.
+		case !begun:
+			if string(line) != "-----BEGIN "+PEMHDR_PKCS8+"-----" {
+				keyType = pemKeyType(string(line))
+				if keyType < 0 {
+					return false, 0, nil
+				}
+			}
+			begun = true
+		case bytes.Equal(line, []byte("Proc-Type: 4,ENCRYPTED")):
+			procType = true
+		case bytes.HasPrefix(line, []byte("DEK-Info: AES-256-GCM,")):
+			dekInfo = true
+		case bytes.HasPrefix(line, []byte("KDF-Info: ")),
+			bytes.HasPrefix(line, []byte("KDF-Pepper: ")),
+			bytes.HasPrefix(line, []byte("AAD-Label: ")):
+		default:
+			// end of the headers.
+			if procType && dekInfo {
+				return true, keyType, nil
+			}
+			return false, 0, nil
+		}
+
+		if err == io.EOF {
+			return false, 0, nil
+		}
+		if err != nil {
+			return false, 0, err
+		}
+	}
+}
+
+// pemKeyType returns the ic key type of a PEM BEGIN line, -1 if it is not one
+// of ours.
+func pemKeyType(line string) int {
+	if !strings.HasPrefix(line, "-----BEGIN ") || !strings.HasSuffix(line, "-----") {
+		return -1
+	}
+	return pemBlockKeyType(strings.TrimSuffix(strings.TrimPrefix(line, "-----BEGIN "), "-----"))
+}
+
+// pemBlockKeyType returns the ic key type of a PEM block type, -1 if it is
+// not one of ours. PKCS #8 blocks don't tell their key type, they are -1 too.
+func pemBlockKeyType(blockType string) int {
+	switch blockType {
+	case PEMHDR_RSA:
+		return KEYRSA
+	case PEMHDR_ECDSA, PEMHDR_EC:
+		return KEYECDSA
+	case PEMHDR_25519:
+		return KEYEC25519
+	}
+	return -1
+}
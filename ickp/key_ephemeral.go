@@ -0,0 +1,18 @@
+package ickp
+
+// NewEphemeralIdentity generates an identity key that is only meant to live
+// in memory, e.g. for a one-off request signer. The returned cleanup
+// function zeroes all the secrets (see Destroy), always defer it right away:
+//
+//	i, cleanup, err := NewEphemeralIdentity(KEYEC25519)
+//	if err != nil {
+//		return err
+//	}
+//	defer cleanup()
+func NewEphemeralIdentity(keytype int) (*IdentityKey, func(), error) {
+	i, err := NewIdentityKey(keytype)
+	if err != nil {
+		return nil, nil, err
+	}
+	return i, i.Destroy, nil
+}
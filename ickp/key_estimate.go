@@ -0,0 +1,67 @@
+package ickp
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// genDurations are rough NewIdentityKey durations on a current desktop, RSA
+// (4096 bits) varies a lot from one key to the other: the prime search is
+// random.
+var genDurations = map[int]time.Duration{
+	KEYRSA:     time.Second,
+	KEYECDSA:   20 * time.Microsecond,
+	KEYEC25519: 25 * time.Microsecond,
+}
+
+const (
+	// calibrationRounds Ed25519 key derivations take about calibrationRef on
+	// the desktop genDurations were measured on.
+	calibrationRounds = 64
+	calibrationRef    = 2 * time.Millisecond
+	// the RNG health check is a 1KB read and two passes over it.
+	rngHealthDuration = 10 * time.Microsecond
+)
+
+var (
+	calibrationOnce  sync.Once
+	calibrationRatio float64
+)
+
+// calibrate times a few Ed25519 key derivations to scale genDurations to
+// this machine, the ratio is kept within [0.25, 20].
+func calibrate() {
+	seed := make([]byte, ed25519.SeedSize)
+	start := time.Now()
+	for k := 0; k < calibrationRounds; k++ {
+		seed[0] = byte(k)
+		ed25519.NewKeyFromSeed(seed)
+	}
+
+	calibrationRatio = float64(time.Since(start)) / float64(calibrationRef)
+	switch {
+	case calibrationRatio < 0.25:
+		calibrationRatio = 0.25
+	case calibrationRatio > 20:
+		calibrationRatio = 20
+	}
+}
+
+// EstimateGenDuration returns a rough estimate of how long
+// NewIdentityKeyWithParams(keytype, params) takes, i.e. for a CLI to decide
+// whether to show a spinner. The first call runs a short (~ms) benchmark to
+// scale the estimates to the machine. Unknown key types give 0.
+func EstimateGenDuration(keytype int, params KeyParams) time.Duration {
+	base, ok := genDurations[keytype]
+	if !ok {
+		return 0
+	}
+	if params.RNGHealthCheck {
+		base += rngHealthDuration
+	}
+
+	calibrationOnce.Do(calibrate)
+	return time.Duration(float64(base) * calibrationRatio)
+}
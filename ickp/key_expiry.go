@@ -0,0 +1,91 @@
+package ickp
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrKeyExpired is returned by the private key operations once the
+// WithExpiry duration has elapsed.
+var ErrKeyExpired = errors.New("identity key expired")
+
+// keyExpiry is the WithExpiry state, guarded by the IdentityKey lock: the
+// timer takes it for writing, so it never wipes the key under a private key
+// operation.
+type keyExpiry struct {
+	timer   *time.Timer
+	expired bool
+}
+
+// WithExpiry zeroes the private key material d from now, afterwards the
+// private key operations fail with ErrKeyExpired. Renew pushes the deadline
+// back. It returns the key itself, to be used right after loading or
+// generating it, like WithRateLimit.
+// Signers taken before the expiry (ToSSHSigner, ToTLSCertificate...) go
+// through the key, they fail with ErrKeyExpired as well.
+func (i *IdentityKey) WithExpiry(d time.Duration) *IdentityKey {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.expiry != nil {
+		i.expiry.timer.Stop()
+	}
+
+	e := new(keyExpiry)
+	e.timer = time.AfterFunc(d, func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		// replaced by another WithExpiry while we waited for the lock.
+		if i.expiry != e {
+			return
+		}
+		i.wipe()
+		e.expired = true
+	})
+	i.expiry = e
+	return i
+}
+
+// Renew sets the WithExpiry deadline to d from now, it fails with
+// ErrKeyExpired when the key has expired already.
+func (i *IdentityKey) Renew(d time.Duration) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	e := i.expiry
+	if e == nil {
+		return errors.New("identity key has no expiry")
+	}
+
+	// Stop fails when the timer fired, and is only waiting for the lock.
+	if e.expired || !e.timer.Stop() {
+		return ErrKeyExpired
+	}
+	e.timer.Reset(d)
+	return nil
+}
+
+// lockPrivate checks the private key is still usable and keeps it so, from
+// Destroy, CompactRSA or the expiry timer, until unlock is called. Any
+// number of private key operations can hold it together.
+func (i *IdentityKey) lockPrivate() (unlock func(), err error) {
+	i.mu.RLock()
+	if i.destroyed {
+		i.mu.RUnlock()
+		return nil, ErrDestroyed
+	}
+	if i.expiry != nil && i.expiry.expired {
+		i.mu.RUnlock()
+		return nil, ErrKeyExpired
+	}
+	return i.mu.RUnlock, nil
+}
+
+// hasPrivate reports whether the private key is still usable: neither
+// destroyed nor expired.
+func (i *IdentityKey) hasPrivate() bool {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return false
+	}
+	unlock()
+	return true
+}
@@ -0,0 +1,38 @@
+// +build !windows
+
+package ickp
+
+import (
+	"os"
+)
+
+// createPrivFile creates (or truncates) the private key file, readable and
+// writable by its owner only.
+func createPrivFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mode is not applied when the file exists already.
+	err = f.Chmod(0600)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// checkPrivFile fails with ErrUnsafePermissions when the private key file
+// is accessible to its group or others.
+func checkPrivFile(f *os.File) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Mode().Perm()&0077 != 0 {
+		return ErrUnsafePermissions
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// +build windows
+
+package ickp
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// createPrivFile creates (or truncates) the private key file.
+// On Windows the unix file mode is meaningless, so we set a protected DACL
+// granting access to the current user only, before anything is written.
+func createPrivFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = restrictToCurrentUser(path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// checkPrivFile does nothing on Windows, what matters is the DACL.
+func checkPrivFile(f *os.File) error {
+	return nil
+}
+
+func restrictToCurrentUser(path string) error {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return err
+	}
+
+	acl, err := windows.ACLFromEntries([]windows.EXPLICIT_ACCESS{
+		{
+			AccessPermissions: windows.GENERIC_ALL,
+			AccessMode:        windows.GRANT_ACCESS,
+			Inheritance:       windows.NO_INHERITANCE,
+			Trustee: windows.TRUSTEE{
+				TrusteeForm:  windows.TRUSTEE_IS_SID,
+				TrusteeType:  windows.TRUSTEE_IS_USER,
+				TrusteeValue: windows.TrusteeValueFromSID(user.User.Sid),
+			},
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	// PROTECTED: do not inherit the (usually broader) parent directory ACEs.
+	return windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION,
+		nil, nil, acl, nil)
+}
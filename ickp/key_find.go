@@ -0,0 +1,82 @@
+package ickp
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// ErrAADBound is returned for the key files written with PrivToPKIXWithAAD
+// by the directory operations (RekeyDir, FindByPassphrase): they can't be
+// opened without their associated data.
+var ErrAADBound = errors.New("key file is bound to associated data")
+
+// FindByPassphrase returns the prefixes (private key file paths) of the ic
+// keys found in dir (not recursing) that passwd unlocks, to recover which
+// key a passphrase belongs to. Only the PEM block is decrypted, the key is
+// not parsed, and the plaintext is wiped right away.
+// Every key file goes through the same key derivation and AEAD open whether
+// it matches or not, and the search does not stop at the first match, so the
+// time taken only depends on the files and their KDF parameters. Key files
+// that can't be read, or checked (ErrAADBound), are skipped, the first such
+// error is returned along with the matches.
+func FindByPassphrase(dir string, passwd []byte) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	var firstErr error
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		isKey, _, err := IsICPrivateKey(path)
+		if err != nil || !isKey {
+			continue
+		}
+
+		keyDer, _, err := decryptKeyFile(path, passwd)
+		switch err {
+		case nil:
+			wipeBytes(keyDer)
+			found = append(found, path)
+		case ErrBadPassphrase, ErrNotFIPSApproved:
+		default:
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return found, firstErr
+}
+
+// decryptKeyFile reads the private key file at path and decrypts its PEM
+// block, the key DER is not parsed.
+func decryptKeyFile(path string, passwd []byte) ([]byte, *pem.Block, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(normalizeNewlines(buf))
+	if block == nil {
+		return nil, nil, fmt.Errorf("no PEM found")
+	}
+
+	// we don't know the associated data, only the caller does.
+	if _, ok := block.Headers["AAD-Label"]; ok {
+		return nil, nil, ErrAADBound
+	}
+
+	keyDer, err := AEADDecryptPEMBlock(block, passwd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyDer, block, nil
+}
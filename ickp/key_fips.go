@@ -0,0 +1,120 @@
+package ickp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrNotFIPSApproved is returned, once EnableFIPSMode was called, when an
+// algorithm, hash, KDF or randomness source that is not FIPS approved is
+// asked for.
+var ErrNotFIPSApproved = errors.New("algorithm not FIPS approved")
+
+const (
+	kdfPBKDF2 = "pbkdf2-sha3-256"
+
+	// SP 800-132 wants at least 128 bits of salt, and as many iterations as
+	// users can bear, this is the OWASP recommendation for PBKDF2-SHA256.
+	fipsPBKDF2Iterations = 600000
+	minPBKDF2Iterations  = 1000
+	maxPBKDF2Iterations  = 10000000
+	fipsPBKDF2SaltSize   = 16
+
+	fipsMinRSABits = 2048
+)
+
+var fipsMode int32
+
+// EnableFIPSMode restricts the package, for the rest of the process, to FIPS
+// approved algorithms:
+//   - new keys are RSA (2048 bits or more) or ECDSA (P-256, P-384, P-521),
+//     EC25519 and registered custom key types are refused, for generation
+//     and signing, they are only validated along with the Go crypto module,
+//   - keys are generated and signatures made with crypto/rand only,
+//   - SignMessage only takes SHA-2 and SHA-3 hashes,
+//   - private keys are written with PBKDF2-SHA3-256 ("KDF-Info:
+//     pbkdf2-sha3-256,<iterations>"), Argon2id and the historical 8 bytes
+//     salt PBKDF2 blocks can't be read nor written, nor can the key files
+//     digests (WriteKeyFilesWithDigest) which use Argon2id.
+//
+// Everything refused fails with ErrNotFIPSApproved.
+// This is a policy switch only: it does not make this package, nor the
+// binary it is built in, FIPS 140 validated. That takes a validated
+// cryptographic module (i.e. a Go toolchain built with GOFIPS140) and its
+// deployment as validated, the x/crypto code used here (SHA-3, PBKDF2) is
+// not part of any.
+func EnableFIPSMode() {
+	atomic.StoreInt32(&fipsMode, 1)
+}
+
+// FIPSMode reports whether EnableFIPSMode was called.
+func FIPSMode() bool {
+	return atomic.LoadInt32(&fipsMode) == 1
+}
+
+// disableFIPSMode is for ResetDefaults, there is no going back otherwise.
+func disableFIPSMode() {
+	atomic.StoreInt32(&fipsMode, 0)
+}
+
+// fipsCheckRand refuses any randomness source but crypto/rand in FIPS mode.
+func fipsCheckRand(rnd io.Reader) error {
+	if FIPSMode() && rnd != nil && rnd != rand.Reader {
+		return ErrNotFIPSApproved
+	}
+	return nil
+}
+
+// fipsCheckKeyType refuses the key types that are not approved in FIPS mode.
+func fipsCheckKeyType(keyType int) error {
+	if FIPSMode() && keyType != KEYRSA && keyType != KEYECDSA {
+		return ErrNotFIPSApproved
+	}
+	return nil
+}
+
+// fipsCheckKey is fipsCheckKeyType also checking the RSA key size, for keys
+// loaded from elsewhere.
+func (i *IdentityKey) fipsCheckKey() error {
+	err := fipsCheckKeyType(i.keyType)
+	if err != nil {
+		return err
+	}
+	if FIPSMode() && i.keyType == KEYRSA && i.rsa != nil && i.rsa.N.BitLen() < fipsMinRSABits {
+		return ErrNotFIPSApproved
+	}
+	return nil
+}
+
+// fipsCheckHash refuses anything but SHA-2 and SHA-3 in FIPS mode.
+func fipsCheckHash(hash crypto.Hash) error {
+	if !FIPSMode() {
+		return nil
+	}
+	switch hash {
+	case crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512,
+		crypto.SHA512_224, crypto.SHA512_256,
+		crypto.SHA3_224, crypto.SHA3_256, crypto.SHA3_384, crypto.SHA3_512:
+		return nil
+	}
+	return ErrNotFIPSApproved
+}
+
+// parsePBKDF2Info parses the "KDF-Info" header: pbkdf2-sha3-256,<iterations>
+func parsePBKDF2Info(kdf string) (iter int, ok bool) {
+	kdfData := strings.Split(kdf, ",")
+	if len(kdfData) != 2 || kdfData[0] != kdfPBKDF2 {
+		return 0, false
+	}
+
+	iter, err := strconv.Atoi(kdfData[1])
+	if err != nil || iter < minPBKDF2Iterations || iter > maxPBKDF2Iterations {
+		return 0, false
+	}
+	return iter, true
+}
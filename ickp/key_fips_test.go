@@ -0,0 +1,94 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestFIPSMode(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+
+	// made before, with what FIPS mode refuses.
+	ed, _ := NewIdentityKey(KEYEC25519)
+	argonBlock, err := AEADEncryptPEMBlock(rand.Reader, "TEST", []byte("data"), []byte("pw"))
+	if err != nil {
+		t.Fatalf("AEADEncryptPEMBlock() error: %v\n", err)
+	}
+
+	EnableFIPSMode()
+	if !FIPSMode() {
+		t.Fatalf("FIPSMode() is false\n")
+	}
+
+	_, err = NewIdentityKey(KEYEC25519)
+	if err != ErrNotFIPSApproved {
+		t.Logf("NewIdentityKey(KEYEC25519) error: %v\n", err)
+		t.Fail()
+	}
+	_, err = NewIdentityKeyWithParams(KEYECDSA, KeyParams{Rand: bytes.NewReader(make([]byte, 1024))})
+	if err != ErrNotFIPSApproved {
+		t.Logf("NewIdentityKeyWithParams(custom Rand) error: %v\n", err)
+		t.Fail()
+	}
+	_, err = ed.SignWithContext("test", rand.Reader, []byte("msg"))
+	if err != ErrNotFIPSApproved {
+		t.Logf("SignWithContext(EC25519) error: %v\n", err)
+		t.Fail()
+	}
+
+	i, err := NewIdentityKey(KEYECDSA)
+	if err != nil {
+		t.Fatalf("NewIdentityKey(KEYECDSA) error: %v\n", err)
+	}
+	_, err = i.SignMessage([]byte("msg"), crypto.SHA1)
+	if err != ErrNotFIPSApproved {
+		t.Logf("SignMessage(SHA1) error: %v\n", err)
+		t.Fail()
+	}
+	sig, err := i.SignMessage([]byte("msg"), crypto.SHA384)
+	if err != nil {
+		t.Fatalf("SignMessage(SHA384) error: %v\n", err)
+	}
+	err = i.VerifyMessage([]byte("msg"), sig, crypto.SHA384)
+	if err != nil {
+		t.Logf("VerifyMessage() error: %v\n", err)
+		t.Fail()
+	}
+
+	_, err = AEADDecryptPEMBlock(argonBlock, []byte("pw"))
+	if err != ErrNotFIPSApproved {
+		t.Logf("AEADDecryptPEMBlock(argon2id) error: %v\n", err)
+		t.Fail()
+	}
+	_, err = AEADEncryptPEMBlockWithKDF(rand.Reader, "TEST", []byte("data"), []byte("pw"), DefaultKDFParams)
+	if err != ErrNotFIPSApproved {
+		t.Logf("AEADEncryptPEMBlockWithKDF() error: %v\n", err)
+		t.Fail()
+	}
+
+	block, err := AEADEncryptPEMBlock(rand.Reader, "TEST", []byte("data"), []byte("pw"))
+	if err != nil {
+		t.Fatalf("AEADEncryptPEMBlock() error: %v\n", err)
+	}
+	if !strings.HasPrefix(block.Headers["KDF-Info"], kdfPBKDF2+",") {
+		t.Logf("KDF-Info: %s\n", block.Headers["KDF-Info"])
+		t.Fail()
+	}
+	_, err = AEADDecryptPEMBlock(block, []byte("wrong"))
+	if err != ErrBadPassphrase {
+		t.Logf("AEADDecryptPEMBlock(wrong) error: %v\n", err)
+		t.Fail()
+	}
+
+	// PBKDF2 blocks are still read outside of FIPS mode.
+	ResetDefaults()
+	data, err := AEADDecryptPEMBlock(block, []byte("pw"))
+	if err != nil || string(data) != "data" {
+		t.Logf("AEADDecryptPEMBlock(pbkdf2) error: %v\n", err)
+		t.Fail()
+	}
+}
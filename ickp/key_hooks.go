@@ -0,0 +1,43 @@
+package ickp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// KeyHook receives notifications about identity key operations, e.g. to
+// export metrics. Hooks only ever get the key fingerprint and algorithm
+// name (i.e. "ic-rsa"), never any private key material.
+type KeyHook interface {
+	OnSign(fingerprint, algorithm string, elapsed time.Duration)
+	OnVerify(fingerprint, algorithm string, elapsed time.Duration)
+	OnKeyLoad(fingerprint, algorithm string, elapsed time.Duration)
+}
+
+// hookHolder is what we store in the atomic.Value, it must always hold the
+// same concrete type.
+type hookHolder struct {
+	hook KeyHook
+}
+
+var keyHook atomic.Value
+
+// SetKeyHook registers the package wide KeyHook, nil removes it.
+func SetKeyHook(h KeyHook) {
+	keyHook.Store(hookHolder{h})
+}
+
+// getKeyHook returns the registered KeyHook or nil.
+func getKeyHook() KeyHook {
+	holder, ok := keyHook.Load().(hookHolder)
+	if !ok {
+		return nil
+	}
+	return holder.hook
+}
+
+// hookArgs returns the fingerprint and algorithm name passed to the hooks.
+func (i *IdentityKey) hookArgs() (fp, alg string) {
+	fp, _ = i.Fingerprint()
+	return fp, i.Type()
+}
@@ -0,0 +1,63 @@
+package ickp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"sort"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// privateKeyIDLabel separates private key IDs from any other hash of the key.
+const privateKeyIDLabel = "ic-private-key-id"
+
+// PrivateKeyID returns a stable identifier of the private key, the hex
+// SHA3-256 of its canonical secret: the sorted primes for RSA (the private
+// exponent has several valid values), the scalar for ECDSA and the seed for
+// EC25519. It is the same whatever encoding (PKCS#1, PKCS#8, OpenSSH...) the
+// key was loaded from, so stores can spot the same secret imported twice.
+// It does not reveal the key, compare IDs with FingerprintEqual.
+func (i *IdentityKey) PrivateKeyID() (string, error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if !i.initialized() {
+		return "", ErrUninitialized
+	}
+
+	h := sha3.New256()
+	h.Write([]byte(privateKeyIDLabel))
+
+	var parts [][]byte
+	switch i.keyType {
+	case KEYRSA:
+		primes := make([]*big.Int, len(i.rsa.Primes))
+		copy(primes, i.rsa.Primes)
+		sort.Slice(primes, func(a, b int) bool { return primes[a].Cmp(primes[b]) < 0 })
+		size := (i.rsa.N.BitLen() + 7) / 8
+		for _, p := range primes {
+			parts = append(parts, p.FillBytes(make([]byte, size)))
+		}
+	case KEYECDSA:
+		params := i.ecdsa.Curve.Params()
+		parts = append(parts, []byte(params.Name), i.ecdsa.D.FillBytes(make([]byte, (params.BitSize+7)/8)))
+	case KEYEC25519:
+		parts = append(parts, i.ec25519.Priv.Seed())
+	}
+
+	var tag [8]byte
+	binary.BigEndian.PutUint32(tag[:4], uint32(i.keyType))
+	binary.BigEndian.PutUint32(tag[4:], uint32(len(parts)))
+	h.Write(tag[:])
+	for _, part := range parts {
+		binary.BigEndian.PutUint32(tag[:4], uint32(len(part)))
+		h.Write(tag[:4])
+		h.Write(part)
+		wipeBytes(part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
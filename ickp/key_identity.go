@@ -1,24 +1,35 @@
 package ickp
 
 import (
+	"bytes"
+	"compress/zlib"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/nu7hatch/gouuid"
 	"github.com/unix4fun/ic/icutl"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/time/rate"
 	//"io/ioutil"
 	//"strings"
-	//"bytes"
 )
 
 const (
@@ -30,16 +41,53 @@ const (
 	KeyECDSAStr   = "ic-ecdsa"
 	KeyEC25519Str = "ic-25519"
 
+	// KeyEC25519AliasStr is accepted on input for EC25519 keys, output always
+	// uses KeyEC25519Str.
+	KeyEC25519AliasStr = "ic-ec25519"
+
+	// PEM block types of the private keys, PrivToPKIX writes these.
 	PEMHDR_RSA   = "RSA PRIVATE KEY"
 	PEMHDR_ECDSA = "ECDSA PRIVATE KEY"
 	PEMHDR_25519 = "EC25519 PRIVATE KEY"
+
+	// Standard PEM block types, only accepted on load: SEC 1 (RFC 5915)
+	// ECDSA keys and PKCS #8 keys of any of the built-in types.
+	PEMHDR_EC    = "EC PRIVATE KEY"
+	PEMHDR_PKCS8 = "PRIVATE KEY"
+
+	// PubFPComment prefixes the optional fingerprint line of a public key file.
+	PubFPComment = "# fingerprint:"
+
+	// Public key line payload versions.
+	//
+	// The base64 field of a public key line decodes to:
+	//   version (1 byte) || payload
+	// version 0: payload is the zlib compressed public key binary form
+	//            (PKIX DER for RSA/ECDSA, ASN.1 octet string for EC25519).
+	// version 1: payload is the zlib compressed ASN.1 of the public key
+	//            binary form, key=value metadata and a self-signature of
+	//            them, see PubToPKIXWithMetadata.
+	//
+	// Lines written before versioning have no version byte, their payload is
+	// a bare zlib stream which always starts with 0x78 (deflate, 32K window),
+	// so they are read as version 0. New versions must never use 0x78.
+	PubLineVersion0 = 0x00
+	PubLineVersion1 = 0x01
+	pubLineLegacy   = 0x78
+
+	// pubLineZlibLevel is the compression of version 0 payloads, with no
+	// preset dictionary. It must never change: the public line of a key is
+	// expected to be byte identical forever (reproducible builds), see the
+	// golden test in testdata/publine.golden.
+	pubLineZlibLevel = zlib.BestCompression
 )
 
 var (
 	S2K = map[string]int{
-		KeyRSAStr:     KEYRSA,
-		KeyECDSAStr:   KEYECDSA,
-		KeyEC25519Str: KEYEC25519,
+		KeyRSAStr:          KEYRSA,
+		KeyECDSAStr:        KEYECDSA,
+		KeyEC25519Str:      KEYEC25519,
+		KeyEC25519AliasStr: KEYEC25519,
 	}
 
 	K2S = map[int]string{
@@ -47,23 +95,76 @@ var (
 		KEYECDSA:   KeyECDSAStr,
 		KEYEC25519: KeyEC25519Str,
 	}
+
+	// ErrDestroyed is returned when using the private part of a destroyed key.
+	ErrDestroyed = errors.New("identity key destroyed")
+
+	// ErrUninitialized is returned when using an identity key holding no
+	// key, i.e. new(IdentityKey).
+	ErrUninitialized = errors.New("uninitialized identity key")
+
+	// ErrAlgorithmNotAllowed is returned when loading a key whose type is not
+	// in LoadParams.AllowedTypes.
+	ErrAlgorithmNotAllowed = errors.New("key algorithm not allowed")
+
+	// ErrPublicPrivateMismatch is returned when loading an EC25519 key whose
+	// stored public key is not the one of its private seed.
+	ErrPublicPrivateMismatch = errors.New("public key does not match the private key")
+
+	// ErrUnsafePermissions is returned, with LoadParams.StrictPermissions,
+	// when the private key file is accessible to its group or others.
+	ErrUnsafePermissions = errors.New("private key file permissions are too open")
 )
 
+// IdentityKey is an identity key pair. It is safe for concurrent use once
+// set up: any number of goroutines can sign, verify, export it... at the
+// same time. Loading or generating it, WithRateLimit and WithExpiry must be
+// done before it is shared. Destroy, CompactRSA and the WithExpiry timer
+// wait for the private key operations in progress.
 type IdentityKey struct {
-	keyType  int
-	keyOwner *uuid.UUID
-	rsa      *rsa.PrivateKey
-	ecdsa    *ecdsa.PrivateKey
-	ec25519  *Ed25519PrivateKey
+	// mu guards the private key material against wiping (Destroy, expiry)
+	// and changes (CompactRSA): private key operations hold it for reading,
+	// see lockPrivate.
+	mu sync.RWMutex
+
+	keyType   int
+	keyOwner  *uuid.UUID
+	rsa       *rsa.PrivateKey
+	ecdsa     *ecdsa.PrivateKey
+	ec25519   *Ed25519PrivateKey
+	custom    crypto.Signer // RegisterKeyType ones
+	destroyed bool
+	limiter   *rate.Limiter
+	expiry    *keyExpiry
 }
 
 type IdentityPublicKey struct {
-	KeyType int
-	//	KeyOwner string
-	KeyBin []byte
+	KeyType  int
+	KeyOwner string
+	KeyBin   []byte
+	// Metadata is what a version 1 public key line carries, its
+	// self-signature was checked when parsing. Nil otherwise.
+	Metadata map[string]string
+}
+
+// initialized reports whether the identity key holds a key of its type, the
+// zero value has the KEYRSA type but no key.
+func (i *IdentityKey) initialized() bool {
+	switch i.keyType {
+	case KEYRSA:
+		return i.rsa != nil
+	case KEYECDSA:
+		return i.ecdsa != nil
+	case KEYEC25519:
+		return i.ec25519 != nil
+	}
+	return i.custom != nil && customKeyType(i.keyType) != nil
 }
 
 func (i *IdentityKey) Type() string {
+	if !i.initialized() {
+		return ""
+	}
 	str, ok := K2S[i.keyType]
 	if ok {
 		return str
@@ -71,133 +172,502 @@ func (i *IdentityKey) Type() string {
 	return ""
 }
 
-func (i *IdentityKey) PubToPKIX(wr io.Writer) error {
-
-	var err error
-	var keyBin, keyHdr []byte
+// pubKeyBin returns the DER/ASN.1 encoded public part of the identity key,
+// this is what gets compressed/encoded into the public key file and what the
+// fingerprint is computed over.
+func (i *IdentityKey) pubKeyBin() (keyBin []byte, err error) {
+	if !i.initialized() {
+		return nil, ErrUninitialized
+	}
 
 	switch i.keyType {
 	case KEYRSA:
-		keyBin, err = x509.MarshalPKIXPublicKey(i.rsa.Public())
+		_, keyBin, err = marshalPubKeyBin(i.rsa.Public())
 	case KEYECDSA:
-		keyBin, err = x509.MarshalPKIXPublicKey(i.ecdsa.Public())
+		_, keyBin, err = marshalPubKeyBin(i.ecdsa.Public())
 	case KEYEC25519:
-		keyBin, err = asn1.Marshal(i.ec25519.Pub[:])
+		_, keyBin, err = marshalPubKeyBin(i.ec25519.Pub)
 	default:
-		return errors.New("invalid key type")
+		// initialized() made sure it is a registered type.
+		keyBin, err = customKeyType(i.keyType).MarshalPublic(i.custom.Public())
+	}
+	return
+}
+
+// pubFingerprint returns the hex encoded SHA3-256 hash of the public key
+// binary form.
+func pubFingerprint(keyBin []byte) (string, error) {
+	fp, err := icutl.HashSHA3Data(keyBin)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(fp), nil
+}
+
+// FingerprintEqual compares two hex encoded fingerprints in constant time.
+// Both are decoded and hashed first so that the comparison time does not
+// depend on their length either, invalid hex never matches.
+func FingerprintEqual(a, b string) bool {
+	aBin, errA := hex.DecodeString(a)
+	bBin, errB := hex.DecodeString(b)
+
+	aHash, errHA := icutl.HashSHA3Data(aBin)
+	bHash, errHB := icutl.HashSHA3Data(bBin)
+	if errHA != nil || errHB != nil {
+		return false
 	}
 
+	eq := subtle.ConstantTimeCompare(aHash, bHash)
+	return eq == 1 && errA == nil && errB == nil
+}
+
+// Fingerprint returns the hex encoded SHA3-256 hash of the public key.
+func (i *IdentityKey) Fingerprint() (string, error) {
+	keyBin, err := i.pubKeyBin()
 	if err != nil {
-		return err
+		return "", err
 	}
-	b64comp, err := icutl.CompressData(keyBin)
+	return pubFingerprint(keyBin)
+}
+
+func (i *IdentityKey) PubToPKIX(wr io.Writer) error {
+	return i.pubToPKIX(wr, false)
+}
+
+// PubToPKIXWithFingerprint writes the public key line preceded by a
+// "# fingerprint: <fp>" comment line, making the public file self-identifying.
+// PKIXToPub checks the stated fingerprint against the actual key.
+func (i *IdentityKey) PubToPKIXWithFingerprint(wr io.Writer) error {
+	return i.pubToPKIX(wr, true)
+}
+
+func (i *IdentityKey) pubToPKIX(wr io.Writer, withFP bool) error {
+	keyBin, err := i.pubKeyBin()
 	if err != nil {
 		return err
 	}
-	b64pub := icutl.B64EncodeData(b64comp)
+	if i.keyOwner == nil {
+		return ErrUninitialized
+	}
+	return writePublicLine(wr, i.keyType, keyBin, i.keyOwner.String(), withFP)
+}
 
-	tmphdr, ok := K2S[i.keyType]
+// writePublicLine writes the "ic-*" public key line for the given key type,
+// binary form and owner, optionally preceded by the fingerprint comment line.
+func writePublicLine(wr io.Writer, keyType int, keyBin []byte, owner string, withFP bool) error {
+	return writePublicLinePayload(wr, keyType, keyBin, PubLineVersion0, keyBin, owner, withFP)
+}
+
+// writePublicLinePayload writes the public key line with the version and
+// (uncompressed) payload, keyBin is for the fingerprint.
+func writePublicLinePayload(wr io.Writer, keyType int, keyBin []byte, version byte, payload []byte, owner string, withFP bool) error {
+	if len(keyBin) == 0 {
+		return errors.New("invalid public key")
+	}
+
+	keyHdr, ok := K2S[keyType]
 	if !ok {
 		return errors.New("invalid key type")
 	}
-	keyHdr = []byte(tmphdr) //[]byte("ic-rsa")
 
-	// let's write our stuff...
-	// XXX error checking...
-	wr.Write(keyHdr)
-	wr.Write([]byte(" "))
-	wr.Write(b64pub)
-	wr.Write([]byte(" "))
-	wr.Write([]byte(i.keyOwner.String()))
-
-	// we're good
-	return nil
-}
+	if withFP {
+		fp, err := pubFingerprint(keyBin)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(wr, "%s %s\n", PubFPComment, fp)
+		if err != nil {
+			return err
+		}
+	}
 
-func (i *IdentityKey) PKIXToPub(rd io.Reader) (err error) {
-	pbuf, err := ioutil.ReadAll(rd)
+	_, err := io.WriteString(wr, keyHdr+" ")
 	if err != nil {
 		return err
 	}
 
-	pstrArr := strings.Split(string(pbuf), " ")
-	if len(pstrArr) != 3 {
-		return errors.New("invalid pubkey file")
+	// b64(version || zlib(payload)) streamed right into wr.
+	b64 := base64.NewEncoder(base64.StdEncoding, wr)
+	_, err = b64.Write([]byte{version})
+	if err != nil {
+		return err
+	}
+	zbuf, err := zlib.NewWriterLevel(b64, pubLineZlibLevel)
+	if err != nil {
+		return err
 	}
+	_, err = zbuf.Write(payload)
+	if err != nil {
+		return err
+	}
+	err = zbuf.Close()
+	if err != nil {
+		return err
+	}
+	err = b64.Close()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(wr, " "+owner)
+	return err
+}
+
+// ErrDecompressTooLarge is returned when a public key payload decompresses
+// to more than MaxPublicKeySize bytes.
+var ErrDecompressTooLarge = icutl.ErrDecompressTooLarge
 
-	if len(pstrArr[0]) > 0 && len(pstrArr[1]) > 0 && len(pstrArr[2]) > 0 {
+// decodePubPayload reads the version byte of the decoded public key line
+// payload and returns the public key binary form, and the signed metadata
+// of version 1 payloads (still to be verified).
+func decodePubPayload(payload []byte) ([]byte, *metadataPayload, error) {
+	if len(payload) == 0 {
+		return nil, nil, errors.New("empty public key payload")
+	}
 
-		// sanity checks before using the splits...
-		keyType, ok := S2K[pstrArr[0]]
-		if !ok || keyType != i.keyType {
-			return errors.New("keytype confusion or invalid")
+	switch payload[0] {
+	case PubLineVersion0:
+		keyBin, err := decompressPub(payload[1:])
+		return keyBin, nil, err
+	case PubLineVersion1:
+		meta, err := decodeMetadataPayload(payload[1:])
+		if err != nil {
+			return nil, nil, err
 		}
+		return meta.KeyBin, meta, nil
+	case pubLineLegacy, gzipMagic[0]:
+		keyBin, err := decompressPub(payload)
+		return keyBin, nil, err
+	}
+
+	return nil, nil, fmt.Errorf("unsupported public key version %d", payload[0])
+}
+
+// gzipMagic starts gzip (RFC 1952) data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompressPub inflates the public key binary form. We always write zlib
+// but also accept gzip, which some transports wrap what they carry with.
+func decompressPub(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return icutl.GunzipDataLimit(data, MaxPublicKeySize)
+	case len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		// zlib header: deflate method and check bits (RFC 1950).
+		return icutl.DecompressDataLimit(data, MaxPublicKeySize)
+	}
+	return nil, errors.New("public key payload is neither zlib nor gzip compressed")
+}
+
+// ErrBadPublicLine is what every public key line parsing error is,
+// errors.Is(err, ErrBadPublicLine) holds for the *ParseError returned.
+var ErrBadPublicLine = errors.New("bad public key line")
+
+// ParseError tells where a public key line failed to parse.
+type ParseError struct {
+	// Line is the 1-based line number in the parsed content, 0 when the
+	// error is not about a given line.
+	Line int
+	// Field is the faulty part: "line", "type", "payload", "owner" or
+	// "fingerprint" (the "# fingerprint:" comment).
+	Field string
+	// Offset is the byte offset of Field within the line.
+	Offset int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v: line %d, offset %d (%s): %v", ErrBadPublicLine, e.Line, e.Offset, e.Field, e.Err)
+}
+
+// Unwrap returns the cause, i.e. ErrDecompressTooLarge.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrBadPublicLine
+}
 
-		// uuid parse
-		if i.keyOwner.String() != pstrArr[2] {
-			return errors.New("invalid owner")
+// lineFields is strings.Fields also returning the byte offset of each field.
+func lineFields(line string) (fields []string, offsets []int) {
+	start := -1
+	for k, r := range line {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				fields = append(fields, line[start:k])
+				offsets = append(offsets, start)
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = k
 		}
+	}
+	if start >= 0 {
+		fields = append(fields, line[start:])
+		offsets = append(offsets, start)
+	}
+	return
+}
 
-		// decode the stuff..
-		deb64, err := icutl.B64DecodeData([]byte(pstrArr[1]))
+// parsePublicFile parses the public key file content, lines starting with
+// '#' are comments and are skipped, if a "# fingerprint: <fp>" comment is
+// present it has to match the fingerprint of the parsed key. Blanks around
+// the lines and between the fields are ignored, and so is a gzip wrapping of
+// the whole content. Errors are *ParseError.
+func parsePublicFile(pbuf []byte) (*IdentityPublicKey, error) {
+	// the whole file gzip'ed on the way.
+	if bytes.HasPrefix(pbuf, gzipMagic) {
+		plain, err := icutl.GunzipDataLimit(pbuf, MaxPublicKeySize)
 		if err != nil {
-			return err
+			return nil, &ParseError{Field: "line", Err: err}
+		}
+		pbuf = plain
+	}
+
+	var keyLine, statedFP string
+	var keyLineNum, fpLineNum, fpOffset int
+	for n, line := range strings.Split(string(pbuf), "\n") {
+		// pasted lines often come with stray blanks (and \r) around.
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case len(trimmed) == 0:
+			continue
+		case strings.HasPrefix(trimmed, PubFPComment):
+			value := strings.TrimPrefix(trimmed, PubFPComment)
+			statedFP = strings.TrimSpace(value)
+			fpLineNum = n + 1
+			fpOffset = strings.Index(line, PubFPComment) + len(PubFPComment) + len(value) - len(strings.TrimLeftFunc(value, unicode.IsSpace))
+		case strings.HasPrefix(trimmed, "#"):
+			continue
+		case len(keyLine) > 0:
+			return nil, &ParseError{Line: n + 1, Field: "line", Err: errors.New("more than one public key line")}
+		default:
+			keyLine, keyLineNum = line, n+1
 		}
+	}
+
+	// any run of blanks separates the fields, a blank inside the base64
+	// field makes a fourth field and is refused.
+	pstrArr, offsets := lineFields(keyLine)
+	if len(pstrArr) != 3 {
+		perr := &ParseError{Line: keyLineNum, Field: "line", Err: fmt.Errorf("%d fields, want 3", len(pstrArr))}
+		if len(pstrArr) > 3 {
+			perr.Field, perr.Offset = "owner", offsets[3]
+		}
+		return nil, perr
+	}
+
+	// sanity checks before using the splits...
+	keyType, ok := S2K[pstrArr[0]]
+	if !ok {
+		return nil, &ParseError{Line: keyLineNum, Field: "type", Offset: offsets[0], Err: errors.New("unknown key type " + pstrArr[0])}
+	}
+
+	// decode the stuff..
+	deb64, err := icutl.B64DecodeData([]byte(pstrArr[1]))
+	if err != nil {
+		return nil, &ParseError{Line: keyLineNum, Field: "payload", Offset: offsets[1], Err: err}
+	}
 
-		// decompress
-		pubraw, err := icutl.DecompressData(deb64)
+	// decompress
+	pubraw, meta, err := decodePubPayload(deb64)
+	if err != nil {
+		return nil, &ParseError{Line: keyLineNum, Field: "payload", Offset: offsets[1], Err: err}
+	}
+
+	// the metadata, and owner, are only taken with a valid self-signature.
+	var metadata map[string]string
+	if meta != nil {
+		metadata, err = meta.verify(keyType, pstrArr[2])
 		if err != nil {
-			return err
+			return nil, &ParseError{Line: keyLineNum, Field: "payload", Offset: offsets[1], Err: err}
 		}
+	}
 
-		switch keyType {
-		case KEYRSA:
-			if i.rsa != nil {
-				tempKey, err := x509.ParsePKIXPublicKey(pubraw)
-				if err != nil {
-					return err
-				}
-				i.rsa.PublicKey = *(tempKey.(*rsa.PublicKey))
-				return nil
-			}
-			break
-		case KEYECDSA:
-			if i.ecdsa != nil {
-				tempKey, err := x509.ParsePKIXPublicKey(pubraw)
-				if err != nil {
-					return err
-				}
-				i.ecdsa.PublicKey = *(tempKey.(*ecdsa.PublicKey))
-				return nil
-			}
-			break
-		case KEYEC25519:
-			// TODO
-			//keyBin, err = asn1.Unmarshal(pubraw)
+	// self-identifying public file, check it has not been tampered with.
+	if len(statedFP) > 0 {
+		fp, err := pubFingerprint(pubraw)
+		if err != nil {
+			return nil, &ParseError{Line: keyLineNum, Field: "payload", Offset: offsets[1], Err: err}
+		}
+		if !FingerprintEqual(fp, statedFP) {
+			return nil, &ParseError{Line: fpLineNum, Field: "fingerprint", Offset: fpOffset, Err: errors.New("fingerprint mismatch")}
 		}
 	}
 
-	return errors.New("invalid key")
+	return &IdentityPublicKey{
+		KeyType:  keyType,
+		KeyOwner: pstrArr[2],
+		KeyBin:   pubraw,
+		Metadata: metadata,
+	}, nil
 }
 
-func (i *IdentityKey) PrivToPKIX(wr io.Writer, passwd []byte) error {
-	var keyHeader string
-	var keyDer []byte
-	var err error
+// PKIXToPub parses the public key file and sets the public part of the
+// identity key, see parsePublicFile() for the accepted format.
+func (i *IdentityKey) PKIXToPub(rd io.Reader) (err error) {
+	// the public file is checked against the loaded private key.
+	if !i.initialized() || i.keyOwner == nil {
+		return ErrUninitialized
+	}
+
+	pbuf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+
+	pub, err := parsePublicFile(pbuf)
+	if err != nil {
+		return err
+	}
+
+	if pub.KeyType != i.keyType {
+		return errors.New("keytype confusion or invalid")
+	}
+
+	// uuid parse
+	if i.keyOwner.String() != pub.KeyOwner {
+		return errors.New("invalid owner")
+	}
+
+	return i.setPub(pub.KeyType, pub.KeyBin)
+}
+
+// FromPublicFile reads a public key file (or any file holding a single
+// "ic-*" public key line) without needing the private key file.
+func FromPublicFile(path string) (*IdentityPublicKey, error) {
+	return FromPublicFileWithParams(path, LoadParams{})
+}
+
+func FromPublicFileWithParams(path string, params LoadParams) (*IdentityPublicKey, error) {
+	pbuf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := parsePublicFile(pbuf)
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.allowed(pub.KeyType) {
+		return nil, ErrAlgorithmNotAllowed
+	}
+
+	// make sure the key itself is usable.
+	_, err = parsePubKeyBin(pub.KeyType, pub.KeyBin)
+	if err != nil {
+		return nil, err
+	}
+
+	return pub, nil
+}
+
+// privKeyDer returns the PEM block type and the DER/ASN.1 encoded private key.
+func (i *IdentityKey) privKeyDer() (keyHeader string, keyDer []byte, err error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if !i.initialized() {
+		err = ErrUninitialized
+		return
+	}
 
 	switch i.keyType {
 	case KEYRSA:
-		keyHeader = PEMHDR_RSA // "RSA PRIVATE KEY"
+		keyHeader = PEMHDR_RSA
 		keyDer = x509.MarshalPKCS1PrivateKey(i.rsa)
 	case KEYECDSA:
-		keyHeader = PEMHDR_ECDSA //"ECDSA PRIVATE KEY"
+		keyHeader = PEMHDR_ECDSA
 		keyDer, err = x509.MarshalECPrivateKey(i.ecdsa)
 	case KEYEC25519:
-		keyHeader = PEMHDR_25519 //"EC25519 PRIVATE KEY"
-		keyDer, err = asn1.Marshal(i.ec25519.Pub[:])
+		keyHeader = PEMHDR_25519
+		keyDer, err = asn1.Marshal([]byte(i.ec25519.Priv))
 	default:
-		return errors.New("invalid key type")
+		err = errors.New("invalid key type")
+	}
+	return
+}
+
+// parsePubKeyBin parses the binary form of a public key of the given type.
+func parsePubKeyBin(keyType int, pubraw []byte) (crypto.PublicKey, error) {
+	switch keyType {
+	case KEYRSA:
+		tempKey, err := parsePKIXPublicKey(pubraw)
+		if err != nil {
+			return nil, err
+		}
+		rsaPub, ok := tempKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("keytype confusion or invalid")
+		}
+		return rsaPub, nil
+	case KEYECDSA:
+		tempKey, err := parsePKIXPublicKey(pubraw)
+		if err != nil {
+			return nil, err
+		}
+		ecdsaPub, ok := tempKey.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("keytype confusion or invalid")
+		}
+		return ecdsaPub, nil
+	case KEYEC25519:
+		var pubRaw []byte
+		_, err := asn1.Unmarshal(pubraw, &pubRaw)
+		if err != nil {
+			return nil, err
+		}
+		if len(pubRaw) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid EC25519 public key")
+		}
+		return ed25519.PublicKey(pubRaw), nil
+	}
+
+	if p := customKeyType(keyType); p != nil {
+		return p.ParsePublic(pubraw)
+	}
+	return nil, errors.New("invalid key type")
+}
+
+// setPub sets the public part of the identity key from its binary form.
+func (i *IdentityKey) setPub(keyType int, pubraw []byte) error {
+	pub, err := parsePubKeyBin(keyType, pubraw)
+	if err != nil {
+		return err
+	}
+
+	switch keyType {
+	case KEYRSA:
+		if i.rsa != nil {
+			i.rsa.PublicKey = *(pub.(*rsa.PublicKey))
+			return nil
+		}
+	case KEYECDSA:
+		if i.ecdsa != nil {
+			i.ecdsa.PublicKey = *(pub.(*ecdsa.PublicKey))
+			return nil
+		}
+	case KEYEC25519:
+		if i.ec25519 != nil {
+			if len(i.ec25519.Priv) > 0 && !bytes.Equal(pub.(ed25519.PublicKey), i.ec25519.Priv.Public().(ed25519.PublicKey)) {
+				return ErrPublicPrivateMismatch
+			}
+			i.ec25519.Pub = pub.(ed25519.PublicKey)
+			return nil
+		}
 	}
+
+	return errors.New("invalid key")
+}
+
+func (i *IdentityKey) PrivToPKIX(wr io.Writer, passwd []byte) error {
+	keyHeader, keyDer, err := i.privKeyDer()
 	if err != nil {
 		return err
 	}
@@ -209,38 +679,148 @@ func (i *IdentityKey) PrivToPKIX(wr io.Writer, passwd []byte) error {
 }
 
 func (i *IdentityKey) PKIXToPriv(rd io.Reader, passwd []byte) error {
+	return i.pkixToPriv(rd, passwd, LoadParams{})
+}
+
+// PrivToPKIXWithAAD is PrivToPKIX binding the private key to the associated
+// data aad (i.e. a hostname), see AEADEncryptPEMBlockWithAAD.
+func (i *IdentityKey) PrivToPKIXWithAAD(wr io.Writer, passwd []byte, label string, aad []byte) error {
+	keyHeader, keyDer, err := i.privKeyDer()
+	if err != nil {
+		return err
+	}
+	pemKey, err := AEADEncryptPEMBlockWithAAD(rand.Reader, keyHeader, keyDer, passwd, label, aad)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(wr, pemKey)
+}
+
+// PKIXToPrivWithAAD is PKIXToPriv for private keys written by
+// PrivToPKIXWithAAD, it fails with ErrBadPassphrase unless aad is the same.
+func (i *IdentityKey) PKIXToPrivWithAAD(rd io.Reader, passwd, aad []byte) error {
+	pbuf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+
+	pemBlock, _ := pem.Decode(normalizeNewlines(pbuf))
+	if pemBlock == nil {
+		return fmt.Errorf("no PEM found")
+	}
+
+	plainBlock, err := AEADDecryptPEMBlockWithAAD(pemBlock, passwd, aad)
+	if err != nil {
+		return err
+	}
+	return i.derToPriv(pemBlock.Type, plainBlock)
+}
+
+func (i *IdentityKey) pkixToPriv(rd io.Reader, passwd []byte, params LoadParams) error {
 	pbuf, err := ioutil.ReadAll(rd)
 	if err != nil {
 		return err
 	}
 
-	pemBlock, _ := pem.Decode(pbuf)
+	pemBlock, _ := pem.Decode(normalizeNewlines(pbuf))
 	if pemBlock == nil {
 		return fmt.Errorf("no PEM found")
 	}
 
+	// no need to decrypt what we won't accept anyway, the type of PKCS #8
+	// keys is only known once decrypted.
+	if pemBlock.Type != PEMHDR_PKCS8 && !params.allowed(pemBlockKeyType(pemBlock.Type)) {
+		return ErrAlgorithmNotAllowed
+	}
+
+	err = i.pemToPriv(pemBlock, passwd)
+	if err != nil {
+		return err
+	}
+	if !params.allowed(i.keyType) {
+		i.Destroy()
+		return ErrAlgorithmNotAllowed
+	}
+	return nil
+}
+
+// normalizeNewlines turns CRLF (and lone CR) line endings into LF, for key
+// files written on Windows or mangled on the way.
+func normalizeNewlines(buf []byte) []byte {
+	if bytes.IndexByte(buf, '\r') < 0 {
+		return buf
+	}
+	buf = bytes.Replace(buf, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(buf, []byte("\r"), []byte("\n"), -1)
+}
+
+// pemToPriv decrypts the AEAD PEM block and sets the private key.
+func (i *IdentityKey) pemToPriv(pemBlock *pem.Block, passwd []byte) error {
 	plainBlock, err := AEADDecryptPEMBlock(pemBlock, passwd)
-	switch pemBlock.Type {
+	if err != nil {
+		return err
+	}
+
+	return i.derToPriv(pemBlock.Type, plainBlock)
+}
+
+// derToPriv sets the private key from its decrypted DER, blockType is the
+// PEM block type of the key, ours or a standard one.
+func (i *IdentityKey) derToPriv(blockType string, plainBlock []byte) (err error) {
+	switch blockType {
+	case PEMHDR_PKCS8:
+		// back to our own encoding, the owner is derived from it.
+		keyHeader, keyDer, err := pkcs8ToDer(plainBlock)
+		if err != nil {
+			return err
+		}
+		defer wipeBytes(keyDer)
+		return i.derToPriv(keyHeader, keyDer)
 	case PEMHDR_RSA:
 		i.keyType = KEYRSA
 
 		// set the keyowner
 		i.keyOwner, err = uuid.NewV5(uuid.NamespaceX500, plainBlock)
 
-		// parse the key
+		// parse the key, unless it is huge.
+		err = checkPKCS1Size(plainBlock)
+		if err != nil {
+			return err
+		}
 		i.rsa, err = x509.ParsePKCS1PrivateKey(plainBlock)
 		if err != nil {
 			return err
 		}
 
-	case PEMHDR_ECDSA:
+	case PEMHDR_ECDSA, PEMHDR_EC:
 		i.keyType = KEYECDSA
+		i.keyOwner, err = uuid.NewV5(uuid.NamespaceX500, plainBlock)
 		i.ecdsa, err = x509.ParseECPrivateKey(plainBlock)
 		if err != nil {
 			return err
 		}
 	case PEMHDR_25519:
-		// TODO
+		var privRaw []byte
+		_, err = asn1.Unmarshal(plainBlock, &privRaw)
+		if err != nil {
+			return err
+		}
+		if len(privRaw) != ed25519.PrivateKeySize {
+			return errors.New("invalid EC25519 private key")
+		}
+		// the public half is stored after the seed, it has to be the one
+		// the seed gives.
+		derived := ed25519.NewKeyFromSeed(privRaw[:ed25519.SeedSize])
+		mismatch := !bytes.Equal(derived[ed25519.SeedSize:], privRaw[ed25519.SeedSize:])
+		wipeBytes(derived)
+		if mismatch {
+			return ErrPublicPrivateMismatch
+		}
+		i.keyType = KEYEC25519
+		i.keyOwner, err = uuid.NewV5(uuid.NamespaceX500, plainBlock)
+		i.ec25519 = new(Ed25519PrivateKey)
+		i.ec25519.Priv = ed25519.PrivateKey(privRaw)
+		i.ec25519.Pub = i.ec25519.Priv.Public().(ed25519.PublicKey)
 	default:
 		return errors.New("Invalid key type")
 	}
@@ -248,8 +828,40 @@ func (i *IdentityKey) PKIXToPriv(rd io.Reader, passwd []byte) error {
 	return nil
 }
 
+// pkcs8ToDer returns the PEM block type and DER encoding PrivToPKIX would
+// use for a PKCS #8 private key.
+func pkcs8ToDer(der []byte) (string, []byte, error) {
+	err := checkPKCS8Size(der)
+	if err != nil {
+		return "", nil, err
+	}
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch key := k.(type) {
+	case *rsa.PrivateKey:
+		return PEMHDR_RSA, x509.MarshalPKCS1PrivateKey(key), nil
+	case *ecdsa.PrivateKey:
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		return PEMHDR_ECDSA, keyDer, err
+	case ed25519.PrivateKey:
+		keyDer, err := asn1.Marshal([]byte(key))
+		return PEMHDR_25519, keyDer, err
+	}
+	return "", nil, errors.New("unsupported PKCS #8 key type")
+}
+
+// ToKeyFiles writes the encrypted private key to prefix and the public key to
+// prefix.pub. The private key file is made accessible to its owner only: mode
+// 0600 on unix, a DACL granting access to the current user only on Windows.
 func (i *IdentityKey) ToKeyFiles(prefix string, passwd []byte) error {
-	privFile, err := os.OpenFile(prefix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
+	if !i.initialized() {
+		return ErrUninitialized
+	}
+
+	privFile, err := createPrivFile(prefix)
 	if err != nil {
 		return err
 	}
@@ -276,6 +888,10 @@ func (i *IdentityKey) ToKeyFiles(prefix string, passwd []byte) error {
 
 // just validation that the key is valid and complete..
 func (i *IdentityKey) Validate() (err error) {
+	if !i.initialized() {
+		return ErrUninitialized
+	}
+
 	switch i.keyType {
 	case KEYRSA:
 		err = i.rsa.Validate()
@@ -296,18 +912,97 @@ func (i *IdentityKey) Validate() (err error) {
 	case KEYECDSA:
 		// TODO
 	case KEYEC25519:
-		// TODO
+		if len(i.ec25519.Priv) != ed25519.PrivateKeySize {
+			return errors.New("invalid EC25519 private key")
+		}
+		derived := ed25519.NewKeyFromSeed(i.ec25519.Priv.Seed())
+		defer wipeBytes(derived)
+		if !bytes.Equal(derived.Public().(ed25519.PublicKey), i.ec25519.Pub) || !bytes.Equal(derived, i.ec25519.Priv) {
+			return ErrPublicPrivateMismatch
+		}
 	}
 	return
 }
 
+// wipeBigInt overwrites the big.Int words with zeroes.
+func wipeBigInt(b *big.Int) {
+	if b == nil {
+		return
+	}
+	words := b.Bits()
+	for k := range words {
+		words[k] = 0
+	}
+	b.SetInt64(0)
+}
+
+// wipe zeroes the private key material we hold (best effort, the runtime
+// may have made copies we cannot reach).
+func (i *IdentityKey) wipe() {
+	if i.rsa != nil {
+		wipeBigInt(i.rsa.D)
+		for _, p := range i.rsa.Primes {
+			wipeBigInt(p)
+		}
+		wipeBigInt(i.rsa.Precomputed.Dp)
+		wipeBigInt(i.rsa.Precomputed.Dq)
+		wipeBigInt(i.rsa.Precomputed.Qinv)
+		for _, crt := range i.rsa.Precomputed.CRTValues {
+			wipeBigInt(crt.Exp)
+			wipeBigInt(crt.Coeff)
+			wipeBigInt(crt.R)
+		}
+		// drops what crypto/rsa derived and keeps from them too.
+		i.rsa.Precomputed = rsa.PrecomputedValues{}
+	}
+	if i.ecdsa != nil {
+		wipeBigInt(i.ecdsa.D)
+	}
+	if i.ec25519 != nil {
+		for k := range i.ec25519.Priv {
+			i.ec25519.Priv[k] = 0
+		}
+	}
+}
+
+// Destroy zeroes the private key material, the key cannot sign or be
+// exported anymore (ErrDestroyed), only its public part remains usable.
+// Use it (often deferred) as soon as the key is not needed anymore rather
+// than waiting for the GC.
+func (i *IdentityKey) Destroy() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.expiry != nil {
+		i.expiry.timer.Stop()
+	}
+	i.wipe()
+	i.destroyed = true
+}
+
+// CheckKeyFiles loads prefix / prefix.pub exactly like FromKeyFiles would and
+// discards the result, wiping the secrets right away. It only reports whether
+// the key files are usable with passwd.
+func CheckKeyFiles(prefix string, passwd []byte) error {
+	i := new(IdentityKey)
+	defer i.wipe()
+
+	return i.FromKeyFiles(prefix, passwd)
+}
+
 // will try to load fprefix.pub / fprefix
 func (i *IdentityKey) FromKeyFiles(prefix string, passwd []byte) (err error) {
+	return i.FromKeyFilesWithParams(prefix, passwd, LoadParams{})
+}
+
+func (i *IdentityKey) FromKeyFilesWithParams(prefix string, passwd []byte, params LoadParams) (err error) {
 	pubFile, err := os.Open(prefix + ".pub")
-	if err != nil {
+	repair := params.RepairPublic && os.IsNotExist(err)
+	if err != nil && !repair {
 		return err
 	}
-	defer pubFile.Close()
+	if !repair {
+		defer pubFile.Close()
+	}
 
 	privFile, err := os.Open(prefix)
 	if err != nil {
@@ -315,12 +1010,23 @@ func (i *IdentityKey) FromKeyFiles(prefix string, passwd []byte) (err error) {
 	}
 	defer privFile.Close()
 
-	err = i.PKIXToPriv(privFile, passwd)
+	if params.StrictPermissions {
+		err = checkPrivFile(privFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = i.pkixToPriv(privFile, passwd, params)
 	if err != nil {
 		return err
 	}
 
-	err = i.PKIXToPub(pubFile)
+	if repair {
+		err = i.repairPublic(prefix + ".pub")
+	} else {
+		err = i.PKIXToPub(pubFile)
+	}
 	if err != nil {
 		return err
 	}
@@ -329,27 +1035,218 @@ func (i *IdentityKey) FromKeyFiles(prefix string, passwd []byte) (err error) {
 	return err
 }
 
+// repairPublic writes the missing public key file from the loaded private
+// key, the owner is derived from the private key so it is the same as in
+// the lost file.
+func (i *IdentityKey) repairPublic(path string) error {
+	pubFile, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0700)
+	if err != nil {
+		return err
+	}
+	defer pubFile.Close()
+
+	err = i.PubToPKIX(pubFile)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	icutl.DebugLog.Printf("FromKeyFiles(): %s was missing, regenerated from the private key", path)
+	return nil
+}
+
+// GenerateAndSave generates a new identity key of the given type with params
+// and writes it to prefix / prefix.pub. Existing files are not overwritten
+// unless force is set. Files are written under a temporary name and moved in
+// place, on failure nothing is left behind. Without force they are hard
+// linked in place, which fails if the file exists, even when it shows up
+// while the key is generated: the filesystem must support hard links.
+func GenerateAndSave(prefix string, keytype int, params KeyParams, passwd []byte, force bool) (*IdentityKey, error) {
+	if !force {
+		// fail early, saveKeyFiles makes sure.
+		for _, f := range []string{prefix, prefix + ".pub"} {
+			_, err := os.Lstat(f)
+			if err == nil {
+				return nil, fmt.Errorf("%s already exists", f)
+			}
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	i, err := NewIdentityKeyWithParams(keytype, params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = i.saveKeyFiles(prefix, passwd, force)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// saveKeyFiles writes the key files under a temporary name and moves them in
+// place, the private key file last. Existing files are replaced if overwrite
+// is set, otherwise it fails with an os.IsExist error and leaves them be.
+func (i *IdentityKey) saveKeyFiles(prefix string, passwd []byte, overwrite bool) error {
+	tmpPrefix := prefix + ".tmp"
+	err := i.ToKeyFiles(tmpPrefix, passwd)
+	if err != nil {
+		os.Remove(tmpPrefix)
+		os.Remove(tmpPrefix + ".pub")
+		return err
+	}
+
+	if overwrite {
+		err = os.Rename(tmpPrefix+".pub", prefix+".pub")
+		if err == nil {
+			err = os.Rename(tmpPrefix, prefix)
+		}
+	} else {
+		// Link fails if the target exists, Rename would replace it.
+		err = os.Link(tmpPrefix+".pub", prefix+".pub")
+		if err == nil {
+			err = os.Link(tmpPrefix, prefix)
+			if err != nil {
+				// ours, we just linked it.
+				os.Remove(prefix + ".pub")
+			}
+		}
+	}
+	os.Remove(tmpPrefix)
+	os.Remove(tmpPrefix + ".pub")
+	return err
+}
+
 func LoadIdentityKey(prefix string, passwd []byte) (i *IdentityKey, err error) {
+	return LoadIdentityKeyWithParams(prefix, passwd, LoadParams{})
+}
+
+func LoadIdentityKeyWithParams(prefix string, passwd []byte, params LoadParams) (i *IdentityKey, err error) {
+	start := time.Now()
 	i = new(IdentityKey)
 
-	err = i.FromKeyFiles(prefix, passwd)
+	err = i.FromKeyFilesWithParams(prefix, passwd, params)
 	if err != nil {
 		return nil, err
 	}
 
+	if hook := getKeyHook(); hook != nil {
+		fp, alg := i.hookArgs()
+		hook.OnKeyLoad(fp, alg, time.Since(start))
+	}
+
 	return i, nil
 }
 
+// setOwner derives the key owner UUID from the private key.
+func (i *IdentityKey) setOwner() (err error) {
+	// registered key types cannot be exported, the public key it is.
+	if i.custom != nil {
+		keyBin, err := i.pubKeyBin()
+		if err != nil {
+			return err
+		}
+		i.keyOwner, err = uuid.NewV5(uuid.NamespaceX500, keyBin)
+		return err
+	}
+
+	_, privKeyDer, err := i.privKeyDer()
+	if err != nil {
+		return err
+	}
+	i.keyOwner, err = uuid.NewV5(uuid.NamespaceX500, privKeyDer)
+	return err
+}
+
+// KeyParams are the identity key generation parameters, the zero value
+// gives the defaults.
+type KeyParams struct {
+	// Rand is the randomness source, crypto/rand.Reader if nil.
+	Rand io.Reader
+	// RNGHealthCheck runs the RNG health checks (see CheckRNGHealth) on Rand
+	// before generating the key.
+	RNGHealthCheck bool
+	// RSAPublicExponent is the public exponent of RSA keys,
+	// RSADefaultExponent (65537) if 0. See GenKeysRSAWithExponent.
+	RSAPublicExponent int
+}
+
+// LoadParams are the key loading options.
+type LoadParams struct {
+	// AllowedTypes restricts the accepted key types (KEYRSA, ...), any type
+	// is accepted if empty. Other types fail with ErrAlgorithmNotAllowed.
+	AllowedTypes []int
+	// RepairPublic regenerates the public key file from the private key
+	// when it is missing, instead of failing.
+	RepairPublic bool
+	// StrictPermissions refuses, like ssh does, a private key file that its
+	// group or others can access (mode & 077) with ErrUnsafePermissions.
+	// Unix only, the file ACLs are not looked at on Windows. Leave it unset
+	// for the special cases, i.e. a key shared by a group on purpose.
+	StrictPermissions bool
+}
+
+func (p LoadParams) allowed(keyType int) bool {
+	if len(p.AllowedTypes) == 0 {
+		return true
+	}
+	for _, t := range p.AllowedTypes {
+		if t == keyType {
+			return true
+		}
+	}
+	return false
+}
+
 func NewIdentityKey(keytype int) (*IdentityKey, error) {
+	return NewIdentityKeyWithParams(keytype, KeyParams{})
+}
+
+func NewIdentityKeyWithParams(keytype int, params KeyParams) (*IdentityKey, error) {
 	var err error
 	i := new(IdentityKey)
 
 	icutl.DebugLog.Printf("bleh bleh keygen for %d\n", keytype)
 
+	err = fipsCheckKeyType(keytype)
+	if err != nil {
+		return nil, err
+	}
+	err = fipsCheckRand(params.Rand)
+	if err != nil {
+		return nil, err
+	}
+
+	rnd := params.Rand
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+
+	if params.RNGHealthCheck {
+		err = CheckRNGHealth(rnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	switch keytype {
 	case KEYRSA:
 		i.keyType = keytype
-		i.rsa, err = GenKeysRSA(rand.Reader)
+		e := params.RSAPublicExponent
+		if e == 0 {
+			e = RSADefaultExponent
+		}
+		// FIPS 186-5 wants e > 2^16.
+		if FIPSMode() && e < RSADefaultExponent {
+			return nil, ErrNotFIPSApproved
+		}
+		i.rsa, err = GenKeysRSAWithExponent(rnd, e)
+		if err != nil {
+			return nil, err
+		}
 		privKeyDer := x509.MarshalPKCS1PrivateKey(i.rsa)
 		i.keyOwner, err = uuid.NewV5(uuid.NamespaceX500, privKeyDer)
 		if err != nil {
@@ -363,7 +1260,7 @@ func NewIdentityKey(keytype int) (*IdentityKey, error) {
 
 	case KEYECDSA:
 		i.keyType = keytype
-		i.ecdsa, err = GenKeysECDSA(rand.Reader)
+		i.ecdsa, err = GenKeysECDSA(rnd)
 	/*
 		//fmt.Printf("ECDSAAAAA: %v / %v\n", i.ecdsa, err)
 		jsonProut, err := json.Marshal(i.ecdsa.Public())
@@ -386,7 +1283,7 @@ func NewIdentityKey(keytype int) (*IdentityKey, error) {
 
 	case KEYEC25519:
 		i.keyType = keytype
-		i.ec25519, err = GenKeysED25519(rand.Reader)
+		i.ec25519, err = GenKeysED25519(rnd)
 
 	/*
 		pkixKey, err := asn1.Marshal(i.ec25519.Pub[:])
@@ -398,9 +1295,27 @@ func NewIdentityKey(keytype int) (*IdentityKey, error) {
 		fmt.Printf("PKIX PublicKey: ac-ed25519 %s\n", b64pub)
 	*/
 	default:
-		err = errors.New("invalid type")
+		p := customKeyType(keytype)
+		if p == nil {
+			err = errors.New("invalid type")
+			return nil, err
+		}
+		i.keyType = keytype
+		i.custom, err = p.Generate(rnd)
+	}
+	if err != nil {
 		return nil, err
 	}
+
+	// owner is derived from the private key for all key types.
+	if i.keyOwner == nil {
+		err = i.setOwner()
+		if err != nil {
+			icutl.DebugLog.Printf("UUID error\n")
+			return nil, err
+		}
+	}
+
 	//fmt.Printf("C'EST BON ON A FINI\n")
 	// UUID.
 	//i.keyOwner = owner
@@ -0,0 +1,2035 @@
+package ickp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unix4fun/ic/icutl"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/sha3"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	icutl.InitDebugLog(ioutil.Discard)
+}
+
+//
+//
+// PUBLIC KEY FILE TESTS
+//
+//
+
+// the streamed public line must be the one built in memory before.
+func TestPubToPKIXStreamed(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, err := NewIdentityKey(keytype)
+		if err != nil {
+			t.Fatalf("NewIdentityKey() error: %v\n", err)
+		}
+		keyBin, err := i.pubKeyBin()
+		if err != nil {
+			t.Fatalf("pubKeyBin() error: %v\n", err)
+		}
+
+		comp, err := icutl.CompressData(keyBin)
+		if err != nil {
+			t.Fatalf("CompressData() error: %v\n", err)
+		}
+		b64pub := icutl.B64EncodeData(append([]byte{PubLineVersion0}, comp...))
+		expected := K2S[keytype] + " " + string(b64pub) + " " + i.keyOwner.String()
+
+		pubBuf := new(bytes.Buffer)
+		err = i.PubToPKIX(pubBuf)
+		if err != nil {
+			t.Fatalf("PubToPKIX() error: %v\n", err)
+		}
+
+		if pubBuf.String() != expected {
+			t.Logf("%s PubToPKIX() output differs:\n%s\n%s\n", K2S[keytype], pubBuf.String(), expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestParsePublicEC25519Alias(t *testing.T) {
+	i, err := NewIdentityKey(KEYEC25519)
+	if err != nil {
+		t.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+
+	pubBuf := new(bytes.Buffer)
+	err = i.PubToPKIX(pubBuf)
+	if err != nil {
+		t.Fatalf("PubToPKIX() error: %v\n", err)
+	}
+
+	pubLine := pubBuf.String()
+	if strings.HasPrefix(pubLine, KeyEC25519Str+" ") == false {
+		t.Logf("not canonical header: '%s'\n", pubLine)
+		t.Fail()
+	}
+
+	aliasLine := KeyEC25519AliasStr + strings.TrimPrefix(pubLine, KeyEC25519Str)
+
+	pub, err := parsePublicFile([]byte(pubLine))
+	if err != nil {
+		t.Fatalf("parsePublicFile(%s) error: %v\n", KeyEC25519Str, err)
+	}
+
+	aliasPub, err := parsePublicFile([]byte(aliasLine))
+	if err != nil {
+		t.Fatalf("parsePublicFile(%s) error: %v\n", KeyEC25519AliasStr, err)
+	}
+
+	if pub.KeyType != aliasPub.KeyType || pub.KeyOwner != aliasPub.KeyOwner || bytes.Equal(pub.KeyBin, aliasPub.KeyBin) == false {
+		t.Logf("not matching '%v' vs '%v'\n", pub, aliasPub)
+		t.Fail()
+	}
+}
+
+func TestParsePublicWhitespace(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	line := new(bytes.Buffer)
+	i.PubToPKIX(line)
+	fields := strings.Fields(line.String())
+
+	for _, noisy := range []string{
+		"  " + line.String() + "  ",
+		"\t" + line.String() + "\t\r\n",
+		"\n\n" + line.String() + "\n\n",
+		fields[0] + "   " + fields[1] + " \t " + fields[2],
+		"\r\n  # comment\r\n" + line.String() + "\r\n",
+	} {
+		pub, err := parsePublicFile([]byte(noisy))
+		if err != nil || pub.KeyOwner != fields[2] {
+			t.Logf("parsePublicFile(%q) error: %v\n", noisy, err)
+			t.Fail()
+		}
+	}
+
+	// blanks inside the base64 field are not tolerated.
+	broken := fields[0] + " " + fields[1][:10] + " " + fields[1][10:] + " " + fields[2]
+	_, err := parsePublicFile([]byte(broken))
+	if err == nil {
+		t.Logf("parsePublicFile() SHOULD fail with a blank inside the payload\n")
+		t.Fail()
+	}
+}
+
+func TestGenerateAndSave(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	prefix := filepath.Join(t.TempDir(), "key")
+	passwd := []byte("passphrase")
+
+	_, err := GenerateAndSave(prefix, KEYRSA, KeyParams{RSAPublicExponent: 3}, passwd, false)
+	if err != nil {
+		t.Fatalf("GenerateAndSave() error: %v\n", err)
+	}
+	loaded, err := LoadIdentityKey(prefix, passwd)
+	if err != nil {
+		t.Fatalf("LoadIdentityKey() error: %v\n", err)
+	}
+	if loaded.rsa.E != 3 {
+		t.Logf("GenerateAndSave() ignored KeyParams: e = %d\n", loaded.rsa.E)
+		t.Fail()
+	}
+	priv, _ := ioutil.ReadFile(prefix)
+
+	_, err = GenerateAndSave(prefix, KEYEC25519, KeyParams{}, passwd, false)
+	if err == nil {
+		t.Logf("GenerateAndSave() SHOULD refuse to overwrite\n")
+		t.Fail()
+	}
+
+	// a file showing up after the early check is not overwritten either.
+	other, _ := NewIdentityKey(KEYEC25519)
+	os.Remove(prefix + ".pub")
+	err = other.saveKeyFiles(prefix, passwd, false)
+	if !os.IsExist(err) {
+		t.Logf("saveKeyFiles() SHOULD fail with an existing file: %v\n", err)
+		t.Fail()
+	}
+	if data, _ := ioutil.ReadFile(prefix); !bytes.Equal(data, priv) {
+		t.Logf("saveKeyFiles() overwrote the private key file\n")
+		t.Fail()
+	}
+	for _, f := range []string{prefix + ".pub", prefix + ".tmp", prefix + ".tmp.pub"} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Logf("%s left behind: %v\n", f, err)
+			t.Fail()
+		}
+	}
+
+	other, err = GenerateAndSave(prefix, KEYEC25519, KeyParams{}, passwd, true)
+	if err != nil {
+		t.Fatalf("GenerateAndSave(force) error: %v\n", err)
+	}
+	loaded, err = LoadIdentityKey(prefix, passwd)
+	if err != nil {
+		t.Fatalf("LoadIdentityKey() error: %v\n", err)
+	}
+	fp, _ := other.Fingerprint()
+	if loadedFp, _ := loaded.Fingerprint(); loadedFp != fp || loaded.keyType != KEYEC25519 {
+		t.Logf("GenerateAndSave(force) did not overwrite the key files\n")
+		t.Fail()
+	}
+}
+
+func TestPromoteKeyFiles(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "key")
+	passwd := []byte("passphrase")
+
+	gen := func(prefix string) string {
+		i, err := GenerateAndSave(prefix, KEYEC25519, KeyParams{}, passwd, true)
+		if err != nil {
+			t.Fatalf("GenerateAndSave(%s) error: %v\n", prefix, err)
+		}
+		fp, _ := i.Fingerprint()
+		return fp
+	}
+	loadFP := func(prefix string) string {
+		i, err := LoadIdentityKey(prefix, passwd)
+		if err != nil {
+			t.Logf("LoadIdentityKey(%s) error: %v\n", prefix, err)
+			t.Fail()
+			return ""
+		}
+		fp, _ := i.Fingerprint()
+		return fp
+	}
+
+	cur := gen(prefix)
+	next := gen(prefix + ".next")
+	err := PromoteKeyFiles(prefix)
+	if err != nil {
+		t.Fatalf("PromoteKeyFiles() error: %v\n", err)
+	}
+	if loadFP(prefix) != next || loadFP(prefix+".old") != cur {
+		t.Logf("PromoteKeyFiles() did not promote the next key\n")
+		t.Fail()
+	}
+	if _, err := os.Stat(prefix + ".next"); !os.IsNotExist(err) {
+		t.Logf("prefix.next still there: %v\n", err)
+		t.Fail()
+	}
+
+	// the public key file fails, the private one is put back.
+	defer func() { renameFile = os.Rename }()
+	renameFile = func(from, to string) error {
+		if strings.HasSuffix(from, ".next.pub") {
+			return errors.New("injected failure")
+		}
+		return os.Rename(from, to)
+	}
+
+	cur, next = next, gen(prefix+".next")
+	err = PromoteKeyFiles(prefix)
+	if err == nil {
+		t.Fatalf("PromoteKeyFiles() SHOULD fail\n")
+	}
+	if loadFP(prefix) != cur || loadFP(prefix+".next") != next {
+		t.Logf("PromoteKeyFiles() failure left mismatched key files\n")
+		t.Fail()
+	}
+	if _, err := os.Stat(prefix + ".rollback"); !os.IsNotExist(err) {
+		t.Logf("prefix.rollback left behind: %v\n", err)
+		t.Fail()
+	}
+
+	// without current key files, there is nothing to go back to.
+	fresh := filepath.Join(dir, "fresh")
+	next = gen(fresh + ".next")
+	err = PromoteKeyFiles(fresh)
+	if err == nil {
+		t.Fatalf("PromoteKeyFiles() SHOULD fail\n")
+	}
+	if _, err := os.Stat(fresh); !os.IsNotExist(err) || loadFP(fresh+".next") != next {
+		t.Logf("PromoteKeyFiles() failure left a private key file: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestWatchKeyFiles(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	WatchInterval = 20 * time.Millisecond
+	prefix := filepath.Join(t.TempDir(), "key")
+	passwd := []byte("passphrase")
+
+	_, err := GenerateAndSave(prefix, KEYEC25519, KeyParams{}, passwd, false)
+	if err != nil {
+		t.Fatalf("GenerateAndSave() error: %v\n", err)
+	}
+
+	reloads := make(chan *IdentityKey, 4)
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		done <- WatchKeyFiles(ctx, prefix, passwd, func(i *IdentityKey) { reloads <- i })
+	}()
+	// let it take the initial state.
+	time.Sleep(5 * WatchInterval)
+
+	i, err := GenerateAndSave(prefix, KEYEC25519, KeyParams{}, passwd, true)
+	if err != nil {
+		t.Fatalf("GenerateAndSave(force) error: %v\n", err)
+	}
+	fp, _ := i.Fingerprint()
+
+	select {
+	case reloaded := <-reloads:
+		if reloadedFP, _ := reloaded.Fingerprint(); reloadedFP != fp {
+			t.Logf("WatchKeyFiles() reloaded another key\n")
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WatchKeyFiles() did not reload\n")
+	}
+
+	// once only.
+	select {
+	case <-reloads:
+		t.Logf("WatchKeyFiles() reloaded twice\n")
+		t.Fail()
+	case <-time.After(10 * WatchInterval):
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		if err != context.Canceled {
+			t.Logf("WatchKeyFiles() error: %v\n", err)
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WatchKeyFiles() did not stop\n")
+	}
+}
+
+func TestLoadAllowedTypes(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "key")
+
+	_, err := GenerateAndSave(prefix, KEYECDSA, KeyParams{}, []byte("passphrase"), false)
+	if err != nil {
+		t.Fatalf("GenerateAndSave() error: %v\n", err)
+	}
+
+	_, err = LoadIdentityKeyWithParams(prefix, []byte("passphrase"), LoadParams{AllowedTypes: []int{KEYEC25519}})
+	if err != ErrAlgorithmNotAllowed {
+		t.Logf("LoadIdentityKeyWithParams() SHOULD fail with ErrAlgorithmNotAllowed: %v\n", err)
+		t.Fail()
+	}
+
+	_, err = FromPublicFileWithParams(prefix+".pub", LoadParams{AllowedTypes: []int{KEYEC25519}})
+	if err != ErrAlgorithmNotAllowed {
+		t.Logf("FromPublicFileWithParams() SHOULD fail with ErrAlgorithmNotAllowed: %v\n", err)
+		t.Fail()
+	}
+
+	_, err = LoadIdentityKeyWithParams(prefix, []byte("passphrase"), LoadParams{AllowedTypes: []int{KEYEC25519, KEYECDSA}})
+	if err != nil {
+		t.Logf("LoadIdentityKeyWithParams() error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestLoadRepairPublic(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "key")
+
+	_, err := GenerateAndSave(prefix, KEYECDSA, KeyParams{}, []byte("passphrase"), false)
+	if err != nil {
+		t.Fatalf("GenerateAndSave() error: %v\n", err)
+	}
+	pubOrig, _ := ioutil.ReadFile(prefix + ".pub")
+	os.Remove(prefix + ".pub")
+
+	_, err = LoadIdentityKey(prefix, []byte("passphrase"))
+	if err == nil {
+		t.Logf("LoadIdentityKey() SHOULD fail without public file\n")
+		t.Fail()
+	}
+
+	_, err = LoadIdentityKeyWithParams(prefix, []byte("passphrase"), LoadParams{RepairPublic: true})
+	if err != nil {
+		t.Fatalf("LoadIdentityKeyWithParams() error: %v\n", err)
+	}
+
+	pub, err := ioutil.ReadFile(prefix + ".pub")
+	if err != nil || !bytes.Equal(pub, pubOrig) {
+		t.Logf("regenerated public file differs: %v\n%s\n%s\n", err, pub, pubOrig)
+		t.Fail()
+	}
+}
+
+func TestFindByPassphrase(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	dir := t.TempDir()
+
+	for _, k := range []struct {
+		name   string
+		passwd string
+	}{
+		{"alice", "forgotten"},
+		{"bob", "other"},
+		{"carol", "forgotten"},
+	} {
+		_, err := GenerateAndSave(filepath.Join(dir, k.name), KEYEC25519, KeyParams{}, []byte(k.passwd), false)
+		if err != nil {
+			t.Fatalf("GenerateAndSave(%s) error: %v\n", k.name, err)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a key"), 0600)
+
+	found, err := FindByPassphrase(dir, []byte("forgotten"))
+	if err != nil {
+		t.Fatalf("FindByPassphrase() error: %v\n", err)
+	}
+	if len(found) != 2 || found[0] != filepath.Join(dir, "alice") || found[1] != filepath.Join(dir, "carol") {
+		t.Logf("FindByPassphrase() found: %v\n", found)
+		t.Fail()
+	}
+
+	found, err = FindByPassphrase(dir, []byte("nope"))
+	if err != nil || len(found) != 0 {
+		t.Logf("FindByPassphrase(wrong) found: %v error: %v\n", found, err)
+		t.Fail()
+	}
+}
+
+// AAD bound keys are ic keys, the directory operations must not skip them
+// silently.
+func TestAADKeyFiles(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aad")
+	passwd := []byte("passphrase")
+
+	i, _ := NewIdentityKey(KEYECDSA)
+	buf := new(bytes.Buffer)
+	err := i.PrivToPKIXWithAAD(buf, passwd, "host", []byte("web01"))
+	if err != nil {
+		t.Fatalf("PrivToPKIXWithAAD() error: %v\n", err)
+	}
+	ioutil.WriteFile(path, buf.Bytes(), 0600)
+
+	isKey, keyType, err := IsICPrivateKey(path)
+	if err != nil || !isKey || keyType != KEYECDSA {
+		t.Logf("IsICPrivateKey(AAD) = %v, %d, %v\n", isKey, keyType, err)
+		t.Fail()
+	}
+
+	changed, err := RekeyDir(dir, passwd, []byte("new"))
+	errs, ok := err.(RekeyErrors)
+	if len(changed) != 0 || !ok || len(errs) != 1 || errs[0].Path != path || errs[0].Err != ErrAADBound {
+		t.Logf("RekeyDir(AAD) = %v, %v\n", changed, err)
+		t.Fail()
+	}
+	if data, _ := ioutil.ReadFile(path); !bytes.Equal(data, buf.Bytes()) {
+		t.Logf("RekeyDir() changed the AAD key file\n")
+		t.Fail()
+	}
+
+	found, err := FindByPassphrase(dir, passwd)
+	if len(found) != 0 || err != ErrAADBound {
+		t.Logf("FindByPassphrase(AAD) = %v, %v\n", found, err)
+		t.Fail()
+	}
+}
+
+func TestRekeyDir(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	dir := t.TempDir()
+	passwd, newPasswd := []byte("passphrase"), []byte("new passphrase")
+
+	fps := make(map[string]string)
+	for name, pw := range map[string][]byte{"argon": passwd, "other": []byte("other")} {
+		i, err := GenerateAndSave(filepath.Join(dir, name), KEYECDSA, KeyParams{}, pw, false)
+		if err != nil {
+			t.Fatalf("GenerateAndSave(%s) error: %v\n", name, err)
+		}
+		fps[name], _ = i.Fingerprint()
+	}
+
+	// a key written before KDF-Info: PBKDF2 with an 8 bytes salt.
+	legacy, _ := NewIdentityKey(KEYEC25519)
+	fps["legacy"], _ = legacy.Fingerprint()
+	keyHeader, keyDer, _ := legacy.privKeyDer()
+	salt, nonce := []byte("saltsalt"), make([]byte, 12)
+	aesraw, _ := aes.NewCipher(deriveKey(passwd, salt, nil))
+	aesgcm, _ := cipher.NewGCM(aesraw)
+	dek := "AES-256-GCM," + hex.EncodeToString(nonce) + "," + hex.EncodeToString(salt)
+	ioutil.WriteFile(filepath.Join(dir, "legacy"), pem.EncodeToMemory(&pem.Block{
+		Type:    keyHeader,
+		Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": dek},
+		Bytes:   aesgcm.Seal(nil, nonce, keyDer, []byte(dek)),
+	}), 0600)
+
+	// a PKCS #8 key, its type is only known once decrypted.
+	pkcs8Key, _ := NewIdentityKey(KEYRSA)
+	fps["pkcs8"], _ = pkcs8Key.Fingerprint()
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(pkcs8Key.rsa)
+	block, _ := AEADEncryptPEMBlock(rand.Reader, PEMHDR_PKCS8, pkcs8, passwd)
+	ioutil.WriteFile(filepath.Join(dir, "pkcs8"), pem.EncodeToMemory(block), 0600)
+	isKey, keyType, err := IsICPrivateKey(filepath.Join(dir, "pkcs8"))
+	if err != nil || !isKey || keyType != KeyTypeUnknown {
+		t.Logf("IsICPrivateKey(PKCS #8) = %v, %d, %v\n", isKey, keyType, err)
+		t.Fail()
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a key"), 0600)
+	otherOrig, _ := ioutil.ReadFile(filepath.Join(dir, "other"))
+
+	changed, err := RekeyDir(dir, passwd, newPasswd)
+	if len(changed) != 3 || changed[0] != filepath.Join(dir, "argon") || changed[1] != filepath.Join(dir, "legacy") ||
+		changed[2] != filepath.Join(dir, "pkcs8") {
+		t.Logf("RekeyDir() changed: %v\n", changed)
+		t.Fail()
+	}
+	errs, ok := err.(RekeyErrors)
+	if !ok || len(errs) != 1 || errs[0].Path != filepath.Join(dir, "other") || errs[0].Err != ErrBadPassphrase {
+		t.Logf("RekeyDir() error: %v\n", err)
+		t.Fail()
+	}
+
+	for _, name := range []string{"argon", "legacy", "pkcs8"} {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		block, _ := pem.Decode(data)
+		if block == nil || !strings.HasPrefix(block.Headers["KDF-Info"], kdfArgon2id+",") {
+			t.Logf("%s not re-encrypted with Argon2id\n", name)
+			t.Fail()
+		}
+		i := new(IdentityKey)
+		err = i.PKIXToPriv(bytes.NewReader(data), newPasswd)
+		if err != nil {
+			t.Logf("%s PKIXToPriv(new passphrase) error: %v\n", name, err)
+			t.Fail()
+			continue
+		}
+		if fp, _ := i.Fingerprint(); fp != fps[name] {
+			t.Logf("%s RekeyDir() changed the key\n", name)
+			t.Fail()
+		}
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "other")); !bytes.Equal(data, otherOrig) {
+		t.Logf("RekeyDir() changed the key it could not decrypt\n")
+		t.Fail()
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "notes.txt")); string(data) != "not a key" {
+		t.Logf("RekeyDir() changed a file which is not a key\n")
+		t.Fail()
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) > 0 {
+		t.Logf("RekeyDir() left %v\n", tmps)
+		t.Fail()
+	}
+}
+
+func TestShredKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "key")
+
+	_, err := GenerateAndSave(prefix, KEYECDSA, KeyParams{}, []byte("passphrase"), false)
+	if err != nil {
+		t.Fatalf("GenerateAndSave() error: %v\n", err)
+	}
+	privOrig, _ := ioutil.ReadFile(prefix)
+
+	// a hard link lets us look at the shredded content.
+	linked := filepath.Join(dir, "linked")
+	hasLink := os.Link(prefix, linked) == nil
+
+	err = ShredKeyFiles(prefix)
+	if err != nil {
+		t.Fatalf("ShredKeyFiles() error: %v\n", err)
+	}
+	for _, name := range []string{prefix, prefix + ".pub"} {
+		_, err = os.Stat(name)
+		if !os.IsNotExist(err) {
+			t.Logf("%s still there: %v\n", name, err)
+			t.Fail()
+		}
+	}
+	if hasLink {
+		priv, _ := ioutil.ReadFile(linked)
+		if len(priv) != len(privOrig) || bytes.Contains(priv, []byte("PRIVATE KEY")) {
+			t.Logf("private key file not overwritten\n")
+			t.Fail()
+		}
+	}
+
+	err = ShredKeyFiles(prefix)
+	if !os.IsNotExist(err) {
+		t.Logf("ShredKeyFiles() SHOULD fail on missing files: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestURLToken(t *testing.T) {
+	for _, keyType := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keyType)
+		token, err := i.PubToURLToken()
+		if err != nil {
+			t.Fatalf("PubToURLToken(%d) error: %v\n", keyType, err)
+		}
+		if url.QueryEscape(token) != token || url.PathEscape(token) != token {
+			t.Logf("PubToURLToken(%d) needs escaping: %s\n", keyType, token)
+			t.Fail()
+		}
+
+		pub, err := DecodeURLToken(url.QueryEscape(token))
+		if err != nil {
+			t.Fatalf("DecodeURLToken(%d) error: %v\n", keyType, err)
+		}
+		keyBin, _ := i.pubKeyBin()
+		if pub.KeyType != keyType || pub.KeyOwner != i.keyOwner.String() || !bytes.Equal(pub.KeyBin, keyBin) {
+			t.Logf("DecodeURLToken(%d) decoded another key\n", keyType)
+			t.Fail()
+		}
+
+		again, err := pub.PubToURLToken()
+		if err != nil || again != token {
+			t.Logf("IdentityPublicKey.PubToURLToken(%d) differs: %v\n", keyType, err)
+			t.Fail()
+		}
+	}
+
+	for _, token := range []string{"", "ic5", "icx.AHg.owner", "ic5.AHg.owner", "ic5.A+g=.owner", "ic5.AHg.own.er"} {
+		_, err := DecodeURLToken(token)
+		if err == nil {
+			t.Logf("DecodeURLToken(%q) SHOULD fail\n", token)
+			t.Fail()
+		}
+	}
+}
+
+// the size guard must trigger before anything expensive is done with the
+// huge modulus.
+func TestRSAKeyTooLarge(t *testing.T) {
+	n := new(big.Int).Lsh(big.NewInt(1), 4*defaultMaxRSAKeyBits)
+	n.Add(n, big.NewInt(1))
+	start := time.Now()
+
+	spki, err := x509.MarshalPKIXPublicKey(&rsa.PublicKey{N: n, E: 65537})
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey() error: %v\n", err)
+	}
+	_, err = IdentityPublicKeyFromSPKI(spki)
+	if err != ErrKeyTooLarge {
+		t.Logf("IdentityPublicKeyFromSPKI() SHOULD fail with ErrKeyTooLarge: %v\n", err)
+		t.Fail()
+	}
+
+	line := new(bytes.Buffer)
+	writePublicLine(line, KEYRSA, spki, nilOwner, false)
+	pub, err := parsePublicFile(line.Bytes())
+	if err != nil {
+		t.Fatalf("parsePublicFile() error: %v\n", err)
+	}
+	_, err = pub.CryptoPublicKey()
+	if err != ErrKeyTooLarge {
+		t.Logf("CryptoPublicKey() SHOULD fail with ErrKeyTooLarge: %v\n", err)
+		t.Fail()
+	}
+
+	// only the modulus matters, the other values are never looked at.
+	pkcs1, err := asn1.Marshal(struct {
+		Version int
+		N       *big.Int
+		E       int
+		D, P, Q *big.Int
+		Dp, Dq  *big.Int
+		Qinv    *big.Int
+	}{0, n, 65537, n, n, n, n, n, n})
+	if err != nil {
+		t.Fatalf("asn1.Marshal() error: %v\n", err)
+	}
+	err = new(IdentityKey).derToPriv(PEMHDR_RSA, pkcs1)
+	if err != ErrKeyTooLarge {
+		t.Logf("derToPriv(PKCS #1) SHOULD fail with ErrKeyTooLarge: %v\n", err)
+		t.Fail()
+	}
+
+	pkcs8, err := asn1.Marshal(struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}{0, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}, Parameters: asn1.NullRawValue}, pkcs1})
+	if err != nil {
+		t.Fatalf("asn1.Marshal() error: %v\n", err)
+	}
+	err = new(IdentityKey).derToPriv(PEMHDR_PKCS8, pkcs8)
+	if err != ErrKeyTooLarge {
+		t.Logf("derToPriv(PKCS #8) SHOULD fail with ErrKeyTooLarge: %v\n", err)
+		t.Fail()
+	}
+
+	if time.Since(start) > time.Second {
+		t.Logf("size checks took %v\n", time.Since(start))
+		t.Fail()
+	}
+}
+
+func TestPubMetadata(t *testing.T) {
+	i, _ := NewIdentityKey(KEYECDSA)
+	metadata := map[string]string{"expires": "2027-01-01", "contact": "ops@example.com"}
+
+	buf := new(bytes.Buffer)
+	err := i.PubToPKIXWithMetadata(buf, metadata)
+	if err != nil {
+		t.Fatalf("PubToPKIXWithMetadata() error: %v\n", err)
+	}
+	line := buf.String()
+
+	pub, err := parsePublicFile([]byte(line))
+	if err != nil {
+		t.Fatalf("parsePublicFile() error: %v\n", err)
+	}
+	if len(pub.Metadata) != 2 || pub.Metadata["expires"] != "2027-01-01" || pub.Metadata["contact"] != "ops@example.com" {
+		t.Logf("parsePublicFile() metadata: %v\n", pub.Metadata)
+		t.Fail()
+	}
+	err = i.PKIXToPub(strings.NewReader(line))
+	if err != nil {
+		t.Logf("PKIXToPub() error: %v\n", err)
+		t.Fail()
+	}
+
+	// same signature, other metadata.
+	fields := strings.Fields(line)
+	deb64, _ := icutl.B64DecodeData([]byte(fields[1]))
+	meta, err := decodeMetadataPayload(deb64[1:])
+	if err != nil {
+		t.Fatalf("decodeMetadataPayload() error: %v\n", err)
+	}
+	meta.Metadata[1].Value = "2099-01-01"
+	payload, _ := asn1.Marshal(*meta)
+	tampered := new(bytes.Buffer)
+	writePublicLinePayload(tampered, KEYECDSA, meta.KeyBin, PubLineVersion1, payload, fields[2], false)
+
+	other, _ := NewIdentityKey(KEYECDSA)
+	for name, bad := range map[string]string{
+		"metadata": tampered.String(),
+		"owner":    fields[0] + " " + fields[1] + " " + other.keyOwner.String(),
+	} {
+		_, err = parsePublicFile([]byte(bad))
+		if !errors.Is(err, ErrMetadataSignature) {
+			t.Logf("parsePublicFile(%s tampered) SHOULD fail with ErrMetadataSignature: %v\n", name, err)
+			t.Fail()
+		}
+	}
+
+	err = i.PubToPKIXWithMetadata(new(bytes.Buffer), map[string]string{"Not A Key": "v"})
+	if err == nil {
+		t.Logf("PubToPKIXWithMetadata() SHOULD fail on an invalid key\n")
+		t.Fail()
+	}
+
+	buf.Reset()
+	i.PubToPKIX(buf)
+	pub, _ = parsePublicFile(buf.Bytes())
+	if pub == nil || pub.Metadata != nil {
+		t.Logf("version 0 line with metadata\n")
+		t.Fail()
+	}
+}
+
+func TestLoadOrCreate(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	prefix := filepath.Join(t.TempDir(), "key")
+	passwd := []byte("passphrase")
+
+	type result struct {
+		fp      string
+		created bool
+		err     error
+	}
+	results := make(chan result)
+	for n := 0; n < 4; n++ {
+		go func() {
+			i, created, err := LoadOrCreate(prefix, KEYEC25519, KeyParams{}, passwd)
+			var fp string
+			if err == nil {
+				fp, _ = i.Fingerprint()
+			}
+			results <- result{fp, created, err}
+		}()
+	}
+
+	var fp string
+	creations := 0
+	for n := 0; n < 4; n++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("LoadOrCreate() error: %v\n", r.err)
+		}
+		if r.created {
+			creations++
+		}
+		if len(fp) > 0 && r.fp != fp {
+			t.Logf("LoadOrCreate() returned different keys\n")
+			t.Fail()
+		}
+		fp = r.fp
+	}
+	if creations != 1 {
+		t.Logf("LoadOrCreate() created %d keys\n", creations)
+		t.Fail()
+	}
+
+	_, err := os.Stat(prefix + ".lock")
+	if !os.IsNotExist(err) {
+		t.Logf("lock file left behind: %v\n", err)
+		t.Fail()
+	}
+
+	_, created, err := LoadOrCreate(prefix, KEYEC25519, KeyParams{}, []byte("wrong"))
+	if err != ErrBadPassphrase || created {
+		t.Logf("LoadOrCreate(wrong passphrase) SHOULD fail: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestLoadStrictPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions on windows")
+	}
+	prefix := filepath.Join(t.TempDir(), "key")
+	strict := LoadParams{StrictPermissions: true}
+
+	_, err := GenerateAndSave(prefix, KEYEC25519, KeyParams{}, []byte("passphrase"), false)
+	if err != nil {
+		t.Fatalf("GenerateAndSave() error: %v\n", err)
+	}
+	_, err = LoadIdentityKeyWithParams(prefix, []byte("passphrase"), strict)
+	if err != nil {
+		t.Fatalf("LoadIdentityKeyWithParams() error: %v\n", err)
+	}
+
+	os.Chmod(prefix, 0640)
+	_, err = LoadIdentityKeyWithParams(prefix, []byte("passphrase"), strict)
+	if err != ErrUnsafePermissions {
+		t.Logf("LoadIdentityKeyWithParams() SHOULD fail with ErrUnsafePermissions: %v\n", err)
+		t.Fail()
+	}
+
+	_, err = LoadIdentityKey(prefix, []byte("passphrase"))
+	if err != nil {
+		t.Logf("LoadIdentityKey() error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestIdentityCard(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	card, err := i.MakeCard("Jane Doe", "jane@example.com")
+	if err != nil {
+		t.Fatalf("MakeCard() error: %v\n", err)
+	}
+
+	pub, info, err := ParseCard(card)
+	if err != nil {
+		t.Fatalf("ParseCard() error: %v\n", err)
+	}
+	keyBin, _ := i.pubKeyBin()
+	if !bytes.Equal(pub.KeyBin, keyBin) || info.Name != "Jane Doe" || info.Email != "jane@example.com" || time.Since(info.Created) > time.Minute {
+		t.Logf("ParseCard() returned %+v\n", info)
+		t.Fail()
+	}
+
+	// the comment line is not what is trusted.
+	edited := bytes.Replace(card, []byte("Jane Doe"), []byte("John Doe"), 1)
+	_, info, err = ParseCard(edited)
+	if err != nil || info.Name != "Jane Doe" {
+		t.Logf("ParseCard(edited comment) returned %+v: %v\n", info, err)
+		t.Fail()
+	}
+
+	buf := new(bytes.Buffer)
+	i.PubToPKIX(buf)
+	_, _, err = ParseCard(buf.Bytes())
+	if err == nil {
+		t.Logf("ParseCard() SHOULD fail on a bare public key line\n")
+		t.Fail()
+	}
+
+	_, err = i.MakeCard("Jane Doe", "Jane <jane@example.com>")
+	if err == nil {
+		t.Logf("MakeCard() SHOULD fail on an invalid email\n")
+		t.Fail()
+	}
+
+	for _, name := range []string{"Jane\nic-25519 forged", "Jane\rDoe", "Jane\x00", "Jane\x1b[2J", "Jane\u0085", "Jane\xff"} {
+		_, err = i.MakeCard(name, "jane@example.com")
+		if err == nil {
+			t.Logf("MakeCard(%q) SHOULD fail\n", name)
+			t.Fail()
+		}
+	}
+	if _, err = i.MakeCard("Zoë Doe", "zoe@example.com"); err != nil {
+		t.Logf("MakeCard() SHOULD accept non ASCII names: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestLoadCRLFKeyFiles(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "key")
+
+	_, err := GenerateAndSave(prefix, KEYEC25519, KeyParams{}, []byte("passphrase"), false)
+	if err != nil {
+		t.Fatalf("GenerateAndSave() error: %v\n", err)
+	}
+
+	for _, f := range []string{prefix, prefix + ".pub"} {
+		buf, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("ReadFile() error: %v\n", err)
+		}
+		err = ioutil.WriteFile(f, bytes.Replace(buf, []byte("\n"), []byte("\r\n"), -1), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile() error: %v\n", err)
+		}
+	}
+
+	_, err = LoadIdentityKey(prefix, []byte("passphrase"))
+	if err != nil {
+		t.Logf("LoadIdentityKey() CRLF error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestKeyringDuplicates(t *testing.T) {
+	var ring Keyring
+
+	a, _ := NewIdentityKey(KEYEC25519)
+	b, _ := NewIdentityKey(KEYEC25519)
+
+	if ring.Add("a", a) != nil || ring.Add("b", b) != nil {
+		t.Fatalf("Add() failed\n")
+	}
+
+	// same key, another name.
+	err := ring.Add("c", a)
+	if err != ErrDuplicateKey || len(ring.Entries) != 2 {
+		t.Logf("Add() SHOULD fail with ErrDuplicateKey: %v\n", err)
+		t.Fail()
+	}
+
+	ring.Entries = append(ring.Entries, KeyringEntry{Name: "d", Key: b}, KeyringEntry{Name: "e", Key: a})
+	removed := ring.Dedup()
+	if removed != 2 || len(ring.Entries) != 2 || ring.Entries[0].Name != "a" || ring.Entries[1].Name != "b" {
+		t.Logf("Dedup() unexpected result: %d removed, %v\n", removed, ring.Entries)
+		t.Fail()
+	}
+
+	// entries appended by hand may have no key, Lookup skips them.
+	ring.Entries = append([]KeyringEntry{{Name: "nil"}}, ring.Entries...)
+	fp, _ := b.Fingerprint()
+	if entry := ring.Lookup(fp); entry == nil || entry.Name != "b" {
+		t.Logf("Lookup() SHOULD find b past a nil entry: %v\n", entry)
+		t.Fail()
+	}
+	if ring.Lookup(strings.Repeat("00", 32)) != nil {
+		t.Logf("Lookup() SHOULD NOT find an unknown key\n")
+		t.Fail()
+	}
+	c, _ := NewIdentityKey(KEYEC25519)
+	if ring.Add("c", c) != nil {
+		t.Logf("Add() SHOULD work with a nil entry in the ring\n")
+		t.Fail()
+	}
+}
+
+func TestKeyringSaveLoad(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+
+	dir, err := ioutil.TempDir("", "ickp")
+	if err != nil {
+		t.Fatalf("TempDir() error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring")
+
+	var ring Keyring
+	for n, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		k, _ := NewIdentityKey(keytype)
+		ring.Add(k.Type(), k)
+		ring.Entries[n].Metadata = map[string]string{"host": "web01"}
+	}
+
+	err = ring.Save(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("Save() error: %v\n", err)
+	}
+
+	_, err = LoadKeyring(path, []byte("wrong"))
+	if err != ErrBadPassphrase {
+		t.Logf("LoadKeyring() SHOULD fail with ErrBadPassphrase: %v\n", err)
+		t.Fail()
+	}
+
+	loaded, err := LoadKeyring(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("LoadKeyring() error: %v\n", err)
+	}
+	if len(loaded.Entries) != len(ring.Entries) {
+		t.Fatalf("LoadKeyring() loaded %d entries\n", len(loaded.Entries))
+	}
+	for n, entry := range ring.Entries {
+		fp, _ := entry.Key.Fingerprint()
+		got := loaded.Entries[n]
+		gotFP, _ := got.Key.Fingerprint()
+		if got.Name != entry.Name || gotFP != fp || got.Metadata["host"] != "web01" || got.Key.keyOwner.String() != entry.Key.keyOwner.String() {
+			t.Logf("LoadKeyring() entry %d differs: %s %s\n", n, got.Name, gotFP)
+			t.Fail()
+		}
+	}
+}
+
+// the EC25519 public key must be the one of the private seed.
+func TestEd25519PublicPrivateMismatch(t *testing.T) {
+	a, _ := NewIdentityKey(KEYEC25519)
+	b, _ := NewIdentityKey(KEYEC25519)
+
+	// seed of a, public half of b.
+	swapped := append(append([]byte(nil), a.ec25519.Priv.Seed()...), b.ec25519.Pub...)
+	der, _ := asn1.Marshal(swapped)
+	err := new(IdentityKey).derToPriv(PEMHDR_25519, der)
+	if err != ErrPublicPrivateMismatch {
+		t.Logf("derToPriv() SHOULD fail with ErrPublicPrivateMismatch: %v\n", err)
+		t.Fail()
+	}
+
+	// public file of b, with the owner of a.
+	dir, err := ioutil.TempDir("", "ickp")
+	if err != nil {
+		t.Fatalf("TempDir() error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	prefix := filepath.Join(dir, "id")
+	err = a.ToKeyFiles(prefix, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("ToKeyFiles() error: %v\n", err)
+	}
+	keyBin, _ := b.pubKeyBin()
+	line := new(bytes.Buffer)
+	writePublicLine(line, KEYEC25519, keyBin, a.keyOwner.String(), false)
+	ioutil.WriteFile(prefix+".pub", line.Bytes(), 0600)
+
+	err = new(IdentityKey).FromKeyFiles(prefix, []byte("passphrase"))
+	if err != ErrPublicPrivateMismatch {
+		t.Logf("FromKeyFiles() SHOULD fail with ErrPublicPrivateMismatch: %v\n", err)
+		t.Fail()
+	}
+
+	a.ec25519.Pub = b.ec25519.Pub
+	if a.Validate() != ErrPublicPrivateMismatch {
+		t.Logf("Validate() SHOULD fail with ErrPublicPrivateMismatch\n")
+		t.Fail()
+	}
+}
+
+func TestSamePublicKey(t *testing.T) {
+	a, _ := NewIdentityKey(KEYECDSA)
+	b, _ := NewIdentityKey(KEYECDSA)
+
+	icLine := new(bytes.Buffer)
+	err := a.PubToPKIXWithFingerprint(icLine)
+	if err != nil {
+		t.Fatalf("PubToPKIXWithFingerprint() error: %v\n", err)
+	}
+	sshLine, err := ConvertPublic(icLine.Bytes(), FormatOpenSSH)
+	if err != nil {
+		t.Fatalf("ConvertPublic() error: %v\n", err)
+	}
+	otherLine := new(bytes.Buffer)
+	b.PubToPKIX(otherLine)
+	otherPEM, err := ConvertPublic(otherLine.Bytes(), FormatPEM)
+	if err != nil {
+		t.Fatalf("ConvertPublic() error: %v\n", err)
+	}
+
+	same, err := SamePublicKey(icLine.Bytes(), sshLine)
+	if err != nil || !same {
+		t.Logf("SamePublicKey() ic vs OpenSSH: %v %v\n", same, err)
+		t.Fail()
+	}
+
+	same, err = SamePublicKey(sshLine, otherPEM)
+	if err != nil || same {
+		t.Logf("SamePublicKey() different keys: %v %v\n", same, err)
+		t.Fail()
+	}
+
+	_, err = SamePublicKey(sshLine, []byte("garbage"))
+	if err == nil {
+		t.Logf("SamePublicKey() SHOULD fail on garbage\n")
+		t.Fail()
+	}
+}
+
+func TestParsePublicCompressionBomb(t *testing.T) {
+	comp, err := icutl.CompressData(make([]byte, MaxPublicKeySize+1))
+	if err != nil {
+		t.Fatalf("CompressData() error: %v\n", err)
+	}
+	line := "ic-ec25519 " + string(icutl.B64EncodeData(append([]byte{PubLineVersion0}, comp...))) + " " + nilOwner
+
+	_, err = parsePublicFile([]byte(line))
+	if !errors.Is(err, ErrDecompressTooLarge) {
+		t.Logf("parsePublicFile() SHOULD fail with ErrDecompressTooLarge: %v\n", err)
+		t.Fail()
+	}
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	_, err := gz.Write(data)
+	if err != nil {
+		t.Fatalf("gzip Write() error: %v\n", err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestParsePublicGzip(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	keyBin, _ := i.pubKeyBin()
+	owner := i.keyOwner.String()
+
+	// gzip instead of zlib, with and without version byte.
+	for _, payload := range [][]byte{
+		append([]byte{PubLineVersion0}, gzipData(t, keyBin)...),
+		gzipData(t, keyBin),
+	} {
+		line := i.Type() + " " + string(icutl.B64EncodeData(payload)) + " " + owner
+		pub, err := parsePublicFile([]byte(line))
+		if err != nil {
+			t.Fatalf("parsePublicFile() gzip payload error: %v\n", err)
+		}
+		if !bytes.Equal(pub.KeyBin, keyBin) {
+			t.Logf("parsePublicFile() gzip payload returned another key\n")
+			t.Fail()
+		}
+	}
+
+	// the whole file gzip'ed.
+	buf := new(bytes.Buffer)
+	i.PubToPKIX(buf)
+	pub, err := parsePublicFile(gzipData(t, buf.Bytes()))
+	if err != nil {
+		t.Fatalf("parsePublicFile() gzip file error: %v\n", err)
+	}
+	if !bytes.Equal(pub.KeyBin, keyBin) {
+		t.Logf("parsePublicFile() gzip file returned another key\n")
+		t.Fail()
+	}
+
+	line := i.Type() + " " + string(icutl.B64EncodeData([]byte{PubLineVersion0, 'n', 'o', 'p', 'e'})) + " " + owner
+	_, err = parsePublicFile([]byte(line))
+	if err == nil || !strings.Contains(err.Error(), "neither zlib nor gzip") {
+		t.Logf("parsePublicFile() uncompressed payload error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestParsePublicError(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	buf := new(bytes.Buffer)
+	i.PubToPKIXWithFingerprint(buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	fpLine, keyLine := lines[0], lines[1]
+	fields := strings.Fields(keyLine)
+
+	tests := []struct {
+		content string
+		line    int
+		field   string
+		offset  int
+	}{
+		{"ic-nope " + fields[1] + " " + fields[2], 1, "type", 0},
+		{"  " + fields[0] + "   !!" + fields[1] + " " + fields[2], 1, "payload", len(fields[0]) + 5},
+		{keyLine + " extra", 1, "owner", len(keyLine) + 1},
+		{keyLine + "\n" + keyLine, 2, "line", 0},
+		{PubFPComment + " " + strings.Repeat("0", len(fpLine)-len(PubFPComment)-1) + "\n" + keyLine, 1, "fingerprint", len(PubFPComment) + 1},
+	}
+
+	for _, test := range tests {
+		_, err := parsePublicFile([]byte(test.content))
+		perr, ok := err.(*ParseError)
+		if !ok || !errors.Is(err, ErrBadPublicLine) {
+			t.Logf("parsePublicFile(%q) error: %v\n", test.content, err)
+			t.Fail()
+			continue
+		}
+		if perr.Line != test.line || perr.Field != test.field || perr.Offset != test.offset {
+			t.Logf("parsePublicFile(%q) error at line %d %s offset %d, want line %d %s offset %d\n",
+				test.content, perr.Line, perr.Field, perr.Offset, test.line, test.field, test.offset)
+			t.Fail()
+		}
+	}
+}
+
+// goldenPublicKeys returns fixed public keys of every type, as binary form.
+func goldenPublicKeys(t *testing.T) map[int][]byte {
+	seed := sha3.Sum256([]byte("ic golden key"))
+
+	// any 2048 bits odd modulus will do, it is never used to encrypt.
+	n := make([]byte, 0, 256)
+	for c := byte(0); len(n) < 256; c++ {
+		block := sha3.Sum256(append(seed[:], c))
+		n = append(n, block[:]...)
+	}
+	n[0] |= 0x80
+	n[255] |= 0x01
+	rsaPub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	ecPub := &ecdsa.PublicKey{Curve: elliptic.P256()}
+	ecPub.X, ecPub.Y = elliptic.P256().ScalarBaseMult(seed[:])
+
+	edPub := ed25519.NewKeyFromSeed(seed[:]).Public()
+
+	keys := make(map[int][]byte)
+	for _, pub := range []crypto.PublicKey{rsaPub, ecPub, edPub} {
+		keyType, keyBin, err := marshalPubKeyBin(pub)
+		if err != nil {
+			t.Fatalf("marshalPubKeyBin() error: %v\n", err)
+		}
+		keys[keyType] = keyBin
+	}
+	return keys
+}
+
+// the public line of a given key must never change.
+func TestPublicLineGolden(t *testing.T) {
+	golden, err := ioutil.ReadFile(filepath.Join("testdata", "publine.golden"))
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v\n", err)
+	}
+
+	keys := goldenPublicKeys(t)
+	out := new(bytes.Buffer)
+	for _, keyType := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		err = writePublicLine(out, keyType, keys[keyType], nilOwner, false)
+		if err != nil {
+			t.Fatalf("writePublicLine() error: %v\n", err)
+		}
+		out.WriteString("\n")
+	}
+
+	if !bytes.Equal(out.Bytes(), golden) {
+		t.Logf("public lines differ from testdata/publine.golden:\n%s", out.String())
+		t.Fail()
+	}
+}
+
+// a zero value key must fail cleanly, not panic.
+func TestUninitializedKey(t *testing.T) {
+	msg := []byte("message")
+
+	for name, op := range map[string]func(i *IdentityKey) error{
+		"Fingerprint": func(i *IdentityKey) error { _, err := i.Fingerprint(); return err },
+		"PubToPKIX":   func(i *IdentityKey) error { return i.PubToPKIX(new(bytes.Buffer)) },
+		"PKIXToPub":   func(i *IdentityKey) error { return i.PKIXToPub(strings.NewReader("")) },
+		"PrivToPKIX":  func(i *IdentityKey) error { return i.PrivToPKIX(new(bytes.Buffer), []byte("passphrase")) },
+		"PubToDER":    func(i *IdentityKey) error { _, err := i.PubToDER(); return err },
+		"Validate":    func(i *IdentityKey) error { return i.Validate() },
+		"ToKeyFiles":  func(i *IdentityKey) error { return i.ToKeyFiles(filepath.Join(t.TempDir(), "key"), nil) },
+		"Describe":    func(i *IdentityKey) error { _, err := i.Describe(); return err },
+		"RandomArt":   func(i *IdentityKey) error { _, err := i.RandomArt(); return err },
+		"SignWithContext": func(i *IdentityKey) error {
+			_, err := i.SignWithContext("ctx", nil, msg)
+			return err
+		},
+		"SignSSHSig": func(i *IdentityKey) error { _, err := i.SignSSHSig(nil, msg); return err },
+		"ToTLSCertificate": func(i *IdentityKey) error {
+			_, err := i.ToTLSCertificate(nil)
+			return err
+		},
+	} {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Logf("%s() panics: %v\n", name, r)
+					t.Fail()
+				}
+			}()
+			return op(new(IdentityKey))
+		}()
+		if err != ErrUninitialized {
+			t.Logf("%s() SHOULD fail with ErrUninitialized: %v\n", name, err)
+			t.Fail()
+		}
+	}
+
+	i := new(IdentityKey)
+	if i.Type() != "" || i.VerifyWithContext("ctx", msg, nil) == nil || i.IsRevoked(nil) {
+		t.Logf("uninitialized key SHOULD have no type and verify nothing\n")
+		t.Fail()
+	}
+	i.CompactRSA()
+	i.Destroy()
+}
+
+// nothing usable is left of a destroyed key, even through a reference to the
+// private key taken before.
+func TestDestroyedKeyCannotSign(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		msg := []byte("message")
+		digest := sha256.Sum256(msg)
+		var raw crypto.Signer
+		switch keytype {
+		case KEYRSA:
+			raw = i.rsa
+		case KEYECDSA:
+			raw = i.ecdsa
+		case KEYEC25519:
+			raw = i.ec25519.Priv
+		}
+
+		i.Destroy()
+		_, err := i.SignWithContext("test", rand.Reader, msg)
+		if err != ErrDestroyed {
+			t.Logf("%s SignWithContext() after Destroy() error: %v\n", K2S[keytype], err)
+			t.Fail()
+		}
+
+		var sig []byte
+		switch keytype {
+		case KEYRSA:
+			sig, err = raw.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err == nil && rsa.VerifyPKCS1v15(&i.rsa.PublicKey, crypto.SHA256, digest[:], sig) == nil {
+				t.Logf("destroyed RSA key still signs\n")
+				t.Fail()
+			}
+		case KEYECDSA:
+			sig, err = raw.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err == nil && ecdsa.VerifyASN1(&i.ecdsa.PublicKey, digest[:], sig) {
+				t.Logf("destroyed ECDSA key still signs\n")
+				t.Fail()
+			}
+		case KEYEC25519:
+			sig, _ = raw.Sign(rand.Reader, msg, crypto.Hash(0))
+			if ed25519.Verify(i.ec25519.Pub, msg, sig) {
+				t.Logf("destroyed EC25519 key still signs\n")
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestPrivDERRoundTrip(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	der, err := i.PrivToDER([]byte("passphrase"))
+	if err != nil {
+		t.Fatalf("PrivToDER() error: %v\n", err)
+	}
+
+	loaded := new(IdentityKey)
+	err = loaded.DERToPriv(der, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("DERToPriv() error: %v\n", err)
+	}
+	fp, _ := i.Fingerprint()
+	loadedFP, _ := loaded.Fingerprint()
+	if fp != loadedFP {
+		t.Logf("DERToPriv() loaded another key\n")
+		t.Fail()
+	}
+}
+
+// every label PrivToPKIX writes, and the standard ones, must load back.
+func TestPubDERRoundTrip(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		der, err := i.PubToDER()
+		if err != nil {
+			t.Fatalf("%s PubToDER() error: %v\n", K2S[keytype], err)
+		}
+
+		// DERToPub sets the public part of an existing key.
+		privDer, _ := i.PrivToDER([]byte("passphrase"))
+		loaded := new(IdentityKey)
+		err = loaded.DERToPriv(privDer, []byte("passphrase"))
+		if err != nil {
+			t.Fatalf("%s DERToPriv() error: %v\n", K2S[keytype], err)
+		}
+		err = loaded.DERToPub(der)
+		if err != nil {
+			t.Fatalf("%s DERToPub() error: %v\n", K2S[keytype], err)
+		}
+		loadedDer, _ := loaded.PubToDER()
+		if !bytes.Equal(der, loadedDer) {
+			t.Logf("%s DERToPub() does not round trip\n", K2S[keytype])
+			t.Fail()
+		}
+
+		otherType, _ := NewIdentityKey((keytype + 1) % 3)
+		otherDer, _ := otherType.PubToDER()
+		malformed := map[string][]byte{
+			"empty":     nil,
+			"truncated": der[:len(der)/2],
+			"garbage":   bytes.Repeat([]byte{0x30}, len(der)),
+			"key type":  otherDer,
+		}
+		for name, bad := range malformed {
+			if loaded.DERToPub(bad) == nil {
+				t.Logf("%s DERToPub(%s) SHOULD fail\n", K2S[keytype], name)
+				t.Fail()
+			}
+		}
+		if loadedDer, _ = loaded.PubToDER(); !bytes.Equal(der, loadedDer) {
+			t.Logf("%s DERToPub() changed the key on malformed input\n", K2S[keytype])
+			t.Fail()
+		}
+	}
+
+	if new(IdentityKey).DERToPub([]byte{0x30, 0x00}) == nil {
+		t.Logf("DERToPub() SHOULD fail on an uninitialized key\n")
+		t.Fail()
+	}
+}
+
+// the EC25519 private key file holds the private key, it used to hold the
+// public one.
+func TestEC25519PrivateEncoding(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	keyHeader, keyDer, err := i.privKeyDer()
+	if err != nil {
+		t.Fatalf("privKeyDer() error: %v\n", err)
+	}
+	var privRaw []byte
+	rest, err := asn1.Unmarshal(keyDer, &privRaw)
+	if err != nil || len(rest) > 0 || keyHeader != PEMHDR_25519 {
+		t.Fatalf("EC25519 private DER: %s, %v\n", keyHeader, err)
+	}
+	if !bytes.Equal(privRaw, i.ec25519.Priv) {
+		t.Logf("EC25519 private DER is not the private key\n")
+		t.Fail()
+	}
+
+	buf := new(bytes.Buffer)
+	i.PrivToPKIX(buf, []byte("passphrase"))
+	loaded := new(IdentityKey)
+	err = loaded.PKIXToPriv(buf, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("PKIXToPriv() error: %v\n", err)
+	}
+	sig, err := loaded.SignWithContext("ec25519", rand.Reader, []byte("message"))
+	if err != nil || i.VerifyWithContext("ec25519", []byte("message"), sig) != nil {
+		t.Logf("loaded EC25519 key does not sign for the original: %v\n", err)
+		t.Fail()
+	}
+}
+
+// a failed decryption leaves the key untouched, the error used to be
+// ignored and the garbage parsed as a key.
+func TestPKIXToPrivDecryptError(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		buf := new(bytes.Buffer)
+		i.PrivToPKIX(buf, []byte("passphrase"))
+
+		loaded := new(IdentityKey)
+		err := loaded.PKIXToPriv(bytes.NewReader(buf.Bytes()), []byte("wrong"))
+		if err != ErrBadPassphrase {
+			t.Logf("%s PKIXToPriv(wrong passphrase) SHOULD fail with ErrBadPassphrase: %v\n", K2S[keytype], err)
+			t.Fail()
+		}
+		if loaded.initialized() {
+			t.Logf("%s PKIXToPriv(wrong passphrase) SHOULD NOT set a key\n", K2S[keytype])
+			t.Fail()
+		}
+
+		der, _ := i.PrivToDER([]byte("passphrase"))
+		err = loaded.DERToPriv(der, []byte("wrong"))
+		if err != ErrBadPassphrase || loaded.initialized() {
+			t.Logf("%s DERToPriv(wrong passphrase) SHOULD fail with ErrBadPassphrase: %v\n", K2S[keytype], err)
+			t.Fail()
+		}
+	}
+}
+
+func TestPrivPEMLabels(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	passwd := []byte("passphrase")
+
+	load := func(blockType string, der []byte) (*IdentityKey, error) {
+		block, err := AEADEncryptPEMBlock(rand.Reader, blockType, der, passwd)
+		if err != nil {
+			return nil, err
+		}
+		loaded := new(IdentityKey)
+		return loaded, loaded.PKIXToPriv(bytes.NewReader(pem.EncodeToMemory(block)), passwd)
+	}
+
+	for _, keyType := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, err := NewIdentityKey(keyType)
+		if err != nil {
+			t.Fatalf("NewIdentityKey(%d) error: %v\n", keyType, err)
+		}
+		buf := new(bytes.Buffer)
+		err = i.PrivToPKIX(buf, passwd)
+		if err != nil {
+			t.Fatalf("PrivToPKIX(%d) error: %v\n", keyType, err)
+		}
+		block, _ := pem.Decode(buf.Bytes())
+		if block == nil || pemBlockKeyType(block.Type) != keyType {
+			t.Fatalf("PrivToPKIX(%d) unknown PEM label\n", keyType)
+		}
+
+		var priv interface{}
+		switch keyType {
+		case KEYRSA:
+			priv = i.rsa
+		case KEYECDSA:
+			priv = i.ecdsa
+		case KEYEC25519:
+			priv = i.ec25519.Priv
+		}
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatalf("MarshalPKCS8PrivateKey(%d) error: %v\n", keyType, err)
+		}
+		encodings := map[string][]byte{PEMHDR_PKCS8: pkcs8}
+		if keyType == KEYECDSA {
+			encodings[PEMHDR_EC], _ = x509.MarshalECPrivateKey(i.ecdsa)
+		}
+
+		loaded := new(IdentityKey)
+		err = loaded.PKIXToPriv(bytes.NewReader(buf.Bytes()), passwd)
+		if err != nil {
+			t.Fatalf("PKIXToPriv(%s) error: %v\n", block.Type, err)
+		}
+		for label, der := range encodings {
+			std, err := load(label, der)
+			if err != nil {
+				t.Logf("PKIXToPriv(%d, %s) error: %v\n", keyType, label, err)
+				t.Fail()
+				continue
+			}
+			if std.keyType != keyType || std.keyOwner.String() != loaded.keyOwner.String() {
+				t.Logf("PKIXToPriv(%d, %s) loaded another key\n", keyType, label)
+				t.Fail()
+			}
+		}
+	}
+
+	// the type of PKCS #8 keys is only checked once decrypted.
+	i, _ := NewIdentityKey(KEYEC25519)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(i.ec25519.Priv)
+	block, _ := AEADEncryptPEMBlock(rand.Reader, PEMHDR_PKCS8, pkcs8, passwd)
+	err := new(IdentityKey).pkixToPriv(bytes.NewReader(pem.EncodeToMemory(block)), passwd, LoadParams{AllowedTypes: []int{KEYECDSA}})
+	if err != ErrAlgorithmNotAllowed {
+		t.Logf("pkixToPriv(PKCS #8) SHOULD fail with ErrAlgorithmNotAllowed: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestPrivToPKIXWithAAD(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	buf := new(bytes.Buffer)
+	err := i.PrivToPKIXWithAAD(buf, []byte("passphrase"), "host", []byte("web01"))
+	if err != nil {
+		t.Fatalf("PrivToPKIXWithAAD() error: %v\n", err)
+	}
+
+	err = new(IdentityKey).PKIXToPriv(bytes.NewReader(buf.Bytes()), []byte("passphrase"))
+	if err != ErrBadPassphrase {
+		t.Logf("PKIXToPriv() SHOULD fail without the associated data: %v\n", err)
+		t.Fail()
+	}
+
+	loaded := new(IdentityKey)
+	err = loaded.PKIXToPrivWithAAD(bytes.NewReader(buf.Bytes()), []byte("passphrase"), []byte("web01"))
+	if err != nil {
+		t.Fatalf("PKIXToPrivWithAAD() error: %v\n", err)
+	}
+	fp, _ := i.Fingerprint()
+	loadedFP, _ := loaded.Fingerprint()
+	if fp != loadedFP {
+		t.Logf("PKIXToPrivWithAAD() loaded another key\n")
+		t.Fail()
+	}
+}
+
+func TestPrivateKeyID(t *testing.T) {
+	i, _ := NewIdentityKey(KEYRSA)
+	id, err := i.PrivateKeyID()
+	if err != nil {
+		t.Fatalf("PrivateKeyID() error: %v\n", err)
+	}
+
+	// the same key through PKCS#8 and OpenSSH.
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(i.rsa)
+	fromPKCS8, _ := x509.ParsePKCS8PrivateKey(pkcs8)
+	sshBlock, err := ssh.MarshalPrivateKey(i.rsa, "")
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey() error: %v\n", err)
+	}
+	fromSSH, err := ssh.ParseRawPrivateKey(pem.EncodeToMemory(sshBlock))
+	if err != nil {
+		t.Fatalf("ParseRawPrivateKey() error: %v\n", err)
+	}
+
+	// d mod lcm(p-1, q-1) is as valid as d mod (p-1)(q-1).
+	p1 := new(big.Int).Sub(i.rsa.Primes[0], big.NewInt(1))
+	q1 := new(big.Int).Sub(i.rsa.Primes[1], big.NewInt(1))
+	gcd := new(big.Int).GCD(nil, nil, p1, q1)
+	lambda := new(big.Int).Div(new(big.Int).Mul(p1, q1), gcd)
+	swapped := &rsa.PrivateKey{
+		PublicKey: i.rsa.PublicKey,
+		D:         new(big.Int).Mod(i.rsa.D, lambda),
+		Primes:    []*big.Int{i.rsa.Primes[1], i.rsa.Primes[0]},
+	}
+
+	for name, priv := range map[string]interface{}{"PKCS#8": fromPKCS8, "OpenSSH": fromSSH, "lambda": swapped} {
+		other := &IdentityKey{keyType: KEYRSA, rsa: priv.(*rsa.PrivateKey)}
+		otherID, err := other.PrivateKeyID()
+		if err != nil || otherID != id {
+			t.Logf("%s PrivateKeyID() differs: %v\n", name, err)
+			t.Fail()
+		}
+	}
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		other, _ := NewIdentityKey(keytype)
+		otherID, _ := other.PrivateKeyID()
+		if otherID == id || len(otherID) != 64 {
+			t.Logf("%s PrivateKeyID() SHOULD differ: %s\n", other.Type(), otherID)
+			t.Fail()
+		}
+	}
+}
+
+func TestSPKI(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		keyBin, _ := i.pubKeyBin()
+		pub := &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin}
+
+		der, err := pub.SPKIBytes()
+		if err != nil {
+			t.Fatalf("%s SPKIBytes() error: %v\n", i.Type(), err)
+		}
+		stdPub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			t.Fatalf("%s ParsePKIXPublicKey() error: %v\n", i.Type(), err)
+		}
+		same, ok := stdPub.(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !same.Equal(i.public()) {
+			t.Logf("%s SPKIBytes() is another key\n", i.Type())
+			t.Fail()
+		}
+
+		parsed, err := IdentityPublicKeyFromSPKI(der)
+		if err != nil || parsed.KeyType != keytype || !bytes.Equal(parsed.KeyBin, keyBin) {
+			t.Logf("%s IdentityPublicKeyFromSPKI() error: %v\n", i.Type(), err)
+			t.Fail()
+		}
+	}
+}
+
+func TestPubToStandardPEM(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		buf := new(bytes.Buffer)
+		err := i.PubToStandardPEM(buf)
+		if err != nil {
+			t.Fatalf("%s PubToStandardPEM() error: %v\n", i.Type(), err)
+		}
+		if !strings.HasPrefix(buf.String(), "-----BEGIN PUBLIC KEY-----\n") {
+			t.Logf("%s PubToStandardPEM() wrote:\n%s\n", i.Type(), buf.String())
+			t.Fail()
+		}
+
+		// with some other block first, and CRLF.
+		data := "-----BEGIN CERTIFICATE-----\r\nAAAA\r\n-----END CERTIFICATE-----\r\n" + strings.Replace(buf.String(), "\n", "\r\n", -1)
+		pub, err := IdentityPublicKeyFromPEM([]byte(data))
+		if err != nil {
+			t.Fatalf("%s IdentityPublicKeyFromPEM() error: %v\n", i.Type(), err)
+		}
+		keyBin, _ := i.pubKeyBin()
+		if pub.KeyType != keytype || !bytes.Equal(pub.KeyBin, keyBin) {
+			t.Logf("%s IdentityPublicKeyFromPEM() returned another key\n", i.Type())
+			t.Fail()
+		}
+	}
+
+	_, err := IdentityPublicKeyFromPEM([]byte("ic-25519 AAAA owner\n"))
+	if err == nil {
+		t.Logf("IdentityPublicKeyFromPEM() SHOULD fail without PEM\n")
+		t.Fail()
+	}
+}
+
+func TestDeriveSubkey(t *testing.T) {
+	master, _ := NewIdentityKey(KEYEC25519)
+
+	a, err := master.DeriveSubkey("signing")
+	if err != nil {
+		t.Fatalf("DeriveSubkey() error: %v\n", err)
+	}
+	again, err := master.DeriveSubkey("signing")
+	if err != nil {
+		t.Fatalf("DeriveSubkey() error: %v\n", err)
+	}
+	b, err := master.DeriveSubkey("encryption")
+	if err != nil {
+		t.Fatalf("DeriveSubkey() error: %v\n", err)
+	}
+
+	fpMaster, _ := master.Fingerprint()
+	fpA, _ := a.Fingerprint()
+	fpAgain, _ := again.Fingerprint()
+	fpB, _ := b.Fingerprint()
+	if fpA != fpAgain {
+		t.Logf("DeriveSubkey() SHOULD give the same key for the same label\n")
+		t.Fail()
+	}
+	if fpA == fpB || fpA == fpMaster || fpB == fpMaster {
+		t.Logf("DeriveSubkey() SHOULD give distinct keys for distinct labels\n")
+		t.Fail()
+	}
+
+	sig, err := a.SignWithContext("subkey", rand.Reader, []byte("message"))
+	if err != nil {
+		t.Fatalf("SignWithContext() error: %v\n", err)
+	}
+	if again.VerifyWithContext("subkey", []byte("message"), sig) != nil {
+		t.Logf("re-derived subkey does not verify the subkey signature\n")
+		t.Fail()
+	}
+
+	rsaKey, _ := NewIdentityKey(KEYRSA)
+	if _, err = rsaKey.DeriveSubkey("signing"); err == nil {
+		t.Logf("DeriveSubkey() SHOULD fail on an RSA key\n")
+		t.Fail()
+	}
+	if _, err = new(IdentityKey).DeriveSubkey("signing"); err == nil {
+		t.Logf("DeriveSubkey() SHOULD fail on an uninitialized key\n")
+		t.Fail()
+	}
+	master.Destroy()
+	if _, err = master.DeriveSubkey("signing"); err != ErrDestroyed {
+		t.Logf("DeriveSubkey() SHOULD fail on a destroyed key: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestFromMultiPEM(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	passwd := []byte("passphrase")
+
+	var fps []string
+	data := new(bytes.Buffer)
+	for k, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		pw := passwd
+		if k == 1 {
+			pw = []byte("other")
+		} else {
+			fp, _ := i.Fingerprint()
+			fps = append(fps, fp)
+		}
+		err := i.PrivToPKIX(data, pw)
+		if err != nil {
+			t.Fatalf("PrivToPKIX() error: %v\n", err)
+		}
+	}
+	data.WriteString("trailing garbage\n-----BEGIN nothing\n")
+
+	keys, err := FromMultiPEM(data.Bytes(), passwd)
+	if len(keys) != 2 {
+		t.Fatalf("FromMultiPEM() loaded %d keys\n", len(keys))
+	}
+	for k, i := range keys {
+		if fp, _ := i.Fingerprint(); fp != fps[k] {
+			t.Logf("FromMultiPEM() key %d is not the one written\n", k)
+			t.Fail()
+		}
+	}
+	errs, ok := err.(MultiPEMError)
+	if !ok || len(errs) != 1 || errs[0].Index != 1 || errs[0].Err != ErrBadPassphrase {
+		t.Logf("FromMultiPEM() error: %v\n", err)
+		t.Fail()
+	}
+
+	keys, err = FromMultiPEM([]byte("no PEM at all"), passwd)
+	if len(keys) != 0 || err != nil {
+		t.Logf("FromMultiPEM(no PEM) = %d keys, %v\n", len(keys), err)
+		t.Fail()
+	}
+}
+
+func TestCheckKeyFiles(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	dir := t.TempDir()
+	passwd := []byte("passphrase")
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		prefix := filepath.Join(dir, K2S[keytype])
+		_, err := GenerateAndSave(prefix, keytype, KeyParams{}, passwd, false)
+		if err != nil {
+			t.Fatalf("GenerateAndSave() error: %v\n", err)
+		}
+
+		err = CheckKeyFiles(prefix, passwd)
+		if err != nil {
+			t.Logf("%s CheckKeyFiles() error: %v\n", K2S[keytype], err)
+			t.Fail()
+		}
+		err = CheckKeyFiles(prefix, []byte("wrong"))
+		if err != ErrBadPassphrase {
+			t.Logf("%s CheckKeyFiles() SHOULD fail with ErrBadPassphrase: %v\n", K2S[keytype], err)
+			t.Fail()
+		}
+
+		// the public key file of another key of the same type.
+		other, _ := NewIdentityKey(keytype)
+		pubFile, _ := os.Create(prefix + ".pub")
+		other.PubToPKIX(pubFile)
+		pubFile.Close()
+		if CheckKeyFiles(prefix, passwd) == nil {
+			t.Logf("%s CheckKeyFiles() SHOULD fail on a mismatched pair\n", K2S[keytype])
+			t.Fail()
+		}
+
+		os.Remove(prefix + ".pub")
+		err = CheckKeyFiles(prefix, passwd)
+		if !os.IsNotExist(err) {
+			t.Logf("%s CheckKeyFiles() SHOULD fail on a missing public key file: %v\n", K2S[keytype], err)
+			t.Fail()
+		}
+	}
+
+	err := CheckKeyFiles(filepath.Join(dir, "missing"), passwd)
+	if !os.IsNotExist(err) {
+		t.Logf("CheckKeyFiles() SHOULD fail on missing files: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestCompactString(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		s, err := i.PubToCompactString()
+		if err != nil {
+			t.Fatalf("%s PubToCompactString() error: %v\n", K2S[keytype], err)
+		}
+
+		pub, err := ParseCompactString(strings.ToLower(s))
+		if err != nil {
+			t.Fatalf("%s ParseCompactString() error: %v\n", K2S[keytype], err)
+		}
+		keyBin, _ := i.pubKeyBin()
+		if pub.KeyType != keytype || !bytes.Equal(pub.KeyBin, keyBin) {
+			t.Logf("%s ParseCompactString() gives another key\n", K2S[keytype])
+			t.Fail()
+		}
+		if again, _ := pub.PubToCompactString(); again != s {
+			t.Logf("%s PubToCompactString() is not stable: %s\n", K2S[keytype], again)
+			t.Fail()
+		}
+
+		bad := map[string]string{
+			"truncated":  s[:len(s)-8],
+			"prefix":     "IC2" + s[len(compactPrefix):],
+			"not base32": s[:10] + "1" + s[11:],
+			"empty":      compactPrefix,
+			"key type":   compactPrefix + compactEncoding.EncodeToString([]byte{9, 1, 2, 3}),
+			"other type": compactPrefix + compactEncoding.EncodeToString(append([]byte{byte((keytype + 1) % 3)}, mustDecodeCompact(t, s)[1:]...)),
+			"trailing":   s + "AAAA",
+		}
+		for name, b := range bad {
+			_, err = ParseCompactString(b)
+			if err == nil {
+				t.Logf("%s ParseCompactString(%s) SHOULD fail\n", K2S[keytype], name)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func mustDecodeCompact(t *testing.T, s string) []byte {
+	buf, err := compactEncoding.DecodeString(s[len(compactPrefix):])
+	if err != nil {
+		t.Fatalf("compact string decode error: %v\n", err)
+	}
+	return buf
+}
+
+func TestNewIdentityKeyByLevel(t *testing.T) {
+	i, err := NewIdentityKeyByLevel(SecurityStandard)
+	if err != nil {
+		t.Fatalf("NewIdentityKeyByLevel(SecurityStandard) error: %v\n", err)
+	}
+	if i.keyType != KEYEC25519 || i.ec25519 == nil {
+		t.Logf("SecurityStandard gives a %s key\n", i.Type())
+		t.Fail()
+	}
+
+	i, err = NewIdentityKeyByLevel(SecurityHigh)
+	if err != nil {
+		t.Fatalf("NewIdentityKeyByLevel(SecurityHigh) error: %v\n", err)
+	}
+	if i.keyType != KEYRSA || i.rsa == nil || i.rsa.N.BitLen() != KEYSIZE_RSA || KEYSIZE_RSA < 4096 {
+		t.Logf("SecurityHigh gives a %s key\n", i.Type())
+		t.Fail()
+	}
+
+	for _, level := range []SecurityLevel{-1, SecurityHigh + 1} {
+		_, err = NewIdentityKeyByLevel(level)
+		if err == nil {
+			t.Logf("NewIdentityKeyByLevel(%d) SHOULD fail\n", level)
+			t.Fail()
+		}
+	}
+}
+
+func TestRevocationList(t *testing.T) {
+	revoked, _ := NewIdentityKey(KEYEC25519)
+	kept, _ := NewIdentityKey(KEYECDSA)
+	fp, _ := revoked.Fingerprint()
+
+	path := filepath.Join(t.TempDir(), "revoked")
+	content := "# revoked keys\r\n\n  " + fp + "  \r\n# " + strings.Repeat("0", len(fp)) + "\n\n"
+	ioutil.WriteFile(path, []byte(content), 0600)
+
+	list, err := LoadRevocationList(path)
+	if err != nil {
+		t.Fatalf("LoadRevocationList() error: %v\n", err)
+	}
+	if len(list) != 1 || list[0] != fp {
+		t.Fatalf("LoadRevocationList() = %q\n", list)
+	}
+
+	keyBin, _ := revoked.pubKeyBin()
+	if !revoked.IsRevoked(list) || !(&IdentityPublicKey{KeyType: KEYEC25519, KeyBin: keyBin}).IsRevoked(list) {
+		t.Logf("IsRevoked() SHOULD find the listed key\n")
+		t.Fail()
+	}
+	keyBin, _ = kept.pubKeyBin()
+	if kept.IsRevoked(list) || (&IdentityPublicKey{KeyType: KEYECDSA, KeyBin: keyBin}).IsRevoked(list) {
+		t.Logf("IsRevoked() SHOULD NOT find a key which is not listed\n")
+		t.Fail()
+	}
+	if revoked.IsRevoked(nil) {
+		t.Logf("IsRevoked() SHOULD NOT find a key in an empty list\n")
+		t.Fail()
+	}
+
+	_, err = LoadRevocationList(filepath.Join(t.TempDir(), "missing"))
+	if !os.IsNotExist(err) {
+		t.Logf("LoadRevocationList() SHOULD fail on a missing file: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestRawPrivateAccessors(t *testing.T) {
+	raw := func(i *IdentityKey) (rsaOK, ecdsaOK, seedOK bool) {
+		_, rsaOK = i.RSAPrivate()
+		_, ecdsaOK = i.ECDSAPrivate()
+		_, seedOK = i.Ed25519Seed()
+		return
+	}
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		rsaOK, ecdsaOK, seedOK := raw(i)
+		if rsaOK != (keytype == KEYRSA) || ecdsaOK != (keytype == KEYECDSA) || seedOK != (keytype == KEYEC25519) {
+			t.Logf("%s raw accessors = %v, %v, %v\n", i.Type(), rsaOK, ecdsaOK, seedOK)
+			t.Fail()
+		}
+
+		i.Destroy()
+		if rsaOK, ecdsaOK, seedOK = raw(i); rsaOK || ecdsaOK || seedOK {
+			t.Logf("%s raw accessors SHOULD fail after Destroy()\n", K2S[keytype])
+			t.Fail()
+		}
+	}
+
+	i, _ := NewIdentityKey(KEYEC25519)
+	seed, _ := i.Ed25519Seed()
+	if !bytes.Equal(seed, i.ec25519.Priv.Seed()) {
+		t.Logf("Ed25519Seed() is not the key seed\n")
+		t.Fail()
+	}
+	i.WithExpiry(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if rsaOK, ecdsaOK, seedOK := raw(i); rsaOK || ecdsaOK || seedOK {
+		t.Logf("raw accessors SHOULD fail after expiry\n")
+		t.Fail()
+	}
+
+	if rsaOK, ecdsaOK, seedOK := raw(new(IdentityKey)); rsaOK || ecdsaOK || seedOK {
+		t.Logf("raw accessors SHOULD fail on an uninitialized key\n")
+		t.Fail()
+	}
+}
+
+func TestFingerprintEqual(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	other, _ := NewIdentityKey(KEYEC25519)
+	fp, _ := i.Fingerprint()
+	otherFp, _ := other.Fingerprint()
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{fp, fp, true},
+		{fp, strings.ToUpper(fp), true},
+		{fp, otherFp, false},
+		{fp, fp[:len(fp)-2], false},
+		{fp, fp + "00", false},
+		{fp, "", false},
+		{"zz" + fp[2:], "zz" + fp[2:], false},
+		{fp[:len(fp)-1], fp[:len(fp)-1], false},
+	}
+	for _, tt := range tests {
+		if got := FingerprintEqual(tt.a, tt.b); got != tt.want {
+			t.Logf("FingerprintEqual(%q, %q) = %v\n", tt.a, tt.b, got)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// KeyInfo describes a key for display, it never holds secret material.
+type KeyInfo struct {
+	Type        string
+	BitLen      int
+	Curve       string
+	Fingerprint string
+	Owner       string
+	HasPrivate  bool
+	// Created is the zero time when unknown, ic keys do not store it.
+	Created time.Time
+}
+
+// String returns a multi-line human readable summary.
+func (k KeyInfo) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "type: %s\n", k.Type)
+	if len(k.Curve) > 0 {
+		fmt.Fprintf(&b, "curve: %s\n", k.Curve)
+	}
+	fmt.Fprintf(&b, "bits: %d\n", k.BitLen)
+	fmt.Fprintf(&b, "fingerprint: %s\n", k.Fingerprint)
+	if len(k.Owner) > 0 {
+		fmt.Fprintf(&b, "owner: %s\n", k.Owner)
+	}
+	fmt.Fprintf(&b, "private: %t\n", k.HasPrivate)
+	if !k.Created.IsZero() {
+		fmt.Fprintf(&b, "created: %s\n", k.Created.UTC().Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// Describe returns the key details.
+func (i *IdentityKey) Describe() (KeyInfo, error) {
+	keyBin, err := i.pubKeyBin()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	info, err := describe(i.keyType, keyBin, i.public())
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if i.keyOwner != nil {
+		info.Owner = i.keyOwner.String()
+	}
+	info.HasPrivate = i.hasPrivate()
+	return info, nil
+}
+
+// Describe returns the key details.
+func (p *IdentityPublicKey) Describe() (KeyInfo, error) {
+	pub, err := p.CryptoPublicKey()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	info, err := describe(p.KeyType, p.KeyBin, pub)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	info.Owner = p.KeyOwner
+	return info, nil
+}
+
+func describe(keyType int, keyBin []byte, pub crypto.PublicKey) (info KeyInfo, err error) {
+	info.Type = K2S[keyType]
+	info.Fingerprint, err = pubFingerprint(keyBin)
+	if err != nil {
+		return
+	}
+	// registered types may reuse a Go key type, only their header means
+	// something.
+	if customKeyType(keyType) != nil {
+		return
+	}
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		info.BitLen = k.N.BitLen()
+	case *ecdsa.PublicKey:
+		info.BitLen = k.Curve.Params().BitSize
+		info.Curve = k.Curve.Params().Name
+	case ed25519.PublicKey:
+		info.BitLen = 256
+		info.Curve = "Ed25519"
+	default:
+		err = errors.New("invalid key type")
+	}
+	return
+}
@@ -0,0 +1,471 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	stded25519 "crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/sha3"
+)
+
+// interopSign signs msg under ctx with the standard library only, the way
+// SignWithContext is specified.
+func interopSign(t *testing.T, priv crypto.Signer, ctx string, msg []byte) []byte {
+	digest := sha3.Sum256(contextMessage(ctx, msg))
+
+	var sig []byte
+	var err error
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA3_256, digest[:], nil)
+	case *ecdsa.PrivateKey:
+		sig, err = ecdsa.SignASN1(rand.Reader, k, digest[:])
+	case stded25519.PrivateKey:
+		sig = stded25519.Sign(k, contextMessage(ctx, msg))
+	}
+	if err != nil {
+		t.Fatalf("interopSign() error: %v\n", err)
+	}
+	return sig
+}
+
+// interopVerify verifies a SignWithContext signature with the standard
+// library only.
+func interopVerify(pub crypto.PublicKey, ctx string, msg, sig []byte) bool {
+	digest := sha3.Sum256(contextMessage(ctx, msg))
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPSS(k, crypto.SHA3_256, digest[:], sig, nil) == nil
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case stded25519.PublicKey:
+		return stded25519.Verify(k, contextMessage(ctx, msg), sig)
+	}
+	return false
+}
+
+func TestSignInteroperability(t *testing.T) {
+	msg := []byte("message")
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, err := NewIdentityKey(keytype)
+		if err != nil {
+			t.Fatalf("NewIdentityKey() error: %v\n", err)
+		}
+
+		var priv crypto.Signer
+		switch keytype {
+		case KEYRSA:
+			priv = i.rsa
+		case KEYECDSA:
+			priv = i.ecdsa
+		case KEYEC25519:
+			priv = i.ec25519.Priv
+		}
+
+		// ic -> standard library
+		sig, err := i.SignWithContext("interop", rand.Reader, msg)
+		if err != nil {
+			t.Fatalf("SignWithContext() error: %v\n", err)
+		}
+		if !interopVerify(priv.Public(), "interop", msg, sig) {
+			t.Logf("%s: standard library rejects the ic signature\n", i.Type())
+			t.Fail()
+		}
+
+		// standard library -> ic
+		sig = interopSign(t, priv, "interop", msg)
+		err = i.VerifyWithContext("interop", msg, sig)
+		if err != nil {
+			t.Logf("%s: ic rejects the standard library signature: %v\n", i.Type(), err)
+			t.Fail()
+		}
+	}
+
+	// Ed25519ph both ways.
+	i, err := NewIdentityKey(KEYEC25519)
+	if err != nil {
+		t.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+	digest := sha512.Sum512(msg)
+	opts := &stded25519.Options{Hash: crypto.SHA512, Context: "interop"}
+
+	sig, err := i.SignPrehashed("interop", digest[:])
+	if err != nil {
+		t.Fatalf("SignPrehashed() error: %v\n", err)
+	}
+	if stded25519.VerifyWithOptions(i.ec25519.Pub, digest[:], sig, opts) != nil {
+		t.Logf("standard library rejects the ic Ed25519ph signature\n")
+		t.Fail()
+	}
+
+	sig, err = i.ec25519.Priv.Sign(nil, digest[:], opts)
+	if err != nil {
+		t.Fatalf("ed25519 Sign() error: %v\n", err)
+	}
+	if i.VerifyPrehashed("interop", digest[:], sig) != nil {
+		t.Logf("ic rejects the standard library Ed25519ph signature\n")
+		t.Fail()
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	msg := []byte("message")
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		keyBin, _ := i.pubKeyBin()
+		pub := &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin}
+
+		for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA3_256, crypto.SHA512} {
+			sig, err := i.SignMessage(msg, hash)
+			if err != nil {
+				t.Fatalf("%s SignMessage() error: %v\n", i.Type(), err)
+			}
+
+			err = pub.VerifyMessage(msg, sig, hash)
+			if err != nil {
+				t.Logf("%s/%s VerifyMessage() error: %v\n", i.Type(), hash, err)
+				t.Fail()
+			}
+			if pub.VerifyMessage([]byte("other"), sig, hash) == nil {
+				t.Logf("%s/%s VerifyMessage() SHOULD fail on another message\n", i.Type(), hash)
+				t.Fail()
+			}
+
+			// plain standard library verification of the same message.
+			digest, _ := messageDigest(msg, hash)
+			var ok bool
+			switch k := i.public().(type) {
+			case *rsa.PublicKey:
+				ok = rsa.VerifyPSS(k, hash, digest, sig, nil) == nil
+			case *ecdsa.PublicKey:
+				ok = ecdsa.VerifyASN1(k, digest, sig)
+			case stded25519.PublicKey:
+				ok = stded25519.Verify(k, msg, sig)
+			}
+			if !ok {
+				t.Logf("%s/%s standard library rejects SignMessage\n", i.Type(), hash)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestSignMessageRSAKeySize(t *testing.T) {
+	k2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error: %v\n", err)
+	}
+	i := &IdentityKey{keyType: KEYRSA, rsa: k2048}
+
+	msg := []byte("message")
+	sig, err := i.SignMessage(msg, crypto.SHA512)
+	if err != nil {
+		t.Fatalf("2048 bits SignMessage(SHA512) error: %v\n", err)
+	}
+	err = i.VerifyMessage(msg, sig, crypto.SHA512)
+	if err != nil {
+		t.Logf("2048 bits VerifyMessage(SHA512) error: %v\n", err)
+		t.Fail()
+	}
+
+	// the salt shrinks to what is left: 62 bytes.
+	k1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error: %v\n", err)
+	}
+	i = &IdentityKey{keyType: KEYRSA, rsa: k1024}
+	sig, err = i.SignMessage(msg, crypto.SHA512)
+	if err != nil {
+		t.Fatalf("1024 bits SignMessage(SHA512) error: %v\n", err)
+	}
+	err = i.VerifyMessage(msg, sig, crypto.SHA512)
+	if err != nil {
+		t.Logf("1024 bits VerifyMessage(SHA512) error: %v\n", err)
+		t.Fail()
+	}
+
+	// 64+2 bytes do not fit in 512 bits, the check comes before the key is
+	// used: only its size matters.
+	n512 := new(big.Int).Lsh(big.NewInt(1), 511)
+	i = &IdentityKey{keyType: KEYRSA, rsa: &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: n512, E: 65537}}}
+	_, err = i.SignMessage(msg, crypto.SHA512)
+	if err != ErrHashTooLargeForKey {
+		t.Logf("512 bits SignMessage(SHA512) error: %v\n", err)
+		t.Fail()
+	}
+}
+
+// rfc8032Vectors are the Ed25519 test vectors of RFC 8032 section 7.1:
+// secret key (seed), public key, message, signature.
+var rfc8032Vectors = []struct {
+	seed, pub, msg, sig string
+}{
+	{
+		"9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60",
+		"d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		"",
+		"e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b",
+	},
+	{
+		"4ccd089b28ff96da9db6c346ec114e0f5b8a319f35aba624da8cf6ed4fb8a6fb",
+		"3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+		"72",
+		"92a009a9f0d4cab8720e820b5f642540a2b27b5416503f8fb3762223ebdb69da085ac1e43e15996e458f3613d0f11d8c387b2eaeb4302aeeb00d291612bb0c00",
+	},
+	{
+		"c5aa8df43f9f837bedb7442f31dcb7b166d38535076f094b85ce3a2e0b4458f7",
+		"fc51cd8e6218a1a38da47ed00230f0580816ed13ba3303ac5deb911548908025",
+		"af82",
+		"6291d657deec24024827e69c3abe01a30ce548a284743a445e3680d7db5ac3ac18ff9b538d16f290ae67f760984dc6594a7c15e9716ed28dc027beceea1ec40a",
+	},
+}
+
+// rfc8032Key loads the EC25519 key of seed through the private key file
+// format, as FromKeyFiles would.
+func rfc8032Key(t *testing.T, seed []byte) *IdentityKey {
+	der, err := asn1.Marshal([]byte(ed25519.NewKeyFromSeed(seed)))
+	if err != nil {
+		t.Fatalf("asn1.Marshal() error: %v\n", err)
+	}
+	i := new(IdentityKey)
+	err = i.derToPriv(PEMHDR_25519, der)
+	if err != nil {
+		t.Fatalf("derToPriv() error: %v\n", err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = i.PrivToPKIX(buf, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("PrivToPKIX() error: %v\n", err)
+	}
+	loaded := new(IdentityKey)
+	err = loaded.PKIXToPriv(buf, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("PKIXToPriv() error: %v\n", err)
+	}
+	return loaded
+}
+
+func TestRFC8032Vectors(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+
+	for n, v := range rfc8032Vectors {
+		seed, _ := hex.DecodeString(v.seed)
+		pubBytes, _ := hex.DecodeString(v.pub)
+		msg, _ := hex.DecodeString(v.msg)
+		expected, _ := hex.DecodeString(v.sig)
+
+		pub, err := IdentityPublicKeyFromCrypto(ed25519.PublicKey(pubBytes))
+		if err != nil {
+			t.Fatalf("vector %d: IdentityPublicKeyFromCrypto() error: %v\n", n, err)
+		}
+		err = pub.VerifyMessage(msg, expected, 0)
+		if err != nil {
+			t.Logf("vector %d: VerifyMessage() error: %v\n", n, err)
+			t.Fail()
+		}
+		err = pub.VerifyMessage(append(msg, 0), expected, 0)
+		if err == nil {
+			t.Logf("vector %d: VerifyMessage() SHOULD fail on another message\n", n)
+			t.Fail()
+		}
+
+		i := rfc8032Key(t, seed)
+		if !bytes.Equal(i.ec25519.Pub, pubBytes) {
+			t.Logf("vector %d: public key %x\n", n, i.ec25519.Pub)
+			t.Fail()
+		}
+		sig, err := i.SignMessage(msg, 0)
+		if err != nil {
+			t.Fatalf("vector %d: SignMessage() error: %v\n", n, err)
+		}
+		if !bytes.Equal(sig, expected) {
+			t.Logf("vector %d: SignMessage() signature %x\n", n, sig)
+			t.Fail()
+		}
+	}
+}
+
+// RFC 8032 section 7.3, Ed25519ph of "abc".
+func TestRFC8032PrehashedVector(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+
+	seed, _ := hex.DecodeString("833fe62409237b9d62ec77587520911e9a759cec1d19755b7da901b96dca3d42")
+	expected, _ := hex.DecodeString("98a70222f0b8121aa9d30f813d683f809e462b469c7ff87639499bb94e6dae4131f85042463c2a355a2003d062adf5aaa10b8c61e636062aaad11c2a26083406")
+	digest := sha512.Sum512([]byte("abc"))
+
+	i := rfc8032Key(t, seed)
+	sig, err := i.SignPrehashed("", digest[:])
+	if err != nil {
+		t.Fatalf("SignPrehashed() error: %v\n", err)
+	}
+	if !bytes.Equal(sig, expected) {
+		t.Logf("SignPrehashed() signature %x\n", sig)
+		t.Fail()
+	}
+	err = i.VerifyPrehashed("", digest[:], expected)
+	if err != nil {
+		t.Logf("VerifyPrehashed() error: %v\n", err)
+		t.Fail()
+	}
+}
+
+var formatNames = map[Format]string{
+	FormatICLine:  "ic",
+	FormatOpenSSH: "openssh",
+	FormatPEM:     "pem",
+	FormatJWK:     "jwk",
+	FormatDER:     "der",
+}
+
+// every format converts to every other one, and back.
+func TestConvertPublic(t *testing.T) {
+	for _, keyType := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, err := NewIdentityKey(keyType)
+		if err != nil {
+			t.Fatalf("NewIdentityKey(%d) error: %v\n", keyType, err)
+		}
+		buf := new(bytes.Buffer)
+		i.PubToPKIX(buf)
+		line := buf.Bytes()
+
+		for inFormat, inName := range formatNames {
+			in, err := ConvertPublic(line, inFormat)
+			if err != nil {
+				t.Fatalf("%s ConvertPublic(%s) error: %v\n", K2S[keyType], inName, err)
+			}
+
+			for outFormat, outName := range formatNames {
+				out, err := ConvertPublic(in, outFormat)
+				if err != nil {
+					t.Logf("%s ConvertPublic(%s -> %s) error: %v\n", K2S[keyType], inName, outName, err)
+					t.Fail()
+					continue
+				}
+				same, err := SamePublicKey(out, line)
+				if err != nil || !same {
+					t.Logf("%s ConvertPublic(%s -> %s) changed the key: %v\n", K2S[keyType], inName, outName, err)
+					t.Fail()
+				}
+				if inFormat == outFormat && !bytes.Equal(in, out) {
+					t.Logf("%s ConvertPublic(%s -> %s) is not stable\n", K2S[keyType], inName, outName)
+					t.Fail()
+				}
+
+				// only ic lines carry the owner.
+				if outFormat == FormatICLine {
+					pub, err := parsePublicFile(out)
+					if err != nil {
+						t.Fatalf("parsePublicFile() error: %v\n", err)
+					}
+					owner := nilOwner
+					if inFormat == FormatICLine {
+						owner = i.keyOwner.String()
+					}
+					if pub.KeyOwner != owner {
+						t.Logf("%s ConvertPublic(%s -> ic) owner: %s\n", K2S[keyType], inName, pub.KeyOwner)
+						t.Fail()
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestConvertPublicInvalid(t *testing.T) {
+	i, _ := NewIdentityKey(KEYECDSA)
+	spki, _ := x509.MarshalPKIXPublicKey(&i.ecdsa.PublicKey)
+	n := b64url.EncodeToString(bytes.Repeat([]byte{0xc3}, 256))
+
+	// the modulus is fine, only e is checked below.
+	_, err := ConvertPublic([]byte(`{"kty":"RSA","n":"`+n+`","e":"AQAB"}`), FormatPEM)
+	if err != nil {
+		t.Fatalf("ConvertPublic(e = 65537) error: %v\n", err)
+	}
+
+	for name, in := range map[string]string{
+		"empty":           "  \n",
+		"off-curve point": `{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}`,
+		"unknown curve":   `{"kty":"EC","crv":"P-192","x":"AQ","y":"AQ"}`,
+		"e = 1":           `{"kty":"RSA","n":"` + n + `","e":"AQ"}`,
+		"e = 2^31":        `{"kty":"RSA","n":"` + n + `","e":"gAAAAA"}`,
+		"e = 65536":       `{"kty":"RSA","n":"` + n + `","e":"AQAA"}`,
+		"short Ed25519":   `{"kty":"OKP","crv":"Ed25519","x":"AQ"}`,
+		"X25519":          `{"kty":"OKP","crv":"X25519","x":"` + b64url.EncodeToString(make([]byte, 32)) + `"}`,
+		"unknown kty":     `{"kty":"oct","k":"AQ"}`,
+		"PEM type":        string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: spki})),
+		"PEM garbage":     string(pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: []byte("garbage")})),
+		"DER garbage":     "\x30\x03\x02\x01\x01",
+		"OpenSSH garbage": "ssh-ed25519 AAAA",
+	} {
+		_, err := ConvertPublic([]byte(in), FormatPEM)
+		if err == nil {
+			t.Logf("ConvertPublic(%s) SHOULD fail\n", name)
+			t.Fail()
+		}
+	}
+
+	_, err = ConvertPublic(spki, Format(-1))
+	if err == nil {
+		t.Logf("ConvertPublic(invalid format) SHOULD fail\n")
+		t.Fail()
+	}
+}
+
+// ssh-keygen -lv output (OpenSSH 9.2) for:
+// ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKHe4fLwJAWLky4nWSuSNJzI4s51owm6RGzwdv7EDnH9
+const opensshRandomArt = `+--[ED25519 256]--+
+|          oo. =oO|
+|           +o..Bo|
+|          o..+ oo|
+|       o  ..o .o.|
+|      o So o  . o|
+|       .+o+ o. +.|
+|      .. *.*  o B|
+|     .. . + + .o+|
+|      ..   . Eo+o|
++----[SHA256]-----+`
+
+func TestRandomArtOpenSSH(t *testing.T) {
+	blob, _ := base64.StdEncoding.DecodeString("AAAAC3NzaC1lZDI1NTE5AAAAIKHe4fLwJAWLky4nWSuSNJzI4s51owm6RGzwdv7EDnH9")
+	fp := sha256.Sum256(blob)
+	if base64.RawStdEncoding.EncodeToString(fp[:]) != "D5jr0w0nvaVQNRCbRzcnF+VQfaJO3WP7IV+/7BerwNs" {
+		t.Fatalf("unexpected OpenSSH fingerprint\n")
+	}
+
+	art := drunkenBishop(fp[:], "[ED25519 256]", "[SHA256]")
+	if art != opensshRandomArt {
+		t.Logf("drunkenBishop():\n%s\nexpected:\n%s\n", art, opensshRandomArt)
+		t.Fail()
+	}
+
+	i, _ := NewIdentityKey(KEYEC25519)
+	art, err := i.RandomArt()
+	if err != nil {
+		t.Fatalf("RandomArt() error: %v\n", err)
+	}
+	lines := strings.Split(art, "\n")
+	if len(lines) != randomartHeight+2 || lines[0] != "+---[IC-25519]----+" || lines[len(lines)-1] != "+---[SHA3-256]----+" {
+		t.Logf("RandomArt():\n%s\n", art)
+		t.Fail()
+	}
+}
@@ -0,0 +1,77 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+)
+
+// jsonContext is the SignWithContext label of JSON signatures.
+const jsonContext = "ic-json"
+
+// CanonicalJSON returns the canonical JSON encoding of v, that is what
+// SignJSON signs. The rules are:
+//   - v is first encoded with encoding/json, then re-encoded as follows,
+//   - no insignificant whitespace at all,
+//   - object members are sorted by key, comparing the UTF-8 bytes,
+//   - strings are UTF-8 with only '"', '\' and the control characters
+//     (< 0x20) escaped: \b \f \n \r \t in short form, the others as \u00xx
+//     with lowercase hex, and U+2028 / U+2029 as \u2028 / \u2029; invalid
+//     UTF-8 is replaced by U+FFFD,
+//   - numbers are kept as written by the first encoding, i.e. integers as
+//     is and floats in the shortest form that round trips (ES6 style),
+//   - true, false and null as is.
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// decoding to generic values sorts the object keys when encoding again.
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic interface{}
+	err = dec.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(generic)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encode() adds a newline.
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// SignJSON signs the canonical JSON encoding (see CanonicalJSON) of v, the
+// same object signs the same whatever its fields order.
+func (i *IdentityKey) SignJSON(v interface{}) ([]byte, error) {
+	msg, err := CanonicalJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return i.SignWithContext(jsonContext, rand.Reader, msg)
+}
+
+// VerifyJSON checks sig is a SignJSON signature of v.
+func (i *IdentityKey) VerifyJSON(v interface{}, sig []byte) error {
+	msg, err := CanonicalJSON(v)
+	if err != nil {
+		return err
+	}
+	return i.VerifyWithContext(jsonContext, msg, sig)
+}
+
+// VerifyJSON checks sig is a SignJSON signature of v.
+func (p *IdentityPublicKey) VerifyJSON(v interface{}, sig []byte) error {
+	msg, err := CanonicalJSON(v)
+	if err != nil {
+		return err
+	}
+	return p.VerifyWithContext(jsonContext, msg, sig)
+}
@@ -0,0 +1,50 @@
+package ickp
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+)
+
+// keychainService is the keychain service name ic keys are stored under.
+const keychainService = "ic"
+
+// ErrUnsupportedPlatform is returned by the system keychain functions on
+// platforms without keychain support.
+var ErrUnsupportedPlatform = errors.New("not supported on this platform")
+
+// ToKeychain stores the encrypted private key in the system keychain (macOS
+// only) under label. The stored blob is the private key file content.
+func (i *IdentityKey) ToKeychain(label string, passwd []byte) error {
+	buf := new(bytes.Buffer)
+	err := i.PrivToPKIX(buf, passwd)
+	if err != nil {
+		return err
+	}
+	return keychainStore(keychainService, label, buf.Bytes())
+}
+
+// FromKeychain loads the private key stored by ToKeychain under label.
+func FromKeychain(label string, passwd []byte) (*IdentityKey, error) {
+	blob, err := keychainLoad(keychainService, label)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(normalizeNewlines(blob))
+	if block == nil {
+		return nil, errors.New("no PEM found")
+	}
+
+	i := new(IdentityKey)
+	err = i.pemToPriv(block, passwd)
+	if err == nil {
+		err = i.Validate()
+	}
+	if err != nil {
+		i.wipe()
+		return nil, err
+	}
+
+	return i, nil
+}
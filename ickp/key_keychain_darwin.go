@@ -0,0 +1,110 @@
+// +build darwin,cgo
+
+package ickp
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+
+#include <stdlib.h>
+#include <string.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+static CFMutableDictionaryRef ic_keychain_query(const char *service, const char *account) {
+	CFMutableDictionaryRef q = CFDictionaryCreateMutable(kCFAllocatorDefault, 0,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	CFStringRef s = CFStringCreateWithCString(kCFAllocatorDefault, service, kCFStringEncodingUTF8);
+	CFStringRef a = CFStringCreateWithCString(kCFAllocatorDefault, account, kCFStringEncodingUTF8);
+
+	CFDictionarySetValue(q, kSecClass, kSecClassGenericPassword);
+	CFDictionarySetValue(q, kSecAttrService, s);
+	CFDictionarySetValue(q, kSecAttrAccount, a);
+
+	CFRelease(s);
+	CFRelease(a);
+	return q;
+}
+
+// replaces any previous item with the same service / account.
+static OSStatus ic_keychain_store(const char *service, const char *account, const void *blob, int len) {
+	CFMutableDictionaryRef q = ic_keychain_query(service, account);
+	SecItemDelete(q);
+
+	CFDataRef d = CFDataCreate(kCFAllocatorDefault, blob, len);
+	CFDictionarySetValue(q, kSecValueData, d);
+	CFRelease(d);
+
+	OSStatus st = SecItemAdd(q, NULL);
+	CFRelease(q);
+	return st;
+}
+
+// *blob is malloc()ed, the caller frees it.
+static OSStatus ic_keychain_load(const char *service, const char *account, void **blob, int *len) {
+	CFMutableDictionaryRef q = ic_keychain_query(service, account);
+	CFDictionarySetValue(q, kSecReturnData, kCFBooleanTrue);
+	CFDictionarySetValue(q, kSecMatchLimit, kSecMatchLimitOne);
+
+	CFTypeRef res = NULL;
+	OSStatus st = SecItemCopyMatching(q, &res);
+	CFRelease(q);
+	if (st != errSecSuccess) {
+		return st;
+	}
+
+	CFDataRef d = (CFDataRef)res;
+	*len = (int)CFDataGetLength(d);
+	*blob = malloc(*len);
+	memcpy(*blob, CFDataGetBytePtr(d), *len);
+	CFRelease(res);
+	return errSecSuccess;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+func keychainError(st C.OSStatus) error {
+	if st == C.errSecItemNotFound {
+		return errors.New("keychain item not found")
+	}
+	return fmt.Errorf("keychain error %d", int(st))
+}
+
+func keychainStore(service, account string, blob []byte) error {
+	if len(blob) == 0 {
+		return errors.New("empty keychain item")
+	}
+
+	cService := C.CString(service)
+	defer C.free(unsafe.Pointer(cService))
+	cAccount := C.CString(account)
+	defer C.free(unsafe.Pointer(cAccount))
+
+	st := C.ic_keychain_store(cService, cAccount, unsafe.Pointer(&blob[0]), C.int(len(blob)))
+	if st != C.errSecSuccess {
+		return keychainError(st)
+	}
+	return nil
+}
+
+func keychainLoad(service, account string) ([]byte, error) {
+	cService := C.CString(service)
+	defer C.free(unsafe.Pointer(cService))
+	cAccount := C.CString(account)
+	defer C.free(unsafe.Pointer(cAccount))
+
+	var cBlob unsafe.Pointer
+	var cLen C.int
+	st := C.ic_keychain_load(cService, cAccount, &cBlob, &cLen)
+	if st != C.errSecSuccess {
+		return nil, keychainError(st)
+	}
+	defer C.free(cBlob)
+
+	return C.GoBytes(cBlob, cLen), nil
+}
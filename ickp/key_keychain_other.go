@@ -0,0 +1,11 @@
+// +build !darwin !cgo
+
+package ickp
+
+func keychainStore(service, account string, blob []byte) error {
+	return ErrUnsupportedPlatform
+}
+
+func keychainLoad(service, account string) ([]byte, error) {
+	return nil, ErrUnsupportedPlatform
+}
@@ -0,0 +1,31 @@
+package ickp
+
+import (
+	"errors"
+)
+
+// SecurityLevel picks the identity key type for the caller, the mapping
+// follows the package defaults and may change.
+type SecurityLevel int
+
+const (
+	// SecurityStandard is ~128 bits of security: EC25519.
+	SecurityStandard SecurityLevel = iota
+	// SecurityHigh is above 128 bits of security: RSA 4096 (~140 bits).
+	SecurityHigh
+)
+
+// levelKeyTypes maps the security levels to key types.
+var levelKeyTypes = map[SecurityLevel]int{
+	SecurityStandard: KEYEC25519,
+	SecurityHigh:     KEYRSA,
+}
+
+// NewIdentityKeyByLevel generates an identity key of the type matching level.
+func NewIdentityKeyByLevel(level SecurityLevel) (*IdentityKey, error) {
+	keytype, ok := levelKeyTypes[level]
+	if !ok {
+		return nil, errors.New("invalid security level")
+	}
+	return NewIdentityKey(keytype)
+}
@@ -0,0 +1,195 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// keyLogContext is the SignWithContext label of the signed tree heads.
+const keyLogContext = "ic-keylog-sth"
+
+// SignedTreeHead is the signed root of a SignedKeyLog at a given size.
+type SignedTreeHead struct {
+	Size      uint64
+	Root      []byte
+	Signature []byte
+}
+
+// InclusionProof is the audit path (RFC 6962) of the Index-th entry in a
+// tree of Size entries.
+type InclusionProof struct {
+	Index uint64
+	Size  uint64
+	Path  [][]byte
+}
+
+// SignedKeyLog is an append-only, in memory, log of public keys: the entries
+// are the leaves of an RFC 6962 Merkle tree (with SHA3-256) whose root is
+// signed by the log key after every append. It is safe for concurrent use.
+type SignedKeyLog struct {
+	mu     sync.Mutex
+	logKey *IdentityKey
+	leaves [][]byte
+	index  map[string]uint64
+	head   SignedTreeHead
+}
+
+// NewSignedKeyLog returns an empty log signed with logKey.
+func NewSignedKeyLog(logKey *IdentityKey) *SignedKeyLog {
+	return &SignedKeyLog{
+		logKey: logKey,
+		index:  make(map[string]uint64),
+	}
+}
+
+// Append logs pub and returns the new signed tree head, a key is only ever
+// logged once.
+func (l *SignedKeyLog) Append(pub *IdentityPublicKey) (SignedTreeHead, error) {
+	fp, err := pubFingerprint(pub.KeyBin)
+	if err != nil {
+		return SignedTreeHead{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.index[fp]; ok {
+		return SignedTreeHead{}, errors.New("key already logged")
+	}
+
+	leaves := append(l.leaves, keyLogLeafHash(pub.KeyBin))
+	head := SignedTreeHead{Size: uint64(len(leaves)), Root: merkleRoot(leaves)}
+	head.Signature, err = l.logKey.SignWithContext(keyLogContext, rand.Reader, treeHeadMessage(head))
+	if err != nil {
+		return SignedTreeHead{}, err
+	}
+
+	l.index[fp] = uint64(len(l.leaves))
+	l.leaves = leaves
+	l.head = head
+	return head, nil
+}
+
+// TreeHead returns the current signed tree head.
+func (l *SignedKeyLog) TreeHead() SignedTreeHead {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// ProveInclusion returns the inclusion proof of the key with fingerprint fp
+// in the current tree.
+func (l *SignedKeyLog) ProveInclusion(fp string) (*InclusionProof, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	index, ok := l.index[fp]
+	if !ok {
+		return nil, errors.New("key not logged")
+	}
+
+	return &InclusionProof{
+		Index: index,
+		Size:  uint64(len(l.leaves)),
+		Path:  merklePath(index, l.leaves),
+	}, nil
+}
+
+// VerifyInclusion checks head is signed by logPub and that proof proves pub
+// is in the tree of head.
+func VerifyInclusion(logPub *IdentityPublicKey, head SignedTreeHead, pub *IdentityPublicKey, proof *InclusionProof) error {
+	err := logPub.VerifyWithContext(keyLogContext, treeHeadMessage(head), head.Signature)
+	if err != nil {
+		return err
+	}
+
+	if proof.Size != head.Size || proof.Index >= proof.Size {
+		return errors.New("inclusion proof does not match the tree head")
+	}
+
+	// RFC 9162 2.1.3.2
+	fn, sn := proof.Index, proof.Size-1
+	r := keyLogLeafHash(pub.KeyBin)
+	for _, p := range proof.Path {
+		if sn == 0 {
+			return errors.New("invalid inclusion proof")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || subtle.ConstantTimeCompare(r, head.Root) != 1 {
+		return errors.New("invalid inclusion proof")
+	}
+	return nil
+}
+
+// treeHeadMessage is what the log key signs: u64_be(size) || root
+func treeHeadMessage(head SignedTreeHead) []byte {
+	msg := make([]byte, 8, 8+len(head.Root))
+	binary.BigEndian.PutUint64(msg, head.Size)
+	return append(msg, head.Root...)
+}
+
+func keyLogLeafHash(keyBin []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte{0x00})
+	h.Write(keyBin)
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleSplit returns the largest power of 2 smaller than n (n > 1).
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// merkleRoot is MTH() of RFC 6962 over the leaf hashes.
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha3.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleNodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merklePath is PATH() of RFC 6962 over the leaf hashes.
+func merklePath(m uint64, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if m < uint64(k) {
+		return append(merklePath(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merklePath(m-uint64(k), leaves[k:]), merkleRoot(leaves[:k]))
+}
@@ -0,0 +1,72 @@
+package ickp
+
+import (
+	"testing"
+)
+
+func TestSignedKeyLog(t *testing.T) {
+	logKey, err := NewIdentityKey(KEYEC25519)
+	if err != nil {
+		t.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+	logBin, _ := logKey.PubToDER()
+	logPub := &IdentityPublicKey{KeyType: KEYEC25519, KeyBin: logBin}
+
+	log := NewSignedKeyLog(logKey)
+
+	var pubs []*IdentityPublicKey
+	for n := 0; n < 7; n++ {
+		i, err := NewIdentityKey(KEYEC25519)
+		if err != nil {
+			t.Fatalf("NewIdentityKey() error: %v\n", err)
+		}
+		keyBin, _ := i.PubToDER()
+		pub := &IdentityPublicKey{KeyType: KEYEC25519, KeyBin: keyBin}
+		pubs = append(pubs, pub)
+
+		_, err = log.Append(pub)
+		if err != nil {
+			t.Fatalf("Append() error: %v\n", err)
+		}
+	}
+
+	_, err = log.Append(pubs[0])
+	if err == nil {
+		t.Logf("Append() SHOULD fail with an already logged key\n")
+		t.Fail()
+	}
+
+	head := log.TreeHead()
+	for k, pub := range pubs {
+		fp, _ := pubFingerprint(pub.KeyBin)
+		proof, err := log.ProveInclusion(fp)
+		if err != nil {
+			t.Fatalf("ProveInclusion() error: %v\n", err)
+		}
+
+		err = VerifyInclusion(logPub, head, pub, proof)
+		if err != nil {
+			t.Logf("entry %d VerifyInclusion() error: %v\n", k, err)
+			t.Fail()
+		}
+
+		// the proof is for this key only.
+		other := pubs[(k+1)%len(pubs)]
+		err = VerifyInclusion(logPub, head, other, proof)
+		if err == nil {
+			t.Logf("entry %d VerifyInclusion() SHOULD fail with another key\n", k)
+			t.Fail()
+		}
+	}
+
+	// a tampered tree head is rejected.
+	head.Size++
+	fp, _ := pubFingerprint(pubs[0].KeyBin)
+	proof, _ := log.ProveInclusion(fp)
+	proof.Size++
+	err = VerifyInclusion(logPub, head, pubs[0], proof)
+	if err == nil {
+		t.Logf("VerifyInclusion() SHOULD fail with a tampered tree head\n")
+		t.Fail()
+	}
+}
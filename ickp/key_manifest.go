@@ -0,0 +1,181 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// manifestContext is the SignWithContext label of file manifests.
+const manifestContext = "ic-file-manifest"
+
+// manifestHashes are the hashes a manifest may use, by crypto.Hash name.
+var manifestHashes = map[string]crypto.Hash{
+	crypto.SHA256.String():   crypto.SHA256,
+	crypto.SHA384.String():   crypto.SHA384,
+	crypto.SHA512.String():   crypto.SHA512,
+	crypto.SHA3_256.String(): crypto.SHA3_256,
+	crypto.SHA3_384.String(): crypto.SHA3_384,
+	crypto.SHA3_512.String(): crypto.SHA3_512,
+}
+
+// ErrDigestMismatch is the ManifestError cause when a file changed since the
+// manifest was signed.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// ManifestError tells which file of a manifest failed verification.
+type ManifestError struct {
+	Path string
+	Err  error
+}
+
+func (e *ManifestError) Error() string {
+	return "manifest: " + e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause, i.e. ErrDigestMismatch for errors.Is.
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Digest []byte `json:"digest"`
+}
+
+type manifest struct {
+	Hash   string          `json:"hash"`
+	Signer string          `json:"signer"`
+	Files  []manifestEntry `json:"files"`
+}
+
+type signedManifest struct {
+	Manifest  manifest `json:"manifest"`
+	Signature []byte   `json:"signature"`
+}
+
+// manifestPath checks p is a relative path staying below the base directory
+// and returns it in slash form.
+func manifestPath(p string) (string, error) {
+	slash := path.Clean(filepath.ToSlash(p))
+	if filepath.IsAbs(p) || path.IsAbs(slash) || slash == ".." || strings.HasPrefix(slash, "../") {
+		return "", errors.New("path outside of the base directory")
+	}
+	return slash, nil
+}
+
+func hashFile(name string, hash crypto.Hash) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := hash.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// SignManifest hashes each of paths with hash and returns the signed JSON
+// manifest of path -> digest. Paths must be relative, they are resolved
+// against the base directory given to VerifyManifest.
+func (i *IdentityKey) SignManifest(paths []string, hash crypto.Hash) ([]byte, error) {
+	if _, ok := manifestHashes[hash.String()]; !ok || !hash.Available() {
+		return nil, errors.New("unsupported manifest hash")
+	}
+
+	fp, err := i.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	m := manifest{Hash: hash.String(), Signer: fp}
+	for _, p := range paths {
+		slash, err := manifestPath(p)
+		if err != nil {
+			return nil, &ManifestError{Path: p, Err: err}
+		}
+		digest, err := hashFile(p, hash)
+		if err != nil {
+			return nil, &ManifestError{Path: p, Err: err}
+		}
+		m.Files = append(m.Files, manifestEntry{Path: slash, Digest: digest})
+	}
+
+	msg, err := CanonicalJSON(m)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := i.SignWithContext(manifestContext, rand.Reader, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(signedManifest{Manifest: m, Signature: sig})
+}
+
+// VerifyManifest checks the SignManifest signature was made by one of the
+// trusted keys, then re-hashes every file below baseDir. The first file
+// failing (missing, unreadable or changed) is reported as a *ManifestError.
+func VerifyManifest(data []byte, baseDir string, trusted []*IdentityPublicKey) error {
+	var signed signedManifest
+	err := json.Unmarshal(data, &signed)
+	if err != nil {
+		return err
+	}
+	m := signed.Manifest
+
+	msg, err := CanonicalJSON(m)
+	if err != nil {
+		return err
+	}
+
+	var signer *IdentityPublicKey
+	for _, pub := range trusted {
+		fp, err := pubFingerprint(pub.KeyBin)
+		if err == nil && FingerprintEqual(m.Signer, fp) {
+			signer = pub
+			break
+		}
+	}
+	if signer == nil {
+		return errors.New("manifest signed by an untrusted key")
+	}
+	err = signer.VerifyWithContext(manifestContext, msg, signed.Signature)
+	if err != nil {
+		return errors.New("invalid manifest signature")
+	}
+
+	hash, ok := manifestHashes[m.Hash]
+	if !ok || !hash.Available() {
+		return errors.New("unsupported manifest hash")
+	}
+
+	for _, entry := range m.Files {
+		slash, err := manifestPath(entry.Path)
+		if err != nil {
+			return &ManifestError{Path: entry.Path, Err: err}
+		}
+		digest, err := hashFile(filepath.Join(baseDir, filepath.FromSlash(slash)), hash)
+		if err != nil {
+			return &ManifestError{Path: entry.Path, Err: err}
+		}
+		if !bytes.Equal(digest, entry.Digest) {
+			return &ManifestError{Path: entry.Path, Err: ErrDigestMismatch}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package ickp
+
+import (
+	"crypto"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "a"), []byte("file a"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b"), []byte("file b"), 0600)
+
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	i, _ := NewIdentityKey(KEYEC25519)
+	keyBin, _ := i.pubKeyBin()
+	trusted := []*IdentityPublicKey{{KeyType: KEYEC25519, KeyBin: keyBin}}
+
+	m, err := i.SignManifest([]string{"a", "sub/b"}, crypto.SHA3_256)
+	if err != nil {
+		t.Fatalf("SignManifest() error: %v\n", err)
+	}
+
+	err = VerifyManifest(m, dir, trusted)
+	if err != nil {
+		t.Logf("VerifyManifest() error: %v\n", err)
+		t.Fail()
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b"), []byte("file B"), 0600)
+	err = VerifyManifest(m, dir, trusted)
+	merr, ok := err.(*ManifestError)
+	if !ok || merr.Path != "sub/b" || merr.Err != ErrDigestMismatch {
+		t.Logf("VerifyManifest() SHOULD fail on sub/b: %v\n", err)
+		t.Fail()
+	}
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Logf("VerifyManifest() error does not unwrap to ErrDigestMismatch\n")
+		t.Fail()
+	}
+
+	other, _ := NewIdentityKey(KEYEC25519)
+	otherBin, _ := other.pubKeyBin()
+	err = VerifyManifest(m, dir, []*IdentityPublicKey{{KeyType: KEYEC25519, KeyBin: otherBin}})
+	if err == nil {
+		t.Logf("VerifyManifest() SHOULD fail with an untrusted key\n")
+		t.Fail()
+	}
+
+	_, err = i.SignManifest([]string{"../a"}, crypto.SHA3_256)
+	if err == nil {
+		t.Logf("SignManifest() SHOULD refuse paths outside the base directory\n")
+		t.Fail()
+	}
+}
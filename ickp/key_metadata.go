@@ -0,0 +1,167 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrMetadataSignature is returned when the self-signature of a public key
+// line metadata does not verify: the metadata, or the owner, were altered.
+var ErrMetadataSignature = errors.New("public key metadata signature mismatch")
+
+const (
+	metadataContext = "ic-public-metadata"
+
+	// a small set of tags, not a certificate.
+	maxMetadataPairs    = 16
+	maxMetadataKeyLen   = 32
+	maxMetadataValueLen = 256
+)
+
+type metadataPair struct {
+	Key   string `asn1:"utf8"`
+	Value string `asn1:"utf8"`
+}
+
+// metadataPayload is the version 1 public key line payload, before it is
+// compressed.
+type metadataPayload struct {
+	KeyBin    []byte
+	Metadata  []metadataPair
+	Signature []byte
+}
+
+// metadataSigned is what the self-signature covers.
+type metadataSigned struct {
+	KeyType  int
+	Owner    string `asn1:"utf8"`
+	KeyBin   []byte
+	Metadata []metadataPair
+}
+
+// checkMetadataPairs makes sure the pairs are valid and in their canonical
+// order: sorted by key, no duplicates.
+func checkMetadataPairs(pairs []metadataPair) error {
+	if len(pairs) > maxMetadataPairs {
+		return fmt.Errorf("more than %d metadata pairs", maxMetadataPairs)
+	}
+
+	for n, p := range pairs {
+		if len(p.Key) == 0 || len(p.Key) > maxMetadataKeyLen {
+			return fmt.Errorf("invalid metadata key %q", p.Key)
+		}
+		for _, c := range p.Key {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				return fmt.Errorf("invalid metadata key %q", p.Key)
+			}
+		}
+		if len(p.Value) > maxMetadataValueLen || !utf8.ValidString(p.Value) {
+			return fmt.Errorf("invalid metadata value for %q", p.Key)
+		}
+		for _, c := range p.Value {
+			if unicode.IsControl(c) {
+				return fmt.Errorf("invalid metadata value for %q", p.Key)
+			}
+		}
+		if n > 0 && pairs[n-1].Key >= p.Key {
+			return errors.New("metadata keys not sorted or duplicated")
+		}
+	}
+	return nil
+}
+
+// PubToPKIXWithMetadata writes a version 1 public key line carrying the
+// key=value metadata (i.e. "expires", "contact"), signed by the key itself
+// along with the owner: parsing the line fails with ErrMetadataSignature
+// when either was altered. Keys are made of [a-z0-9._-], up to 32 bytes,
+// values are printable UTF-8, up to 256 bytes, and there are 16 pairs at
+// most. The key is used to sign, it must hold its private part.
+// The metadata is dropped when the public key is converted (ConvertPublic)
+// or written again from an IdentityPublicKey, it can't be signed there.
+func (i *IdentityKey) PubToPKIXWithMetadata(wr io.Writer, metadata map[string]string) error {
+	keyBin, err := i.pubKeyBin()
+	if err != nil {
+		return err
+	}
+	if i.keyOwner == nil {
+		return ErrUninitialized
+	}
+	owner := i.keyOwner.String()
+
+	pairs := make([]metadataPair, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, metadataPair{Key: k, Value: v})
+	}
+	sort.Slice(pairs, func(a, b int) bool { return pairs[a].Key < pairs[b].Key })
+	err = checkMetadataPairs(pairs)
+	if err != nil {
+		return err
+	}
+
+	signed, err := asn1.Marshal(metadataSigned{KeyType: i.keyType, Owner: owner, KeyBin: keyBin, Metadata: pairs})
+	if err != nil {
+		return err
+	}
+	sig, err := i.SignWithContext(metadataContext, rand.Reader, signed)
+	if err != nil {
+		return err
+	}
+
+	payload, err := asn1.Marshal(metadataPayload{KeyBin: keyBin, Metadata: pairs, Signature: sig})
+	if err != nil {
+		return err
+	}
+	return writePublicLinePayload(wr, i.keyType, keyBin, PubLineVersion1, payload, owner, false)
+}
+
+// decodeMetadataPayload decompresses and parses a version 1 payload, the
+// signature is not checked here.
+func decodeMetadataPayload(data []byte) (*metadataPayload, error) {
+	raw, err := decompressPub(data)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := new(metadataPayload)
+	rest, err := asn1.Unmarshal(raw, meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 || len(meta.KeyBin) == 0 {
+		return nil, errors.New("invalid public key metadata payload")
+	}
+
+	err = checkMetadataPairs(meta.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// verify checks the self-signature of the metadata and returns it.
+func (m *metadataPayload) verify(keyType int, owner string) (map[string]string, error) {
+	pub, err := parsePubKeyBin(keyType, m.KeyBin)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := asn1.Marshal(metadataSigned{KeyType: keyType, Owner: owner, KeyBin: m.KeyBin, Metadata: m.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	if verifyWithContext(keyType, pub, metadataContext, signed, m.Signature) != nil {
+		return nil, ErrMetadataSignature
+	}
+
+	metadata := make(map[string]string, len(m.Metadata))
+	for _, p := range m.Metadata {
+		metadata[p.Key] = p.Value
+	}
+	return metadata, nil
+}
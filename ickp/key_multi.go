@@ -0,0 +1,64 @@
+package ickp
+
+import (
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// PEMBlockError is the failure to load the Index-th (from 0) PEM block.
+type PEMBlockError struct {
+	Index int
+	Err   error
+}
+
+func (e PEMBlockError) Error() string {
+	return fmt.Sprintf("PEM block %d: %v", e.Index, e.Err)
+}
+
+// MultiPEMError lists the PEM blocks FromMultiPEM could not load.
+type MultiPEMError []PEMBlockError
+
+func (e MultiPEMError) Error() string {
+	msgs := make([]string, len(e))
+	for k, be := range e {
+		msgs[k] = be.Error()
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// FromMultiPEM loads all the encrypted private keys concatenated in data,
+// all with the same passphrase. The keys that could be loaded are returned
+// in order, along with a MultiPEMError listing the blocks that could not.
+// Text around the PEM blocks is skipped, as pem.Decode does.
+func FromMultiPEM(data []byte, passwd []byte) ([]*IdentityKey, error) {
+	var keys []*IdentityKey
+	var errs MultiPEMError
+
+	data = normalizeNewlines(data)
+	for index := 0; ; index++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		i := new(IdentityKey)
+		err := i.pemToPriv(block, passwd)
+		if err == nil {
+			err = i.Validate()
+		}
+		if err != nil {
+			i.wipe()
+			errs = append(errs, PEMBlockError{Index: index, Err: err})
+			continue
+		}
+
+		keys = append(keys, i)
+	}
+
+	if len(errs) > 0 {
+		return keys, errs
+	}
+	return keys, nil
+}
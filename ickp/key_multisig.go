@@ -0,0 +1,134 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// ErrQuorumNotMet is returned by VerifyQuorum when fewer than threshold
+// trusted keys signed.
+var ErrQuorumNotMet = errors.New("signature quorum not met")
+
+// multiSigContext is the SignWithContext label of multi-signatures.
+const multiSigContext = "ic-multi-signature"
+
+// MultiSignatureEntry is the signature of one signer, identified by its key
+// fingerprint.
+type MultiSignatureEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	Signature   []byte `json:"signature"`
+}
+
+// MultiSignature holds the signatures of several identity keys over the same
+// message, sorted by fingerprint.
+type MultiSignature struct {
+	Signatures []MultiSignatureEntry `json:"signatures"`
+}
+
+// Add signs msg with signer and adds the signature, replacing any previous
+// one from the same key.
+func (m *MultiSignature) Add(signer *IdentityKey, msg []byte) error {
+	fp, err := signer.Fingerprint()
+	if err != nil {
+		return err
+	}
+	sig, err := signer.SignWithContext(multiSigContext, rand.Reader, msg)
+	if err != nil {
+		return err
+	}
+
+	n := sort.Search(len(m.Signatures), func(i int) bool {
+		return m.Signatures[i].Fingerprint >= fp
+	})
+	if n < len(m.Signatures) && m.Signatures[n].Fingerprint == fp {
+		m.Signatures[n].Signature = sig
+		return nil
+	}
+
+	m.Signatures = append(m.Signatures, MultiSignatureEntry{})
+	copy(m.Signatures[n+1:], m.Signatures[n:])
+	m.Signatures[n] = MultiSignatureEntry{Fingerprint: fp, Signature: sig}
+	return nil
+}
+
+// Marshal returns the JSON form of the multi-signature.
+func (m *MultiSignature) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// ParseMultiSignature parses the output of Marshal.
+func ParseMultiSignature(data []byte) (*MultiSignature, error) {
+	m := new(MultiSignature)
+	err := json.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	if !sort.SliceIsSorted(m.Signatures, func(i, j int) bool {
+		return m.Signatures[i].Fingerprint < m.Signatures[j].Fingerprint
+	}) {
+		return nil, errors.New("multi-signature entries are not sorted")
+	}
+	return m, nil
+}
+
+// VerifyMulti checks every one of the required keys signed msg, signatures
+// from other keys are ignored.
+func (m *MultiSignature) VerifyMulti(msg []byte, required []*IdentityPublicKey) error {
+	for _, pub := range required {
+		fp, err := pubFingerprint(pub.KeyBin)
+		if err != nil {
+			return err
+		}
+
+		var sig []byte
+		for _, entry := range m.Signatures {
+			if FingerprintEqual(entry.Fingerprint, fp) {
+				sig = entry.Signature
+				break
+			}
+		}
+		if sig == nil {
+			return errors.New("missing signature from " + fp)
+		}
+
+		err = pub.VerifyWithContext(multiSigContext, msg, sig)
+		if err != nil {
+			return errors.New("invalid signature from " + fp)
+		}
+	}
+	return nil
+}
+
+// VerifyQuorum checks at least threshold of the trusted keys signed msg, sig
+// being a marshaled MultiSignature (m-of-n approval). It returns the trusted
+// keys with a valid signature, or ErrQuorumNotMet along with the ones found.
+func VerifyQuorum(msg, sig []byte, trusted []*IdentityPublicKey, threshold int) (matched []*IdentityPublicKey, err error) {
+	if threshold < 1 {
+		return nil, errors.New("invalid quorum threshold")
+	}
+
+	m, err := ParseMultiSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	// a key listed twice in trusted only counts once.
+	seen := make(map[string]bool)
+	for _, pub := range trusted {
+		fp, err := pubFingerprint(pub.KeyBin)
+		if err != nil || seen[fp] {
+			continue
+		}
+		if m.VerifyMulti(msg, []*IdentityPublicKey{pub}) == nil {
+			seen[fp] = true
+			matched = append(matched, pub)
+		}
+	}
+
+	if len(matched) < threshold {
+		return matched, ErrQuorumNotMet
+	}
+	return matched, nil
+}
@@ -0,0 +1,128 @@
+package ickp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// marshalPubKeyBin returns the ic key type and binary form of a standard
+// library public key.
+func marshalPubKeyBin(pub crypto.PublicKey) (keyType int, keyBin []byte, err error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		err = checkRSABits(k.N)
+		if err != nil {
+			return
+		}
+		keyType = KEYRSA
+		keyBin, err = x509.MarshalPKIXPublicKey(k)
+	case *ecdsa.PublicKey:
+		keyType = KEYECDSA
+		keyBin, err = x509.MarshalPKIXPublicKey(k)
+	case ed25519.PublicKey:
+		if len(k) != ed25519.PublicKeySize {
+			return 0, nil, errors.New("invalid EC25519 public key")
+		}
+		keyType = KEYEC25519
+		keyBin, err = asn1.Marshal([]byte(k))
+	default:
+		err = errors.New("unsupported public key type")
+	}
+	return
+}
+
+// public returns the standard library form of the identity public key, or
+// nil if the key is not set.
+func (i *IdentityKey) public() crypto.PublicKey {
+	switch {
+	case i.keyType == KEYRSA && i.rsa != nil:
+		return &i.rsa.PublicKey
+	case i.keyType == KEYECDSA && i.ecdsa != nil:
+		return &i.ecdsa.PublicKey
+	case i.keyType == KEYEC25519 && i.ec25519 != nil:
+		return i.ec25519.Pub
+	case i.custom != nil:
+		return i.custom.Public()
+	}
+	return nil
+}
+
+// IdentityPublicKeyFromCrypto builds an IdentityPublicKey from a standard
+// library public key (*rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey),
+// the key owner is unknown and left empty.
+func IdentityPublicKeyFromCrypto(pub crypto.PublicKey) (*IdentityPublicKey, error) {
+	keyType, keyBin, err := marshalPubKeyBin(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IdentityPublicKey{
+		KeyType: keyType,
+		KeyBin:  keyBin,
+	}, nil
+}
+
+// CryptoPublicKey returns the standard library form of the public key:
+// *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+func (p *IdentityPublicKey) CryptoPublicKey() (crypto.PublicKey, error) {
+	return parsePubKeyBin(p.KeyType, p.KeyBin)
+}
+
+// IdentityPublicKeyFromSPKI builds an IdentityPublicKey from a DER encoded
+// SubjectPublicKeyInfo (RSA, ECDSA or Ed25519), the key owner is unknown.
+func IdentityPublicKeyFromSPKI(der []byte) (*IdentityPublicKey, error) {
+	pub, err := parsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return IdentityPublicKeyFromCrypto(pub)
+}
+
+// SPKIBytes returns the DER encoded SubjectPublicKeyInfo of the public key,
+// the uncompressed standard counterpart of the public key line.
+func (p *IdentityPublicKey) SPKIBytes() ([]byte, error) {
+	pub, err := p.CryptoPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKIXPublicKey(pub)
+}
+
+// PubToStandardPEM writes the public key as the conventional "PUBLIC KEY"
+// PEM block (SubjectPublicKeyInfo) understood by OpenSSL and most libraries,
+// see IdentityPublicKeyFromPEM for the other way around.
+func (i *IdentityKey) PubToStandardPEM(wr io.Writer) error {
+	if !i.initialized() {
+		return ErrUninitialized
+	}
+	der, err := x509.MarshalPKIXPublicKey(i.public())
+	if err != nil {
+		return err
+	}
+	return pem.Encode(wr, &pem.Block{Type: pemPublicKey, Bytes: der})
+}
+
+// IdentityPublicKeyFromPEM parses the first "PUBLIC KEY" PEM block of data,
+// as written by PubToStandardPEM or openssl pkey -pubout, the key owner is
+// unknown.
+func IdentityPublicKeyFromPEM(data []byte) (*IdentityPublicKey, error) {
+	rest := normalizeNewlines(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("no PUBLIC KEY PEM block found")
+		}
+		if block.Type == pemPublicKey {
+			return IdentityPublicKeyFromSPKI(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package ickp
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/unix4fun/ic/icutl"
+)
+
+// randomart grid size and symbols, same as OpenSSH.
+const (
+	randomartWidth  = 17
+	randomartHeight = 9
+	randomartChars  = " .o+=*BOX@%&#/^SE"
+)
+
+// RandomArt renders the key fingerprint as an OpenSSH style "drunken bishop"
+// ASCII art, to compare keys visually out of band.
+func (i *IdentityKey) RandomArt() (string, error) {
+	keyBin, err := i.pubKeyBin()
+	if err != nil {
+		return "", err
+	}
+	return randomArt(i.keyType, keyBin)
+}
+
+// RandomArt is the same art as the one of the matching IdentityKey.
+func (p *IdentityPublicKey) RandomArt() (string, error) {
+	return randomArt(p.KeyType, p.KeyBin)
+}
+
+// randomArt draws the SHA3 of the public key.
+func randomArt(keyType int, keyBin []byte) (string, error) {
+	fp, err := icutl.HashSHA3Data(keyBin)
+	if err != nil {
+		return "", err
+	}
+	return drunkenBishop(fp, "["+strings.ToUpper(K2S[keyType])+"]", "[SHA3-256]"), nil
+}
+
+// drunkenBishop walks the bishop over the grid with fp, 2 bits per move from
+// the least significant ones of each byte, exactly as OpenSSH does.
+func drunkenBishop(fp []byte, title, footer string) string {
+	var field [randomartWidth][randomartHeight]int
+	x, y := randomartWidth/2, randomartHeight/2
+	last := len(randomartChars) - 1
+
+	for _, b := range fp {
+		for s := 0; s < 4; s++ {
+			if b&0x1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&0x2 != 0 {
+				y++
+			} else {
+				y--
+			}
+
+			x = clampInt(x, 0, randomartWidth-1)
+			y = clampInt(y, 0, randomartHeight-1)
+
+			// the last 2 symbols are reserved for start and end.
+			if field[x][y] < last-2 {
+				field[x][y]++
+			}
+			b >>= 2
+		}
+	}
+
+	field[randomartWidth/2][randomartHeight/2] = last - 1
+	field[x][y] = last
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(randomartBorder(title) + "\n")
+	for row := 0; row < randomartHeight; row++ {
+		buf.WriteByte('|')
+		for col := 0; col < randomartWidth; col++ {
+			buf.WriteByte(randomartChars[field[col][row]])
+		}
+		buf.WriteString("|\n")
+	}
+	buf.WriteString(randomartBorder(footer))
+
+	return buf.String()
+}
+
+// randomartBorder centers the title in a "+---...---+" line.
+func randomartBorder(title string) string {
+	if len(title) > randomartWidth {
+		title = title[:randomartWidth]
+	}
+	pad := randomartWidth - len(title)
+	return "+" + strings.Repeat("-", pad/2) + title + strings.Repeat("-", pad-pad/2) + "+"
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
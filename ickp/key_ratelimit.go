@@ -0,0 +1,26 @@
+package ickp
+
+import (
+	"errors"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned by the private key operations exceeding the
+// key rate limit.
+var ErrRateLimited = errors.New("identity key rate limited")
+
+// WithRateLimit bounds how fast the private key can be used for signing,
+// signatures over the limit fail with ErrRateLimited rather than wait. It
+// returns the key itself, to be used right after loading or generating it:
+//
+//	i, err := LoadIdentityKey(prefix, passwd)
+//	...
+//	i.WithRateLimit(rate.NewLimiter(100, 10))
+//
+// A nil limiter removes the limit, it must not be changed while the key is
+// in use.
+func (i *IdentityKey) WithRateLimit(limiter *rate.Limiter) *IdentityKey {
+	i.limiter = limiter
+	return i
+}
@@ -0,0 +1,55 @@
+package ickp
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+)
+
+// UNSAFE raw private key accessors.
+//
+// These return the underlying private key material for integrations the
+// package does not cover (threshold signing, custom protocols...). They
+// bypass the IdentityKey abstraction: the returned values are shared with the
+// IdentityKey (not copies), are not wiped by Destroy() once copied elsewhere,
+// and must be handled with the same care as the private key file content.
+// The bool is false when the key is not of the requested type or has been
+// destroyed (or has expired).
+
+// RSAPrivate returns the RSA private key.
+func (i *IdentityKey) RSAPrivate() (*rsa.PrivateKey, bool) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, false
+	}
+	defer unlock()
+	if i.keyType != KEYRSA || i.rsa == nil {
+		return nil, false
+	}
+	return i.rsa, true
+}
+
+// ECDSAPrivate returns the ECDSA private key.
+func (i *IdentityKey) ECDSAPrivate() (*ecdsa.PrivateKey, bool) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, false
+	}
+	defer unlock()
+	if i.keyType != KEYECDSA || i.ecdsa == nil {
+		return nil, false
+	}
+	return i.ecdsa, true
+}
+
+// Ed25519Seed returns the 32 bytes EC25519 private key seed.
+func (i *IdentityKey) Ed25519Seed() ([]byte, bool) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, false
+	}
+	defer unlock()
+	if i.keyType != KEYEC25519 || i.ec25519 == nil {
+		return nil, false
+	}
+	return i.ec25519.Priv.Seed(), true
+}
@@ -0,0 +1,75 @@
+package ickp
+
+import (
+	"errors"
+	"io"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUnsupportedRecipient is returned when keys of the type cannot be
+// encrypted to, only RSA and EC25519 keys can.
+var ErrUnsupportedRecipient = errors.New("unsupported recipient key type")
+
+// ageRecipient returns the age recipient of an RSA or EC25519 public key.
+func ageRecipient(p *IdentityPublicKey) (age.Recipient, error) {
+	cpub, err := p.CryptoPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	sshPub, err := ssh.NewPublicKey(cpub)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.KeyType {
+	case KEYRSA:
+		return agessh.NewRSARecipient(sshPub)
+	case KEYEC25519:
+		return agessh.NewEd25519Recipient(sshPub)
+	}
+	return nil, ErrUnsupportedRecipient
+}
+
+// ageIdentity returns the age identity of an RSA or EC25519 private key.
+func (i *IdentityKey) ageIdentity() (age.Identity, error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if !i.initialized() {
+		return nil, ErrUninitialized
+	}
+
+	switch i.keyType {
+	case KEYRSA:
+		return agessh.NewRSAIdentity(i.rsa)
+	case KEYEC25519:
+		return agessh.NewEd25519Identity(i.ec25519.Priv)
+	}
+	return nil, ErrUnsupportedRecipient
+}
+
+// PrivToRecipient writes the private key encrypted to the recipient public
+// key rather than a passphrase (see ToAgeEncrypted), for key handoff between
+// machines. The recipient must be an RSA or EC25519 key.
+func (i *IdentityKey) PrivToRecipient(wr io.Writer, recipient *IdentityPublicKey) error {
+	r, err := ageRecipient(recipient)
+	if err != nil {
+		return err
+	}
+	return i.ToAgeEncrypted(wr, r)
+}
+
+// FromRecipient loads a private key written by PrivToRecipient, decrypting
+// it with the recipient private key unwrapper.
+func FromRecipient(rd io.Reader, unwrapper *IdentityKey) (*IdentityKey, error) {
+	id, err := unwrapper.ageIdentity()
+	if err != nil {
+		return nil, err
+	}
+	return FromAgeEncrypted(rd, []age.Identity{id})
+}
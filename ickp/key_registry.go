@@ -0,0 +1,167 @@
+package ickp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/sha3"
+)
+
+// KeyTypeProvider implements a key type registered with RegisterKeyType.
+// The messages given to Sign and Verify are the context prefixed messages
+// of SignWithContext / VerifyWithContext, providers hash them as they see
+// fit.
+type KeyTypeProvider interface {
+	// Generate returns a new private key.
+	Generate(rand io.Reader) (crypto.Signer, error)
+	// MarshalPublic returns the binary form of the public key, the one
+	// compressed into the public key line and fingerprinted.
+	MarshalPublic(pub crypto.PublicKey) ([]byte, error)
+	// ParsePublic parses the MarshalPublic binary form.
+	ParsePublic(keyBin []byte) (crypto.PublicKey, error)
+	Sign(priv crypto.Signer, rand io.Reader, msg []byte) ([]byte, error)
+	Verify(pub crypto.PublicKey, msg, sig []byte) error
+}
+
+// firstCustomKeyType is the key type given to the first registered type,
+// built-in types are below.
+const firstCustomKeyType = 100
+
+var (
+	keyTypesLock     sync.RWMutex
+	keyTypeProviders = make(map[int]KeyTypeProvider)
+	nextKeyType      = firstCustomKeyType
+)
+
+func init() {
+	for keyType := range K2S {
+		keyTypeProviders[keyType] = builtinProvider(keyType)
+	}
+}
+
+// RegisterKeyType adds a key type using the "ic-*" header in public key
+// lines and returns its key type, to use with NewIdentityKey. The public
+// key parsers then accept it, but such keys cannot be written to private
+// key files: their owner is derived from the public key.
+// It must be called from an init function, S2K and K2S are not protected
+// against concurrent updates.
+func RegisterKeyType(header string, impl KeyTypeProvider) (int, error) {
+	if impl == nil || !strings.HasPrefix(header, "ic-") || strings.ContainsAny(header, " \t\r\n") {
+		return 0, errors.New("invalid key type registration")
+	}
+
+	keyTypesLock.Lock()
+	defer keyTypesLock.Unlock()
+
+	if _, ok := S2K[header]; ok {
+		return 0, errors.New("key type " + header + " already registered")
+	}
+
+	keyType := nextKeyType
+	nextKeyType++
+
+	S2K[header] = keyType
+	K2S[keyType] = header
+	keyTypeProviders[keyType] = impl
+	return keyType, nil
+}
+
+// resetKeyTypes drops the registered key types, for ResetDefaults.
+func resetKeyTypes() {
+	keyTypesLock.Lock()
+	defer keyTypesLock.Unlock()
+
+	for keyType := firstCustomKeyType; keyType < nextKeyType; keyType++ {
+		delete(S2K, K2S[keyType])
+		delete(K2S, keyType)
+		delete(keyTypeProviders, keyType)
+	}
+	nextKeyType = firstCustomKeyType
+}
+
+// customKeyType returns the provider of a registered (not built-in) key
+// type, or nil.
+func customKeyType(keyType int) KeyTypeProvider {
+	if keyType < firstCustomKeyType {
+		return nil
+	}
+
+	keyTypesLock.RLock()
+	defer keyTypesLock.RUnlock()
+	return keyTypeProviders[keyType]
+}
+
+// builtinProvider is the KeyTypeProvider of the built-in key types, the
+// IdentityKey methods do not go through it.
+type builtinProvider int
+
+func (b builtinProvider) Generate(rand io.Reader) (crypto.Signer, error) {
+	switch int(b) {
+	case KEYRSA:
+		return GenKeysRSA(rand)
+	case KEYECDSA:
+		return GenKeysECDSA(rand)
+	case KEYEC25519:
+		k, err := GenKeysED25519(rand)
+		if err != nil {
+			return nil, err
+		}
+		return k.Priv, nil
+	}
+	return nil, errors.New("invalid key type")
+}
+
+func (b builtinProvider) MarshalPublic(pub crypto.PublicKey) ([]byte, error) {
+	keyType, keyBin, err := marshalPubKeyBin(pub)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != int(b) {
+		return nil, errors.New("keytype confusion or invalid")
+	}
+	return keyBin, nil
+}
+
+func (b builtinProvider) ParsePublic(keyBin []byte) (crypto.PublicKey, error) {
+	return parsePubKeyBin(int(b), keyBin)
+}
+
+func (b builtinProvider) Sign(priv crypto.Signer, rand io.Reader, msg []byte) ([]byte, error) {
+	digest := sha3.Sum256(msg)
+
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return signPSS(rand, k, crypto.SHA3_256, digest[:])
+	case *ecdsa.PrivateKey:
+		return ecdsa.SignASN1(rand, k, digest[:])
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, msg), nil
+	}
+	return nil, errors.New("invalid key type")
+}
+
+func (b builtinProvider) Verify(pub crypto.PublicKey, msg, sig []byte) error {
+	digest := sha3.Sum256(msg)
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPSS(k, crypto.SHA3_256, digest[:], sig, nil)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, msg, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("invalid key type")
+}
@@ -0,0 +1,174 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto"
+	stded25519 "crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// dummyProvider is Ed25519 under another name.
+type dummyProvider struct{}
+
+func (dummyProvider) Generate(rand io.Reader) (crypto.Signer, error) {
+	_, priv, err := stded25519.GenerateKey(rand)
+	return priv, err
+}
+
+func (dummyProvider) MarshalPublic(pub crypto.PublicKey) ([]byte, error) {
+	return append([]byte("dummy"), pub.(stded25519.PublicKey)...), nil
+}
+
+func (dummyProvider) ParsePublic(keyBin []byte) (crypto.PublicKey, error) {
+	if len(keyBin) != 5+stded25519.PublicKeySize || !bytes.HasPrefix(keyBin, []byte("dummy")) {
+		return nil, errors.New("invalid dummy key")
+	}
+	return stded25519.PublicKey(keyBin[5:]), nil
+}
+
+func (dummyProvider) Sign(priv crypto.Signer, rand io.Reader, msg []byte) ([]byte, error) {
+	return stded25519.Sign(priv.(stded25519.PrivateKey), msg), nil
+}
+
+func (dummyProvider) Verify(pub crypto.PublicKey, msg, sig []byte) error {
+	if !stded25519.Verify(pub.(stded25519.PublicKey), msg, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func TestRegisterKeyType(t *testing.T) {
+	defer ResetDefaults()
+	dummyKeyType, err := RegisterKeyType("ic-dummy", dummyProvider{})
+	if err != nil {
+		t.Fatalf("RegisterKeyType() error: %v\n", err)
+	}
+
+	_, err = RegisterKeyType("ic-dummy", dummyProvider{})
+	if err == nil {
+		t.Logf("RegisterKeyType() SHOULD refuse an existing header\n")
+		t.Fail()
+	}
+	_, err = RegisterKeyType(KeyRSAStr, dummyProvider{})
+	if err == nil {
+		t.Logf("RegisterKeyType() SHOULD refuse a built-in header\n")
+		t.Fail()
+	}
+
+	i, err := NewIdentityKey(dummyKeyType)
+	if err != nil {
+		t.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+	if i.Type() != "ic-dummy" {
+		t.Logf("Type() = %q\n", i.Type())
+		t.Fail()
+	}
+
+	line := new(bytes.Buffer)
+	err = i.PubToPKIXWithFingerprint(line)
+	if err != nil {
+		t.Fatalf("PubToPKIXWithFingerprint() error: %v\n", err)
+	}
+	pub, err := parsePublicFile(line.Bytes())
+	if err != nil {
+		t.Fatalf("parsePublicFile() error: %v\n", err)
+	}
+	if pub.KeyType != dummyKeyType {
+		t.Logf("parsePublicFile() key type %d\n", pub.KeyType)
+		t.Fail()
+	}
+
+	sig, err := i.SignWithContext("registry", rand.Reader, []byte("message"))
+	if err != nil {
+		t.Fatalf("SignWithContext() error: %v\n", err)
+	}
+	err = pub.VerifyWithContext("registry", []byte("message"), sig)
+	if err != nil {
+		t.Logf("VerifyWithContext() error: %v\n", err)
+		t.Fail()
+	}
+	if pub.VerifyWithContext("other", []byte("message"), sig) == nil {
+		t.Logf("VerifyWithContext() SHOULD fail under another context\n")
+		t.Fail()
+	}
+}
+
+// SignMessage, VerifyDetailed and Describe must go through the provider of
+// a registered type, not treat it as the Go key type it happens to use.
+func TestRegisteredKeyTypeMessage(t *testing.T) {
+	defer ResetDefaults()
+	dummyKeyType, err := RegisterKeyType("ic-dummy", dummyProvider{})
+	if err != nil {
+		t.Fatalf("RegisterKeyType() error: %v\n", err)
+	}
+	i, err := NewIdentityKey(dummyKeyType)
+	if err != nil {
+		t.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+
+	sig, err := i.SignMessage([]byte("message"), crypto.SHA256)
+	if err != nil {
+		t.Fatalf("SignMessage() error: %v\n", err)
+	}
+	err = i.VerifyMessage([]byte("message"), sig, crypto.SHA256)
+	if err != nil {
+		t.Logf("VerifyMessage() error: %v\n", err)
+		t.Fail()
+	}
+	if i.VerifyMessage([]byte("other"), sig, crypto.SHA256) == nil {
+		t.Logf("VerifyMessage() SHOULD fail on another message\n")
+		t.Fail()
+	}
+
+	sig, err = i.SignWithContext("registry", rand.Reader, []byte("message"))
+	if err != nil {
+		t.Fatalf("SignWithContext() error: %v\n", err)
+	}
+	res, err := i.VerifyDetailed("registry", []byte("message"), sig)
+	if err != nil || !res.OK {
+		t.Logf("VerifyDetailed() error: %v\n", err)
+		t.Fail()
+	}
+	if res.Algorithm != "ic-dummy" {
+		t.Logf("VerifyDetailed() algorithm %q\n", res.Algorithm)
+		t.Fail()
+	}
+
+	info, err := i.Describe()
+	if err != nil {
+		t.Fatalf("Describe() error: %v\n", err)
+	}
+	if info.Type != "ic-dummy" || len(info.Curve) > 0 {
+		t.Logf("Describe() type %q curve %q\n", info.Type, info.Curve)
+		t.Fail()
+	}
+}
+
+// the built-in providers must agree with the IdentityKey methods.
+func TestBuiltinProviders(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		p := keyTypeProviders[keytype]
+		priv, err := p.Generate(rand.Reader)
+		if err != nil {
+			t.Fatalf("Generate() error: %v\n", err)
+		}
+		keyBin, err := p.MarshalPublic(priv.Public())
+		if err != nil {
+			t.Fatalf("MarshalPublic() error: %v\n", err)
+		}
+
+		sig, err := p.Sign(priv, rand.Reader, contextMessage("builtin", []byte("message")))
+		if err != nil {
+			t.Fatalf("Sign() error: %v\n", err)
+		}
+		pub := &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin}
+		err = pub.VerifyWithContext("builtin", []byte("message"), sig)
+		if err != nil {
+			t.Logf("%s provider signature does not verify: %v\n", K2S[keytype], err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RekeyError is the failure to re-encrypt the key file at Path.
+type RekeyError struct {
+	Path string
+	Err  error
+}
+
+func (e RekeyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// RekeyErrors lists the key files RekeyDir skipped.
+type RekeyErrors []RekeyError
+
+func (e RekeyErrors) Error() string {
+	msgs := make([]string, len(e))
+	for k, re := range e {
+		msgs[k] = re.Error()
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// RekeyDir re-encrypts with newPass every ic private key file found in dir
+// (not recursing) that decrypts with oldPass, and returns their paths. Each
+// file is written under a temporary name then renamed in place, the key is
+// never written unencrypted. Keys that could not be re-encrypted are left
+// untouched and listed in a RekeyErrors, AAD bound ones with ErrAADBound.
+func RekeyDir(dir string, oldPass, newPass []byte) (changed []string, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs RekeyErrors
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		isKey, _, err := IsICPrivateKey(path)
+		if err != nil || !isKey {
+			continue
+		}
+
+		err = rekeyFile(path, oldPass, newPass)
+		if err != nil {
+			errs = append(errs, RekeyError{Path: path, Err: err})
+			continue
+		}
+		changed = append(changed, path)
+	}
+
+	if len(errs) > 0 {
+		return changed, errs
+	}
+	return changed, nil
+}
+
+func rekeyFile(path string, oldPass, newPass []byte) error {
+	keyDer, block, err := decryptKeyFile(path, oldPass)
+	if err != nil {
+		return err
+	}
+	defer wipeBytes(keyDer)
+
+	newBlock, err := AEADEncryptPEMBlock(rand.Reader, block.Type, keyDer, newPass)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := createPrivFile(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = pem.Encode(f, newBlock)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package ickp
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// IsRevoked tells whether the key fingerprint is in revocationList, the whole
+// list is always compared in constant time.
+func (i *IdentityKey) IsRevoked(revocationList []string) bool {
+	fp, err := i.Fingerprint()
+	if err != nil {
+		return false
+	}
+	return fingerprintListed(fp, revocationList)
+}
+
+// IsRevoked tells whether the key fingerprint is in revocationList, the whole
+// list is always compared in constant time.
+func (p *IdentityPublicKey) IsRevoked(revocationList []string) bool {
+	fp, err := pubFingerprint(p.KeyBin)
+	if err != nil {
+		return false
+	}
+	return fingerprintListed(fp, revocationList)
+}
+
+func fingerprintListed(fp string, list []string) bool {
+	found := false
+	for _, revoked := range list {
+		if FingerprintEqual(fp, revoked) {
+			found = true
+		}
+	}
+	return found
+}
+
+// LoadRevocationList reads a revocation list file: one hex fingerprint per
+// line, blank lines and lines starting with '#' are skipped.
+func LoadRevocationList(path string) ([]string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list = append(list, line)
+	}
+	return list, nil
+}
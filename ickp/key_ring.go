@@ -0,0 +1,189 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+)
+
+// ErrDuplicateKey is returned when adding a key already in the Keyring.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// pemKeyring is the PEM block type of keyring files.
+const pemKeyring = "IC KEYRING"
+
+// KeyringEntry is a named identity key.
+type KeyringEntry struct {
+	Name string
+	Key  *IdentityKey
+	// Metadata is free form, it is saved along with the key.
+	Metadata map[string]string
+}
+
+// Keyring is a set of named identity keys, keys are unique by fingerprint
+// (two names cannot hold the same key). Entries may be appended directly
+// for bulk loading, followed by Dedup.
+type Keyring struct {
+	Entries []KeyringEntry
+}
+
+// Add adds the key under name, it fails with ErrDuplicateKey and does not
+// insert if a key with the same fingerprint is already there.
+func (r *Keyring) Add(name string, k *IdentityKey) error {
+	fp, err := k.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	if r.Lookup(fp) != nil {
+		return ErrDuplicateKey
+	}
+
+	r.Entries = append(r.Entries, KeyringEntry{Name: name, Key: k})
+	return nil
+}
+
+// Lookup returns the entry of the key with fingerprint fp, or nil.
+func (r *Keyring) Lookup(fp string) *KeyringEntry {
+	for k := range r.Entries {
+		if r.Entries[k].Key == nil {
+			continue
+		}
+		entryFP, err := r.Entries[k].Key.Fingerprint()
+		if err == nil && FingerprintEqual(fp, entryFP) {
+			return &r.Entries[k]
+		}
+	}
+	return nil
+}
+
+// Dedup removes the entries whose key fingerprint appeared in an earlier
+// entry (and those without a usable key), it returns how many were removed.
+func (r *Keyring) Dedup() int {
+	seen := make(map[string]bool, len(r.Entries))
+	kept := r.Entries[:0]
+
+	for _, entry := range r.Entries {
+		if entry.Key == nil {
+			continue
+		}
+		fp, err := entry.Key.Fingerprint()
+		if err != nil || seen[fp] {
+			continue
+		}
+		seen[fp] = true
+		kept = append(kept, entry)
+	}
+
+	removed := len(r.Entries) - len(kept)
+	for k := len(kept); k < len(r.Entries); k++ {
+		r.Entries[k] = KeyringEntry{}
+	}
+	r.Entries = kept
+	return removed
+}
+
+// keyringFileEntry is a KeyringEntry in the keyring file.
+type keyringFileEntry struct {
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Type is the private key PEM block type, Key its DER.
+	Type string `json:"type"`
+	Key  []byte `json:"key"`
+}
+
+// Save writes every entry, private keys, names and metadata, to path as a
+// single AEAD encrypted PEM block (see AEADEncryptPEMBlock) with passwd.
+func (r *Keyring) Save(path string, passwd []byte) error {
+	entries := make([]keyringFileEntry, 0, len(r.Entries))
+	defer func() {
+		for _, e := range entries {
+			wipeBytes(e.Key)
+		}
+	}()
+
+	for _, entry := range r.Entries {
+		keyHeader, keyDer, err := entry.Key.privKeyDer()
+		if err != nil {
+			return errors.New("keyring entry " + entry.Name + ": " + err.Error())
+		}
+		entries = append(entries, keyringFileEntry{
+			Name:     entry.Name,
+			Metadata: entry.Metadata,
+			Type:     keyHeader,
+			Key:      keyDer,
+		})
+	}
+
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	defer wipeBytes(plain)
+
+	block, err := AEADEncryptPEMBlock(rand.Reader, pemKeyring, plain, passwd)
+	if err != nil {
+		return err
+	}
+
+	f, err := createPrivFile(path)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(f, block)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadKeyring reads a keyring file written by Keyring.Save, a wrong
+// passphrase fails with ErrBadPassphrase.
+func LoadKeyring(path string, passwd []byte) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(normalizeNewlines(data))
+	if block == nil || block.Type != pemKeyring {
+		return nil, errors.New("no " + pemKeyring + " PEM block found")
+	}
+
+	plain, err := AEADDecryptPEMBlock(block, passwd)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(plain)
+
+	var entries []keyringFileEntry
+	err = json.Unmarshal(plain, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(Keyring)
+	for _, e := range entries {
+		i := new(IdentityKey)
+		err = i.derToPriv(e.Type, e.Key)
+		wipeBytes(e.Key)
+		if err == nil {
+			err = i.Validate()
+		}
+		if err == nil {
+			err = r.Add(e.Name, i)
+		}
+		if err != nil {
+			i.wipe()
+			for _, loaded := range r.Entries {
+				loaded.Key.wipe()
+			}
+			return nil, errors.New("keyring entry " + e.Name + ": " + err.Error())
+		}
+		r.Entries[len(r.Entries)-1].Metadata = e.Metadata
+	}
+	return r, nil
+}
@@ -0,0 +1,85 @@
+package ickp
+
+import (
+	"fmt"
+	"os"
+)
+
+// PromoteKeyFiles promotes prefix.next / prefix.next.pub to prefix /
+// prefix.pub, the previous key files are kept as prefix.old /
+// prefix.old.pub.
+// Each file is replaced with a rename so readers never see a partially
+// written file and prefix never goes missing, the private and public files
+// cannot be swapped together though: a reader may briefly see a mismatched
+// pair, which fails to load (FromKeyFiles checks the owner), and
+// WatchKeyFiles waits for the files to settle anyway. If the public key file
+// can't be promoted, the private one is put back as it was.
+func PromoteKeyFiles(prefix string) error {
+	next, old := prefix+".next", prefix+".old"
+
+	for _, f := range []string{next, next + ".pub"} {
+		_, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	// archive the current key files, as hard links so they stay in place.
+	archived := make(map[string]bool)
+	for _, suffix := range []string{"", ".pub"} {
+		err := os.Remove(old + suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		err = os.Link(prefix+suffix, old+suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		archived[suffix] = err == nil
+	}
+
+	err := renameFile(next, prefix)
+	if err != nil {
+		return err
+	}
+	err = renameFile(next+".pub", prefix+".pub")
+	if err != nil {
+		rerr := unpromotePrivate(prefix, archived[""])
+		if rerr != nil {
+			return fmt.Errorf("%v, and the private key file could not be put back: %v", err, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// renameFile is os.Rename, tests make it fail.
+var renameFile = os.Rename
+
+// unpromotePrivate undoes the private key file promotion when the public
+// one failed: the new private key goes back to prefix.next, and prefix gets
+// the archived one back (or goes away if there was none).
+func unpromotePrivate(prefix string, archived bool) error {
+	next, old := prefix+".next", prefix+".old"
+
+	err := os.Link(prefix, next)
+	if err != nil {
+		return err
+	}
+	if !archived {
+		return os.Remove(prefix)
+	}
+
+	// prefix must not go missing in between.
+	tmp := prefix + ".rollback"
+	os.Remove(tmp)
+	err = os.Link(old, tmp)
+	if err == nil {
+		err = renameFile(tmp, prefix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
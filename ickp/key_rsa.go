@@ -1,16 +1,215 @@
 package ickp
 
 import (
-	//"crypto/rand"
+	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"errors"
 	//"github.com/unix4fun/ac/acutl"
 	"io"
+	"math/big"
+	"math/bits"
+
+	"github.com/unix4fun/ic/icutl"
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
 )
 
 const (
 	KEYSIZE_RSA = 4096
+
+	// RSADefaultExponent is the public exponent of the generated RSA keys,
+	// unless KeyParams.RSAPublicExponent says otherwise.
+	RSADefaultExponent = 65537
 )
 
+// ErrKeyTooLarge is returned when loading an RSA key with a modulus of more
+// than MaxRSAKeyBits bits.
+var ErrKeyTooLarge = errors.New("RSA key too large")
+
+// ErrInvalidRSAExponent is returned for RSA public exponents that are even,
+// smaller than 3 or don't fit 31 bits.
+var ErrInvalidRSAExponent = errors.New("invalid RSA public exponent")
+
+// ErrHashTooLargeForKey is returned when signing with an RSA key too small
+// for the PSS encoding of the requested hash.
+var ErrHashTooLargeForKey = errors.New("hash too large for the RSA key size")
+
+// signPSS is rsa.SignPSS checking first that the PSS encoding of the hash
+// fits in the modulus (RFC 8017 section 9.1.1): the salt takes what room is
+// left (PSSSaltLengthAuto), it can be empty, so only the hash and two bytes
+// are needed, i.e. SHA-512 fits in 1024 bits but not in 512.
+func signPSS(rand io.Reader, k *rsa.PrivateKey, hash crypto.Hash, digest []byte) ([]byte, error) {
+	emLen := (k.N.BitLen() - 1 + 7) / 8
+	if hash.Size()+2 > emLen {
+		return nil, ErrHashTooLargeForKey
+	}
+	return rsa.SignPSS(rand, k, hash, digest, nil)
+}
+
 func GenKeysRSA(r io.Reader) (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(r, KEYSIZE_RSA)
 }
+
+// GenKeysRSAWithExponent is GenKeysRSA with the public exponent e, for
+// legacy peers that need i.e. e = 3. Small exponents are only safe with a
+// proper padding (PSS, OAEP), e = 3 is accepted but logged.
+func GenKeysRSAWithExponent(r io.Reader, e int) (*rsa.PrivateKey, error) {
+	if e == RSADefaultExponent {
+		return GenKeysRSA(r)
+	}
+	if e < 3 || e%2 == 0 || e > 1<<31-1 {
+		return nil, ErrInvalidRSAExponent
+	}
+	if e < RSADefaultExponent {
+		icutl.DebugLog.Printf("WARNING: RSA key with a small public exponent (e = %d)\n", e)
+	}
+
+	bigE := big.NewInt(int64(e))
+	one := big.NewInt(1)
+
+	// the primes have their top two bits set, p*q has all its bits.
+	prime := func() (*big.Int, error) {
+		for {
+			p, err := rand.Prime(r, KEYSIZE_RSA/2)
+			if err != nil {
+				return nil, err
+			}
+			if new(big.Int).GCD(nil, nil, bigE, new(big.Int).Sub(p, one)).Cmp(one) == 0 {
+				return p, nil
+			}
+		}
+	}
+
+	for {
+		p, err := prime()
+		if err != nil {
+			return nil, err
+		}
+		q, err := prime()
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) != 0 {
+			return rsaKeyFromPrimes(p, q, e)
+		}
+	}
+}
+
+// rsaKeyFromPrimes builds and validates the two primes RSA key p*q with the
+// public exponent e, gcd(e, p-1) and gcd(e, q-1) must be 1.
+func rsaKeyFromPrimes(p, q *big.Int, e int) (*rsa.PrivateKey, error) {
+	one := big.NewInt(1)
+	pMinusOne := new(big.Int).Sub(p, one)
+	qMinusOne := new(big.Int).Sub(q, one)
+	phi := new(big.Int).Mul(pMinusOne, qMinusOne)
+
+	k := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: e},
+		D:         new(big.Int).ModInverse(big.NewInt(int64(e)), phi),
+		Primes:    []*big.Int{p, q},
+	}
+	k.Precompute()
+	return k, k.Validate()
+}
+
+// CompactRSA drops (and wipes) the precomputed CRT values of a loaded RSA
+// key, that is ~7KB down to ~2KB held per 4096 bits key.
+// Verification is unaffected, but every private key operation (signing)
+// then runs without CRT: ~1.7x slower (see BenchmarkLoadRSAKeys* and
+// BenchmarkSignRSACompact). Worth it for servers keeping many keys around
+// and rarely signing with them. It is a no-op for other key types.
+func (i *IdentityKey) CompactRSA() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.keyType != KEYRSA || i.rsa == nil {
+		return
+	}
+
+	wipeBigInt(i.rsa.Precomputed.Dp)
+	wipeBigInt(i.rsa.Precomputed.Dq)
+	wipeBigInt(i.rsa.Precomputed.Qinv)
+	for _, crt := range i.rsa.Precomputed.CRTValues {
+		wipeBigInt(crt.Exp)
+		wipeBigInt(crt.Coeff)
+		wipeBigInt(crt.R)
+	}
+	i.rsa.Precomputed = rsa.PrecomputedValues{}
+}
+
+// checkRSABits fails with ErrKeyTooLarge when the modulus is over
+// MaxRSAKeyBits bits.
+func checkRSABits(n *big.Int) error {
+	if n != nil && n.BitLen() > MaxRSAKeyBits {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// readModulus reads the modulus INTEGER from s and checks its size, the
+// integer itself is never decoded. Whatever does not parse is left to the
+// x509 parsers.
+func readModulus(s *cryptobyte.String) error {
+	var n cryptobyte.String
+	if !s.ReadASN1(&n, cbasn1.INTEGER) {
+		return nil
+	}
+	for len(n) > 0 && n[0] == 0 {
+		n = n[1:]
+	}
+	if len(n) > 0 && (len(n)-1)*8+bits.Len8(n[0]) > MaxRSAKeyBits {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// checkPKCS1Size checks the modulus size of a PKCS #1 RSA private key:
+// SEQUENCE { version INTEGER, modulus INTEGER, ... }
+func checkPKCS1Size(der []byte) error {
+	var seq cryptobyte.String
+	input := cryptobyte.String(der)
+	if !input.ReadASN1(&seq, cbasn1.SEQUENCE) || !seq.SkipASN1(cbasn1.INTEGER) {
+		return nil
+	}
+	return readModulus(&seq)
+}
+
+// checkPKCS8Size checks the modulus size of a PKCS #8 RSA private key:
+// SEQUENCE { version INTEGER, algorithm SEQUENCE, key OCTET STRING (PKCS #1) }
+func checkPKCS8Size(der []byte) error {
+	var seq, key cryptobyte.String
+	input := cryptobyte.String(der)
+	if !input.ReadASN1(&seq, cbasn1.SEQUENCE) || !seq.SkipASN1(cbasn1.INTEGER) ||
+		!seq.SkipASN1(cbasn1.SEQUENCE) || !seq.ReadASN1(&key, cbasn1.OCTET_STRING) {
+		return nil
+	}
+	return checkPKCS1Size(key)
+}
+
+// checkPKIXSize checks the modulus size of an RSA SubjectPublicKeyInfo:
+// SEQUENCE { algorithm SEQUENCE, key BIT STRING (SEQUENCE { modulus, ... }) }
+func checkPKIXSize(der []byte) error {
+	var seq, rsaSeq cryptobyte.String
+	var key []byte
+	input := cryptobyte.String(der)
+	if !input.ReadASN1(&seq, cbasn1.SEQUENCE) || !seq.SkipASN1(cbasn1.SEQUENCE) ||
+		!seq.ReadASN1BitStringAsBytes(&key) {
+		return nil
+	}
+	keyStr := cryptobyte.String(key)
+	if !keyStr.ReadASN1(&rsaSeq, cbasn1.SEQUENCE) {
+		return nil
+	}
+	return readModulus(&rsaSeq)
+}
+
+// parsePKIXPublicKey is x509.ParsePKIXPublicKey refusing RSA keys of more
+// than MaxRSAKeyBits bits before parsing them.
+func parsePKIXPublicKey(der []byte) (interface{}, error) {
+	err := checkPKIXSize(der)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
@@ -0,0 +1,50 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+)
+
+// ShredKeyFiles overwrites prefix and prefix.pub with random data, syncs
+// them to disk and removes them: the "destroy this identity" operation.
+// Both files are attempted, the first error is returned.
+// It is best effort: it only helps on filesystems writing in place (i.e.
+// ext4, xfs without reflinks). Copy-on-write filesystems (btrfs, zfs, apfs),
+// snapshots, backups and journaling of data write the random data elsewhere
+// and keep the old blocks around, and SSDs remap written blocks anyway
+// (wear leveling): full disk encryption is the only real protection there.
+// Other hard links to the files are overwritten too, but not removed.
+func ShredKeyFiles(prefix string) error {
+	var firstErr error
+	for _, name := range []string{prefix, prefix + ".pub"} {
+		err := shredFile(name)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// shredFile overwrites the whole file in place, then removes it.
+func shredFile(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	st, err := f.Stat()
+	if err == nil {
+		_, err = io.CopyN(f, rand.Reader, st.Size())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
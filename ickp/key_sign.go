@@ -0,0 +1,264 @@
+package ickp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/sha3"
+)
+
+// ecdsaSignature is the ASN.1 form of an ECDSA signature.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// appendContextLabel appends the length tagged context label to out:
+// uint32_be(len(ctx)) || ctx
+// the length tag prevents ambiguities between labels and messages.
+func appendContextLabel(out []byte, ctx string) []byte {
+	var tag [4]byte
+	binary.BigEndian.PutUint32(tag[:], uint32(len(ctx)))
+	out = append(out, tag[:]...)
+	return append(out, ctx...)
+}
+
+// contextMessage returns the message prefixed by the length tagged context
+// label: uint32_be(len(ctx)) || ctx || msg
+func contextMessage(ctx string, msg []byte) []byte {
+	out := make([]byte, 0, 4+len(ctx)+len(msg))
+	out = appendContextLabel(out, ctx)
+	return append(out, msg...)
+}
+
+// contextHasher computes context digests, it is pooled so that repeated
+// signatures reuse the SHA3-256 state and buffers.
+type contextHasher struct {
+	h       hash.Hash
+	scratch []byte
+	digest  []byte
+}
+
+var contextHasherPool = sync.Pool{
+	New: func() interface{} {
+		h := sha3.New256()
+		return &contextHasher{
+			h:      h,
+			digest: make([]byte, 0, h.Size()),
+		}
+	},
+}
+
+// sum returns the SHA3-256 of the context prefixed message, the message is
+// streamed into the hash rather than copied behind the label. The returned
+// slice is only valid until the contextHasher goes back to the pool.
+func (c *contextHasher) sum(ctx string, msg []byte) []byte {
+	c.scratch = appendContextLabel(c.scratch[:0], ctx)
+
+	c.h.Reset()
+	c.h.Write(c.scratch)
+	c.h.Write(msg)
+	c.digest = c.h.Sum(c.digest[:0])
+	return c.digest
+}
+
+// message returns the context prefixed message, built in the contextHasher
+// scratch buffer. The returned slice is only valid until the contextHasher
+// goes back to the pool.
+func (c *contextHasher) message(ctx string, msg []byte) []byte {
+	c.scratch = appendContextLabel(c.scratch[:0], ctx)
+	c.scratch = append(c.scratch, msg...)
+	return c.scratch
+}
+
+// contextDigest returns a freshly allocated SHA3-256 of the context prefixed
+// message.
+func contextDigest(ctx string, msg []byte) []byte {
+	c := contextHasherPool.Get().(*contextHasher)
+	digest := append([]byte(nil), c.sum(ctx, msg)...)
+	contextHasherPool.Put(c)
+	return digest
+}
+
+// SignWithContext signs msg under the ctx domain separation label, a
+// signature made under one label does not verify under any other.
+// RSA (PSS) and ECDSA sign the SHA3-256 digest of the context prefixed
+// message, EC25519 signs the context prefixed message itself.
+// It can be called from many goroutines at once on the same key, rand must
+// then be safe for concurrent use too (crypto/rand is).
+func (i *IdentityKey) SignWithContext(ctx string, rand io.Reader, msg []byte) ([]byte, error) {
+	hook := getKeyHook()
+	if hook == nil {
+		return i.signWithContext(ctx, rand, msg)
+	}
+
+	start := time.Now()
+	sig, err := i.signWithContext(ctx, rand, msg)
+	fp, alg := i.hookArgs()
+	hook.OnSign(fp, alg, time.Since(start))
+	return sig, err
+}
+
+func (i *IdentityKey) signWithContext(ctx string, rand io.Reader, msg []byte) ([]byte, error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if !i.initialized() {
+		return nil, ErrUninitialized
+	}
+	err = i.fipsCheckKey()
+	if err == nil {
+		err = fipsCheckRand(rand)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i.limiter != nil && !i.limiter.Allow() {
+		return nil, ErrRateLimited
+	}
+
+	switch i.keyType {
+	case KEYRSA:
+		c := contextHasherPool.Get().(*contextHasher)
+		defer contextHasherPool.Put(c)
+		return signPSS(rand, i.rsa, crypto.SHA3_256, c.sum(ctx, msg))
+	case KEYECDSA:
+		c := contextHasherPool.Get().(*contextHasher)
+		defer contextHasherPool.Put(c)
+		r, s, err := ecdsa.Sign(rand, i.ecdsa, c.sum(ctx, msg))
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(ecdsaSignature{r, s})
+	case KEYEC25519:
+		c := contextHasherPool.Get().(*contextHasher)
+		defer contextHasherPool.Put(c)
+		return ed25519.Sign(i.ec25519.Priv, c.message(ctx, msg)), nil
+	}
+	return customKeyType(i.keyType).Sign(i.custom, rand, contextMessage(ctx, msg))
+}
+
+// VerifyWithContext checks sig is a signature of msg made by SignWithContext
+// under the same ctx label, only the public part of the key is used.
+func (i *IdentityKey) VerifyWithContext(ctx string, msg, sig []byte) error {
+	hook := getKeyHook()
+	if hook == nil {
+		return verifyWithContext(i.keyType, i.public(), ctx, msg, sig)
+	}
+
+	start := time.Now()
+	err := verifyWithContext(i.keyType, i.public(), ctx, msg, sig)
+	fp, alg := i.hookArgs()
+	hook.OnVerify(fp, alg, time.Since(start))
+	return err
+}
+
+// VerifyWithContext checks sig is a signature of msg made by SignWithContext
+// under the same ctx label by the private key matching this public key.
+func (p *IdentityPublicKey) VerifyWithContext(ctx string, msg, sig []byte) error {
+	var start time.Time
+
+	hook := getKeyHook()
+	if hook != nil {
+		start = time.Now()
+	}
+
+	pub, err := p.CryptoPublicKey()
+	if err == nil {
+		err = verifyWithContext(p.KeyType, pub, ctx, msg, sig)
+	}
+
+	if hook != nil {
+		fp, _ := pubFingerprint(p.KeyBin)
+		hook.OnVerify(fp, K2S[p.KeyType], time.Since(start))
+	}
+	return err
+}
+
+// VerifyResult describes what a signature was checked against.
+type VerifyResult struct {
+	// Algorithm is the signature scheme, e.g. "RSA-PSS".
+	Algorithm string
+	// Hash is the hash of the context prefixed message the scheme used.
+	Hash string
+	OK   bool
+}
+
+// VerifyDetailed is VerifyWithContext also reporting the signature scheme
+// and hash the signature was checked with, i.e. for audit logs.
+func (i *IdentityKey) VerifyDetailed(ctx string, msg, sig []byte) (VerifyResult, error) {
+	err := i.VerifyWithContext(ctx, msg, sig)
+	return verifyResult(i.keyType, i.public(), err)
+}
+
+// VerifyDetailed is VerifyWithContext also reporting the signature scheme
+// and hash the signature was checked with, i.e. for audit logs.
+func (p *IdentityPublicKey) VerifyDetailed(ctx string, msg, sig []byte) (VerifyResult, error) {
+	err := p.VerifyWithContext(ctx, msg, sig)
+	pub, _ := p.CryptoPublicKey()
+	return verifyResult(p.KeyType, pub, err)
+}
+
+func verifyResult(keyType int, pub crypto.PublicKey, err error) (VerifyResult, error) {
+	var res VerifyResult
+
+	// a registered type may reuse a Go key type, its header is what to
+	// report.
+	if customKeyType(keyType) != nil {
+		res.Algorithm = K2S[keyType]
+		res.OK = err == nil
+		return res, err
+	}
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		res.Algorithm, res.Hash = "RSA-PSS", "SHA3-256"
+	case *ecdsa.PublicKey:
+		res.Algorithm, res.Hash = "ECDSA-"+k.Curve.Params().Name, "SHA3-256"
+	case ed25519.PublicKey:
+		res.Algorithm, res.Hash = "Ed25519", "SHA-512"
+	}
+
+	res.OK = err == nil
+	return res, err
+}
+
+// verifyWithContext is the verification of all key types, it only ever
+// needs the public key.
+func verifyWithContext(keyType int, pub crypto.PublicKey, ctx string, msg, sig []byte) error {
+	if p := customKeyType(keyType); p != nil {
+		return p.Verify(pub, contextMessage(ctx, msg), sig)
+	}
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPSS(k, crypto.SHA3_256, contextDigest(ctx, msg), sig, nil)
+	case *ecdsa.PublicKey:
+		var esig ecdsaSignature
+		rest, err := asn1.Unmarshal(sig, &esig)
+		if err != nil || len(rest) > 0 || esig.R == nil || esig.S == nil {
+			return errors.New("invalid signature")
+		}
+		if !ecdsa.Verify(k, contextDigest(ctx, msg), esig.R, esig.S) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, contextMessage(ctx, msg), sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("invalid key type")
+}
@@ -0,0 +1,119 @@
+package ickp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// SignMessage signs msg with the standard schemes, without context label:
+// RSA-PSS and ECDSA (ASN.1) over the hash of msg, EC25519 over msg itself
+// (hash is then ignored). The message is hashed here, callers never deal
+// with digests, see VerifyMessage. Registered key types (RegisterKeyType)
+// sign msg itself through their provider, hash is ignored as well. In FIPS
+// mode hash has to be a SHA-2 or SHA-3 one.
+func (i *IdentityKey) SignMessage(msg []byte, hash crypto.Hash) ([]byte, error) {
+	hook := getKeyHook()
+	if hook == nil {
+		return i.signMessage(msg, hash)
+	}
+
+	start := time.Now()
+	sig, err := i.signMessage(msg, hash)
+	fp, alg := i.hookArgs()
+	hook.OnSign(fp, alg, time.Since(start))
+	return sig, err
+}
+
+func (i *IdentityKey) signMessage(msg []byte, hash crypto.Hash) ([]byte, error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if !i.initialized() {
+		return nil, ErrUninitialized
+	}
+	err = i.fipsCheckKey()
+	if err == nil {
+		err = fipsCheckHash(hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i.limiter != nil && !i.limiter.Allow() {
+		return nil, ErrRateLimited
+	}
+
+	if p := customKeyType(i.keyType); p != nil {
+		return p.Sign(i.custom, rand.Reader, msg)
+	}
+	if i.keyType == KEYEC25519 {
+		return ed25519.Sign(i.ec25519.Priv, msg), nil
+	}
+
+	digest, err := messageDigest(msg, hash)
+	if err != nil {
+		return nil, err
+	}
+	if i.keyType == KEYRSA {
+		return signPSS(rand.Reader, i.rsa, hash, digest)
+	}
+	return ecdsa.SignASN1(rand.Reader, i.ecdsa, digest)
+}
+
+// VerifyMessage checks sig is a SignMessage signature of msg with hash.
+func (i *IdentityKey) VerifyMessage(msg, sig []byte, hash crypto.Hash) error {
+	return verifyMessage(i.keyType, i.public(), msg, sig, hash)
+}
+
+// VerifyMessage checks sig is a SignMessage signature of msg with hash.
+func (p *IdentityPublicKey) VerifyMessage(msg, sig []byte, hash crypto.Hash) error {
+	pub, err := p.CryptoPublicKey()
+	if err != nil {
+		return err
+	}
+	return verifyMessage(p.KeyType, pub, msg, sig, hash)
+}
+
+func messageDigest(msg []byte, hash crypto.Hash) ([]byte, error) {
+	if !hash.Available() {
+		return nil, errors.New("unavailable hash function")
+	}
+	h := hash.New()
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+func verifyMessage(keyType int, pub crypto.PublicKey, msg, sig []byte, hash crypto.Hash) error {
+	if p := customKeyType(keyType); p != nil {
+		return p.Verify(pub, msg, sig)
+	}
+	if k, ok := pub.(ed25519.PublicKey); ok {
+		if !ed25519.Verify(k, msg, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	digest, err := messageDigest(msg, hash)
+	if err != nil {
+		return err
+	}
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPSS(k, hash, digest, sig, nil)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("invalid key type")
+}
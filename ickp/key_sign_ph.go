@@ -0,0 +1,77 @@
+package ickp
+
+import (
+	"crypto"
+	stded25519 "crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"time"
+)
+
+// SignPrehashed makes an Ed25519ph (RFC 8032 prehashed) signature of digest,
+// the SHA-512 of the message, with ctx as the RFC 8032 context (255 bytes
+// max). It is for callers that already hashed a large message, EC25519
+// keys only.
+// Ed25519ph and the default pure Ed25519 (SignWithContext) are different
+// schemes: a signature made with one never verifies with the other, so the
+// verifier has to know which one is used, use VerifyPrehashed for these.
+func (i *IdentityKey) SignPrehashed(ctx string, digest []byte) ([]byte, error) {
+	hook := getKeyHook()
+	if hook == nil {
+		return i.signPrehashed(ctx, digest)
+	}
+
+	start := time.Now()
+	sig, err := i.signPrehashed(ctx, digest)
+	fp, alg := i.hookArgs()
+	hook.OnSign(fp, alg, time.Since(start))
+	return sig, err
+}
+
+func (i *IdentityKey) signPrehashed(ctx string, digest []byte) ([]byte, error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if i.keyType != KEYEC25519 || i.ec25519 == nil {
+		return nil, errors.New("Ed25519ph needs an EC25519 key")
+	}
+	err = i.fipsCheckKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(digest) != sha512.Size {
+		return nil, errors.New("Ed25519ph digest must be a SHA-512")
+	}
+	if i.limiter != nil && !i.limiter.Allow() {
+		return nil, ErrRateLimited
+	}
+
+	opts := &stded25519.Options{Hash: crypto.SHA512, Context: ctx}
+	return i.ec25519.Priv.Sign(rand.Reader, digest, opts)
+}
+
+// VerifyPrehashed checks sig is a SignPrehashed signature of digest.
+func (i *IdentityKey) VerifyPrehashed(ctx string, digest, sig []byte) error {
+	return verifyPrehashed(i.public(), ctx, digest, sig)
+}
+
+// VerifyPrehashed checks sig is a SignPrehashed signature of digest.
+func (p *IdentityPublicKey) VerifyPrehashed(ctx string, digest, sig []byte) error {
+	pub, err := p.CryptoPublicKey()
+	if err != nil {
+		return err
+	}
+	return verifyPrehashed(pub, ctx, digest, sig)
+}
+
+func verifyPrehashed(pub crypto.PublicKey, ctx string, digest, sig []byte) error {
+	k, ok := pub.(stded25519.PublicKey)
+	if !ok {
+		return errors.New("Ed25519ph needs an EC25519 key")
+	}
+	opts := &stded25519.Options{Hash: crypto.SHA512, Context: ctx}
+	return stded25519.VerifyWithOptions(k, digest, sig, opts)
+}
@@ -0,0 +1,955 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/time/rate"
+)
+
+var benchMsg = make([]byte, 4096)
+
+// the verify path must work with the public key only.
+func TestVerifyWithContextPublicOnly(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, err := NewIdentityKey(keytype)
+		if err != nil {
+			t.Fatalf("NewIdentityKey() error: %v\n", err)
+		}
+
+		sig, err := i.SignWithContext("test", rand.Reader, []byte("message"))
+		if err != nil {
+			t.Fatalf("SignWithContext() error: %v\n", err)
+		}
+
+		keyBin, err := i.PubToDER()
+		if err != nil {
+			t.Fatalf("PubToDER() error: %v\n", err)
+		}
+
+		// nothing but the public key.
+		pub := &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin}
+		i.Destroy()
+		i = nil
+
+		err = pub.VerifyWithContext("test", []byte("message"), sig)
+		if err != nil {
+			t.Logf("%s VerifyWithContext() error: %v\n", K2S[keytype], err)
+			t.Fail()
+		}
+
+		err = pub.VerifyWithContext("other", []byte("message"), sig)
+		if err == nil {
+			t.Logf("%s VerifyWithContext() SHOULD error with another context\n", K2S[keytype])
+			t.Fail()
+		}
+	}
+}
+
+func benchmarkNewIdentityKey(b *testing.B, keytype int) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		_, err := NewIdentityKey(keytype)
+		if err != nil {
+			b.Fatalf("NewIdentityKey() error: %v\n", err)
+		}
+	}
+}
+
+func benchmarkSign(b *testing.B, keytype int) {
+	i, err := NewIdentityKey(keytype)
+	if err != nil {
+		b.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, err = i.SignWithContext("bench", rand.Reader, benchMsg)
+		if err != nil {
+			b.Fatalf("SignWithContext() error: %v\n", err)
+		}
+	}
+}
+
+func benchmarkVerify(b *testing.B, keytype int) {
+	i, err := NewIdentityKey(keytype)
+	if err != nil {
+		b.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+
+	sig, err := i.SignWithContext("bench", rand.Reader, benchMsg)
+	if err != nil {
+		b.Fatalf("SignWithContext() error: %v\n", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		err = i.VerifyWithContext("bench", benchMsg, sig)
+		if err != nil {
+			b.Fatalf("VerifyWithContext() error: %v\n", err)
+		}
+	}
+}
+
+func BenchmarkNewIdentityKeyRSA(b *testing.B)     { benchmarkNewIdentityKey(b, KEYRSA) }
+func BenchmarkNewIdentityKeyECDSA(b *testing.B)   { benchmarkNewIdentityKey(b, KEYECDSA) }
+func BenchmarkNewIdentityKeyEC25519(b *testing.B) { benchmarkNewIdentityKey(b, KEYEC25519) }
+
+func BenchmarkSignRSA(b *testing.B)     { benchmarkSign(b, KEYRSA) }
+func BenchmarkSignECDSA(b *testing.B)   { benchmarkSign(b, KEYECDSA) }
+func BenchmarkSignEC25519(b *testing.B) { benchmarkSign(b, KEYEC25519) }
+
+func BenchmarkVerifyRSA(b *testing.B)     { benchmarkVerify(b, KEYRSA) }
+func BenchmarkVerifyECDSA(b *testing.B)   { benchmarkVerify(b, KEYECDSA) }
+func BenchmarkVerifyEC25519(b *testing.B) { benchmarkVerify(b, KEYEC25519) }
+
+func BenchmarkSignRSACompact(b *testing.B) {
+	i, err := NewIdentityKey(KEYRSA)
+	if err != nil {
+		b.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+	i.CompactRSA()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, err = i.SignWithContext("bench", rand.Reader, benchMsg)
+		if err != nil {
+			b.Fatalf("SignWithContext() error: %v\n", err)
+		}
+	}
+}
+
+// benchmarkLoadRSAKeys loads many RSA keys and reports the memory held per
+// key, with and without CompactRSA.
+func benchmarkLoadRSAKeys(b *testing.B, compact bool) {
+	const count = 100
+
+	i, err := NewIdentityKey(KEYRSA)
+	if err != nil {
+		b.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+	header, der, err := i.privKeyDer()
+	if err != nil {
+		b.Fatalf("privKeyDer() error: %v\n", err)
+	}
+
+	var held uint64
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		keys := make([]*IdentityKey, count)
+		for k := range keys {
+			keys[k] = new(IdentityKey)
+			err = keys[k].derToPriv(header, der)
+			if err != nil {
+				b.Fatalf("derToPriv() error: %v\n", err)
+			}
+			if compact {
+				keys[k].CompactRSA()
+			}
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		held += (after.HeapAlloc - before.HeapAlloc) / count
+		runtime.KeepAlive(keys)
+	}
+	b.ReportMetric(float64(held)/float64(b.N), "heap-B/key")
+}
+
+func BenchmarkLoadRSAKeys(b *testing.B)        { benchmarkLoadRSAKeys(b, false) }
+func BenchmarkLoadRSAKeysCompact(b *testing.B) { benchmarkLoadRSAKeys(b, true) }
+
+// a 2-of-2 additive signature must be a standard Ed25519 one.
+func TestThresholdSign(t *testing.T) {
+	i, err := NewIdentityKey(KEYEC25519)
+	if err != nil {
+		t.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+
+	shares, err := i.SplitSigningKey(2, 2)
+	if err != nil {
+		t.Fatalf("SplitSigningKey() error: %v\n", err)
+	}
+
+	// the second holder gets its share from elsewhere.
+	shareBin, err := shares[1].MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error: %v\n", err)
+	}
+	shares[1] = SignShare{}
+	err = shares[1].UnmarshalBinary(shareBin)
+	if err != nil {
+		t.Fatalf("UnmarshalBinary() error: %v\n", err)
+	}
+	if shares[1].Index != 2 || shares[1].N != 2 {
+		t.Fatalf("UnmarshalBinary() share %d of %d\n", shares[1].Index, shares[1].N)
+	}
+	var bad SignShare
+	if bad.UnmarshalBinary(shareBin[:len(shareBin)-1]) == nil || bad.UnmarshalBinary(append(shareBin, 0)) == nil {
+		t.Logf("UnmarshalBinary() SHOULD fail on truncated or trailing data\n")
+		t.Fail()
+	}
+
+	nonces := make([]*SignNonce, len(shares))
+	commitments := make([][]byte, len(shares))
+	for j := range shares {
+		nonces[j], err = shares[j].Commit(rand.Reader)
+		if err != nil {
+			t.Fatalf("Commit() error: %v\n", err)
+		}
+		commitments[j] = nonces[j].Commitment
+	}
+
+	R, err := AggregateCommitments(commitments)
+	if err != nil {
+		t.Fatalf("AggregateCommitments() error: %v\n", err)
+	}
+
+	partials := make([][]byte, len(shares))
+	for j := range shares {
+		partials[j], err = shares[j].PartialSign(nonces[j], R, "test", []byte("message"))
+		if err != nil {
+			t.Fatalf("PartialSign() error: %v\n", err)
+		}
+	}
+
+	sig, err := CombinePartialSignatures(R, partials)
+	if err != nil {
+		t.Fatalf("CombinePartialSignatures() error: %v\n", err)
+	}
+
+	err = i.VerifyWithContext("test", []byte("message"), sig)
+	if err != nil {
+		t.Logf("VerifyWithContext() error: %v\n", err)
+		t.Fail()
+	}
+
+	if !ed25519.Verify(shares[0].Public(), contextMessage("test", []byte("message")), sig) {
+		t.Logf("ed25519.Verify() failed\n")
+		t.Fail()
+	}
+
+	// nonces are single use.
+	_, err = shares[0].PartialSign(nonces[0], R, "test", []byte("other"))
+	if err == nil {
+		t.Logf("PartialSign() SHOULD fail with a used nonce\n")
+		t.Fail()
+	}
+
+	// a missing share gives an invalid signature.
+	sig, _ = CombinePartialSignatures(R, partials[:1])
+	if i.VerifyWithContext("test", []byte("message"), sig) == nil {
+		t.Logf("VerifyWithContext() SHOULD fail with a missing share\n")
+		t.Fail()
+	}
+}
+
+func TestChallengeResponse(t *testing.T) {
+	var trusted []*IdentityPublicKey
+	var keys []*IdentityKey
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, err := NewIdentityKey(keytype)
+		if err != nil {
+			t.Fatalf("NewIdentityKey() error: %v\n", err)
+		}
+		keyBin, err := i.PubToDER()
+		if err != nil {
+			t.Fatalf("PubToDER() error: %v\n", err)
+		}
+		keys = append(keys, i)
+		trusted = append(trusted, &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin})
+	}
+
+	challenge := []byte("challenge")
+	for k, i := range keys {
+		response, err := i.RespondChallenge(challenge)
+		if err != nil {
+			t.Fatalf("RespondChallenge() error: %v\n", err)
+		}
+
+		pub, err := VerifyChallengeResponse(challenge, response, trusted)
+		if err != nil || pub != trusted[k] {
+			t.Logf("%s VerifyChallengeResponse() error: %v\n", i.Type(), err)
+			t.Fail()
+		}
+
+		_, err = VerifyChallengeResponse([]byte("other"), response, trusted)
+		if err == nil {
+			t.Logf("%s VerifyChallengeResponse() SHOULD fail with another challenge\n", i.Type())
+			t.Fail()
+		}
+
+		_, err = VerifyChallengeResponse(challenge, response, trusted[k+1:])
+		if err == nil {
+			t.Logf("%s VerifyChallengeResponse() SHOULD fail with an untrusted key\n", i.Type())
+			t.Fail()
+		}
+	}
+}
+
+func TestSignJSONCanonical(t *testing.T) {
+	i, err := NewIdentityKey(KEYEC25519)
+	if err != nil {
+		t.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+
+	type obj struct {
+		B string            `json:"b"`
+		A int               `json:"a"`
+		M map[string]string `json:"m"`
+	}
+	v := obj{B: "<b>é", A: 1, M: map[string]string{"y": "1", "x": "2"}}
+
+	canon, err := CanonicalJSON(v)
+	if err != nil {
+		t.Fatalf("CanonicalJSON() error: %v\n", err)
+	}
+	if string(canon) != `{"a":1,"b":"<b>`+"é"+`","m":{"x":"2","y":"1"}}` {
+		t.Logf("CanonicalJSON() unexpected: %s\n", canon)
+		t.Fail()
+	}
+
+	sig, err := i.SignJSON(v)
+	if err != nil {
+		t.Fatalf("SignJSON() error: %v\n", err)
+	}
+
+	// same object, different field order and whitespace.
+	other := json.RawMessage(`{ "m": {"y":"1","x":"2"}, "b": "<b>` + "é" + `", "a": 1 }`)
+	err = i.VerifyJSON(other, sig)
+	if err != nil {
+		t.Logf("VerifyJSON() error: %v\n", err)
+		t.Fail()
+	}
+
+	v.A = 2
+	err = i.VerifyJSON(v, sig)
+	if err == nil {
+		t.Logf("VerifyJSON() SHOULD fail with another object\n")
+		t.Fail()
+	}
+}
+
+func TestSignPrehashed(t *testing.T) {
+	i, err := NewIdentityKey(KEYEC25519)
+	if err != nil {
+		t.Fatalf("NewIdentityKey() error: %v\n", err)
+	}
+
+	digest := sha512.Sum512([]byte("message"))
+	sig, err := i.SignPrehashed("test", digest[:])
+	if err != nil {
+		t.Fatalf("SignPrehashed() error: %v\n", err)
+	}
+
+	err = i.VerifyPrehashed("test", digest[:], sig)
+	if err != nil {
+		t.Logf("VerifyPrehashed() error: %v\n", err)
+		t.Fail()
+	}
+
+	// prehashed and pure signatures never mix.
+	if i.VerifyWithContext("test", digest[:], sig) == nil || i.VerifyWithContext("test", []byte("message"), sig) == nil {
+		t.Logf("VerifyWithContext() SHOULD fail with an Ed25519ph signature\n")
+		t.Fail()
+	}
+	if i.VerifyPrehashed("other", digest[:], sig) == nil {
+		t.Logf("VerifyPrehashed() SHOULD fail with another context\n")
+		t.Fail()
+	}
+}
+
+func TestEphemeralIdentity(t *testing.T) {
+	i, cleanup, err := NewEphemeralIdentity(KEYEC25519)
+	if err != nil {
+		t.Fatalf("NewEphemeralIdentity() error: %v\n", err)
+	}
+
+	sig, err := i.SignWithContext("ephemeral", rand.Reader, []byte("message"))
+	if err != nil {
+		t.Fatalf("SignWithContext() error: %v\n", err)
+	}
+
+	cleanup()
+	_, err = i.SignWithContext("ephemeral", rand.Reader, []byte("message"))
+	if err != ErrDestroyed {
+		t.Logf("SignWithContext() SHOULD fail after cleanup: %v\n", err)
+		t.Fail()
+	}
+	if i.VerifyWithContext("ephemeral", []byte("message"), sig) != nil {
+		t.Logf("VerifyWithContext() SHOULD still work after cleanup\n")
+		t.Fail()
+	}
+}
+
+func TestSSHSig(t *testing.T) {
+	msg := []byte("message")
+	defer ResetDefaults()
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		keyBin, _ := i.pubKeyBin()
+		pub := &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin}
+
+		sig, err := i.SignSSHSig(rand.Reader, msg)
+		if err != nil {
+			t.Fatalf("SignSSHSig() error: %v\n", err)
+		}
+
+		err = pub.VerifySSHSig(msg, sig)
+		if err != nil {
+			t.Logf("%s VerifySSHSig() error: %v\n", i.Type(), err)
+			t.Fail()
+		}
+
+		if pub.VerifySSHSig([]byte("other"), sig) == nil {
+			t.Logf("%s VerifySSHSig() SHOULD fail on another message\n", i.Type())
+			t.Fail()
+		}
+
+		other, _ := NewIdentityKey(keytype)
+		otherBin, _ := other.pubKeyBin()
+		if (&IdentityPublicKey{KeyType: keytype, KeyBin: otherBin}).VerifySSHSig(msg, sig) == nil {
+			t.Logf("%s VerifySSHSig() SHOULD fail with another key\n", i.Type())
+			t.Fail()
+		}
+
+		if pub.VerifySSHSigWithNamespace("git", msg, sig) == nil {
+			t.Logf("%s VerifySSHSigWithNamespace() SHOULD fail in another namespace\n", i.Type())
+			t.Fail()
+		}
+
+		sig, err = i.SignSSHSigWithNamespace(rand.Reader, "git", msg)
+		if err != nil {
+			t.Fatalf("SignSSHSigWithNamespace() error: %v\n", err)
+		}
+		err = pub.VerifySSHSigWithNamespace("git", msg, sig)
+		if err != nil {
+			t.Logf("%s VerifySSHSigWithNamespace() error: %v\n", i.Type(), err)
+			t.Fail()
+		}
+		if pub.VerifySSHSig(msg, sig) == nil {
+			t.Logf("%s VerifySSHSig() SHOULD fail on a \"git\" signature\n", i.Type())
+			t.Fail()
+		}
+
+		SSHSigNamespace = "git"
+		if pub.VerifySSHSig(msg, sig) != nil {
+			t.Logf("%s VerifySSHSig() SHOULD use SSHSigNamespace\n", i.Type())
+			t.Fail()
+		}
+		SSHSigNamespace = "file"
+	}
+
+	i, _ := NewIdentityKey(KEYEC25519)
+	if _, err := i.SignSSHSigWithNamespace(rand.Reader, "", msg); err == nil {
+		t.Logf("SignSSHSigWithNamespace() SHOULD refuse an empty namespace\n")
+		t.Fail()
+	}
+}
+
+func TestMultiSignature(t *testing.T) {
+	msg := []byte("document")
+
+	var keys []*IdentityKey
+	var required []*IdentityPublicKey
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		keyBin, _ := i.pubKeyBin()
+		keys = append(keys, i)
+		required = append(required, &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin})
+	}
+
+	var m MultiSignature
+	for _, i := range keys[:2] {
+		err := m.Add(i, msg)
+		if err != nil {
+			t.Fatalf("Add() error: %v\n", err)
+		}
+	}
+	if m.VerifyMulti(msg, required) == nil {
+		t.Logf("VerifyMulti() SHOULD fail with a missing signer\n")
+		t.Fail()
+	}
+
+	m.Add(keys[2], msg)
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error: %v\n", err)
+	}
+	parsed, err := ParseMultiSignature(data)
+	if err != nil {
+		t.Fatalf("ParseMultiSignature() error: %v\n", err)
+	}
+
+	err = parsed.VerifyMulti(msg, required)
+	if err != nil {
+		t.Logf("VerifyMulti() error: %v\n", err)
+		t.Fail()
+	}
+	if parsed.VerifyMulti([]byte("other"), required) == nil {
+		t.Logf("VerifyMulti() SHOULD fail on another message\n")
+		t.Fail()
+	}
+}
+
+func TestVerifyQuorum(t *testing.T) {
+	msg := []byte("approve release")
+
+	var keys []*IdentityKey
+	var trusted []*IdentityPublicKey
+	for n := 0; n < 3; n++ {
+		i, _ := NewIdentityKey(KEYEC25519)
+		keyBin, _ := i.pubKeyBin()
+		keys = append(keys, i)
+		trusted = append(trusted, &IdentityPublicKey{KeyType: KEYEC25519, KeyBin: keyBin})
+	}
+
+	var m MultiSignature
+	m.Add(keys[0], msg)
+	m.Add(keys[2], msg)
+	outsider, _ := NewIdentityKey(KEYEC25519)
+	m.Add(outsider, msg)
+	sig, _ := m.Marshal()
+
+	matched, err := VerifyQuorum(msg, sig, trusted, 2)
+	if err != nil || len(matched) != 2 || matched[0] != trusted[0] || matched[1] != trusted[2] {
+		t.Logf("VerifyQuorum() 2-of-3 error: %v [%d matched]\n", err, len(matched))
+		t.Fail()
+	}
+
+	matched, err = VerifyQuorum(msg, sig, trusted, 3)
+	if err != ErrQuorumNotMet || len(matched) != 2 {
+		t.Logf("VerifyQuorum() 3-of-3 SHOULD fail with ErrQuorumNotMet: %v\n", err)
+		t.Fail()
+	}
+
+	// the same signer listed twice does not make a quorum.
+	_, err = VerifyQuorum(msg, sig, append(trusted[:1:1], trusted[0], trusted[1]), 2)
+	if err != ErrQuorumNotMet {
+		t.Logf("VerifyQuorum() SHOULD not count a duplicated key twice: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestSignSSHCertificate(t *testing.T) {
+	user, _ := NewIdentityKey(KEYEC25519)
+	userBin, _ := user.pubKeyBin()
+	userPub, _ := (&IdentityPublicKey{KeyType: KEYEC25519, KeyBin: userBin}).CryptoPublicKey()
+	userSSH, _ := ssh.NewPublicKey(userPub)
+
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		ca, _ := NewIdentityKey(keytype)
+		caSigner, _ := ca.ToSSHSigner()
+
+		cert := &ssh.Certificate{
+			Key:             userSSH,
+			CertType:        ssh.UserCert,
+			KeyId:           "user",
+			ValidPrincipals: []string{"user"},
+			ValidBefore:     ssh.CertTimeInfinity,
+		}
+		err := ca.SignSSHCertificate(cert)
+		if err != nil {
+			t.Fatalf("%s SignSSHCertificate() error: %v\n", ca.Type(), err)
+		}
+
+		checker := &ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return bytes.Equal(auth.Marshal(), caSigner.PublicKey().Marshal())
+			},
+		}
+		_, err = checker.Authenticate(sshConnMetadata("user"), cert)
+		if err != nil {
+			t.Logf("%s certificate does not verify: %v\n", ca.Type(), err)
+			t.Fail()
+		}
+		if keytype == KEYRSA && cert.Signature.Format != ssh.KeyAlgoRSASHA512 {
+			t.Logf("RSA certificate signed with %s\n", cert.Signature.Format)
+			t.Fail()
+		}
+
+		// the signer handed out before follows the key.
+		ca.WithRateLimit(rate.NewLimiter(0, 0))
+		err = ca.SignSSHCertificate(cert)
+		if err != ErrRateLimited {
+			t.Logf("%s SignSSHCertificate() SHOULD be rate limited: %v\n", ca.Type(), err)
+			t.Fail()
+		}
+		ca.WithRateLimit(nil)
+		ca.Destroy()
+		_, err = caSigner.Sign(rand.Reader, []byte("data"))
+		if err != ErrDestroyed {
+			t.Logf("%s ssh.Signer after Destroy() SHOULD fail: %v\n", ca.Type(), err)
+			t.Fail()
+		}
+	}
+}
+
+// sshConnMetadata is the minimal ssh.ConnMetadata CertChecker needs.
+type sshConnMetadata string
+
+func (m sshConnMetadata) User() string          { return string(m) }
+func (m sshConnMetadata) SessionID() []byte     { return nil }
+func (m sshConnMetadata) ClientVersion() []byte { return nil }
+func (m sshConnMetadata) ServerVersion() []byte { return nil }
+func (m sshConnMetadata) RemoteAddr() net.Addr  { return nil }
+func (m sshConnMetadata) LocalAddr() net.Addr   { return nil }
+
+func TestWithExpiry(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	i.WithExpiry(100 * time.Millisecond)
+	msg := []byte("message")
+
+	sig, err := i.SignWithContext("expiry", rand.Reader, msg)
+	if err != nil {
+		t.Fatalf("SignWithContext() error: %v\n", err)
+	}
+
+	err = i.Renew(400 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Renew() error: %v\n", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	_, err = i.SignMessage(msg, 0)
+	if err != nil {
+		t.Fatalf("SignMessage() after Renew() error: %v\n", err)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	_, err = i.SignWithContext("expiry", rand.Reader, msg)
+	if err != ErrKeyExpired {
+		t.Logf("SignWithContext() SHOULD fail with ErrKeyExpired: %v\n", err)
+		t.Fail()
+	}
+	_, err = i.PrivToDER([]byte("passphrase"))
+	if err != ErrKeyExpired {
+		t.Logf("PrivToDER() SHOULD fail with ErrKeyExpired: %v\n", err)
+		t.Fail()
+	}
+	if i.Renew(time.Second) != ErrKeyExpired {
+		t.Logf("Renew() SHOULD fail with ErrKeyExpired\n")
+		t.Fail()
+	}
+	if !bytes.Equal(i.ec25519.Priv.Seed(), make([]byte, ed25519.SeedSize)) {
+		t.Logf("the private key SHOULD be zeroed\n")
+		t.Fail()
+	}
+
+	// the public part is still there.
+	err = i.VerifyWithContext("expiry", msg, sig)
+	if err != nil {
+		t.Logf("VerifyWithContext() error: %v\n", err)
+		t.Fail()
+	}
+}
+
+// run with -race: one key shared by many goroutines.
+func TestConcurrentSign(t *testing.T) {
+	for _, kt := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, err := NewIdentityKey(kt)
+		if err != nil {
+			t.Fatalf("NewIdentityKey(%d) error: %v\n", kt, err)
+		}
+		fp, _ := i.Fingerprint()
+
+		errs := make(chan error, 64)
+		var wg sync.WaitGroup
+		for n := 0; n < 16; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				for j := 0; j < 4; j++ {
+					msg := []byte{byte(n), byte(j)}
+					sig, err := i.SignWithContext("concurrent", rand.Reader, msg)
+					if err == nil {
+						err = i.VerifyWithContext("concurrent", msg, sig)
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+					if f, _ := i.Fingerprint(); f != fp {
+						errs <- errors.New("fingerprint changed: " + f)
+						return
+					}
+				}
+			}(n)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Logf("key type %d concurrent sign error: %v\n", kt, err)
+			t.Fail()
+		}
+	}
+
+	// Destroy and the expiry wait for the signatures in progress.
+	i, _ := NewIdentityKey(KEYEC25519)
+	i.WithExpiry(time.Millisecond)
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 16; j++ {
+				msg := []byte("message")
+				sig, err := i.SignWithContext("concurrent", rand.Reader, msg)
+				if err == nil && i.VerifyWithContext("concurrent", msg, sig) != nil {
+					t.Logf("signature made during expiry does not verify\n")
+					t.Fail()
+				}
+			}
+		}()
+	}
+	i.Destroy()
+	wg.Wait()
+}
+
+type countHook struct {
+	signs int32
+}
+
+func (h *countHook) OnSign(fingerprint, algorithm string, elapsed time.Duration) {
+	atomic.AddInt32(&h.signs, 1)
+}
+func (h *countHook) OnVerify(fingerprint, algorithm string, elapsed time.Duration)  {}
+func (h *countHook) OnKeyLoad(fingerprint, algorithm string, elapsed time.Duration) {}
+
+// the crypto.Signer given out goes through the key checks on every call.
+func TestKeySigner(t *testing.T) {
+	hook := new(countHook)
+	SetKeyHook(hook)
+	defer SetKeyHook(nil)
+
+	i, _ := NewIdentityKey(KEYECDSA)
+	signer, err := i.signer()
+	if err != nil {
+		t.Fatalf("signer() error: %v\n", err)
+	}
+	if _, raw := signer.(*ecdsa.PrivateKey); raw {
+		t.Fatalf("signer() returned the private key itself\n")
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign() error: %v\n", err)
+	}
+	if !ecdsa.VerifyASN1(&i.ecdsa.PublicKey, digest[:], sig) {
+		t.Logf("Sign() signature does not verify\n")
+		t.Fail()
+	}
+	if atomic.LoadInt32(&hook.signs) != 1 {
+		t.Logf("OnSign called %d times\n", hook.signs)
+		t.Fail()
+	}
+
+	i.WithRateLimit(rate.NewLimiter(0, 0))
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != ErrRateLimited {
+		t.Logf("Sign() SHOULD be rate limited: %v\n", err)
+		t.Fail()
+	}
+	i.WithRateLimit(nil)
+
+	i.Destroy()
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != ErrDestroyed {
+		t.Logf("Sign() after Destroy() SHOULD fail: %v\n", err)
+		t.Fail()
+	}
+}
+
+// SignMessage reports to the hook like SignWithContext does.
+func TestSignMessageHook(t *testing.T) {
+	hook := new(countHook)
+	SetKeyHook(hook)
+	defer SetKeyHook(nil)
+
+	i, _ := NewIdentityKey(KEYECDSA)
+	_, err := i.SignMessage([]byte("message"), crypto.SHA256)
+	if err != nil {
+		t.Fatalf("SignMessage() error: %v\n", err)
+	}
+	if atomic.LoadInt32(&hook.signs) != 1 {
+		t.Logf("OnSign called %d times\n", hook.signs)
+		t.Fail()
+	}
+}
+
+// SignPrehashed reports to the hook like SignWithContext does.
+func TestSignPrehashedHook(t *testing.T) {
+	hook := new(countHook)
+	SetKeyHook(hook)
+	defer SetKeyHook(nil)
+
+	i, _ := NewIdentityKey(KEYEC25519)
+	digest := sha512.Sum512([]byte("message"))
+	_, err := i.SignPrehashed("hook", digest[:])
+	if err != nil {
+		t.Fatalf("SignPrehashed() error: %v\n", err)
+	}
+	if atomic.LoadInt32(&hook.signs) != 1 {
+		t.Logf("OnSign called %d times\n", hook.signs)
+		t.Fail()
+	}
+}
+
+func TestRSAPublicExponent(t *testing.T) {
+	for _, e := range []int{1, 4, 0x7fffffff + 1} {
+		_, err := NewIdentityKeyWithParams(KEYRSA, KeyParams{RSAPublicExponent: e})
+		if err != ErrInvalidRSAExponent {
+			t.Logf("NewIdentityKeyWithParams(e = %d) SHOULD fail: %v\n", e, err)
+			t.Fail()
+		}
+	}
+
+	i, err := NewIdentityKeyWithParams(KEYRSA, KeyParams{RSAPublicExponent: 3})
+	if err != nil {
+		t.Fatalf("NewIdentityKeyWithParams(e = 3) error: %v\n", err)
+	}
+	if i.rsa.E != 3 || i.rsa.N.BitLen() != KEYSIZE_RSA {
+		t.Fatalf("NewIdentityKeyWithParams(e = 3) key: e = %d, %d bits\n", i.rsa.E, i.rsa.N.BitLen())
+	}
+
+	buf := new(bytes.Buffer)
+	err = i.PrivToPKIX(buf, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("PrivToPKIX() error: %v\n", err)
+	}
+	loaded := new(IdentityKey)
+	err = loaded.PKIXToPriv(buf, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("PKIXToPriv() error: %v\n", err)
+	}
+
+	sig, err := loaded.SignWithContext("test", rand.Reader, []byte("message"))
+	if err != nil {
+		t.Fatalf("SignWithContext() error: %v\n", err)
+	}
+	err = i.VerifyWithContext("test", []byte("message"), sig)
+	if err != nil {
+		t.Logf("VerifyWithContext() error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestSignedPublicBundle(t *testing.T) {
+	i, _ := NewIdentityKey(KEYEC25519)
+	data, err := i.SignedPublicBundle("signing")
+	if err != nil {
+		t.Fatalf("SignedPublicBundle() error: %v\n", err)
+	}
+
+	pub, info, err := VerifySignedPublicBundle(data)
+	if err != nil {
+		t.Fatalf("VerifySignedPublicBundle() error: %v\n", err)
+	}
+	keyBin, _ := i.pubKeyBin()
+	if pub.KeyType != KEYEC25519 || !bytes.Equal(pub.KeyBin, keyBin) || pub.KeyOwner != i.keyOwner.String() {
+		t.Logf("VerifySignedPublicBundle() returned another key\n")
+		t.Fail()
+	}
+	if info.Usage != "signing" || time.Since(info.Created) > time.Minute {
+		t.Logf("VerifySignedPublicBundle() info: %+v\n", info)
+		t.Fail()
+	}
+
+	other, _ := NewIdentityKey(KEYEC25519)
+	otherLine := new(bytes.Buffer)
+	other.PubToPKIX(otherLine)
+
+	tamper := map[string]func(*signedPublicBundle){
+		"owner": func(s *signedPublicBundle) {
+			fields := strings.Fields(s.Bundle.Key)
+			fields[len(fields)-1] = other.keyOwner.String()
+			s.Bundle.Key = strings.Join(fields, " ")
+		},
+		"key": func(s *signedPublicBundle) {
+			s.Bundle.Key = strings.TrimSpace(otherLine.String())
+		},
+		"usage": func(s *signedPublicBundle) {
+			s.Bundle.Usage = "encryption"
+		},
+		// the bundle of i, signed by other.
+		"signer": func(s *signedPublicBundle) {
+			msg, _ := CanonicalJSON(s.Bundle)
+			s.Signature, _ = other.SignWithContext(bundleContext, rand.Reader, msg)
+		},
+	}
+	for name, f := range tamper {
+		var signed signedPublicBundle
+		json.Unmarshal(data, &signed)
+		f(&signed)
+		tampered, _ := json.Marshal(signed)
+		_, _, err = VerifySignedPublicBundle(tampered)
+		if err == nil {
+			t.Logf("VerifySignedPublicBundle() SHOULD fail on a tampered %s\n", name)
+			t.Fail()
+		}
+	}
+}
+
+func TestVerifyDetailed(t *testing.T) {
+	want := map[int]VerifyResult{
+		KEYRSA:     {Algorithm: "RSA-PSS", Hash: "SHA3-256"},
+		KEYECDSA:   {Algorithm: "ECDSA-P-256", Hash: "SHA3-256"},
+		KEYEC25519: {Algorithm: "Ed25519", Hash: "SHA-512"},
+	}
+	msg := []byte("message")
+
+	for keytype, w := range want {
+		i, _ := NewIdentityKey(keytype)
+		keyBin, _ := i.pubKeyBin()
+		pub := &IdentityPublicKey{KeyType: keytype, KeyBin: keyBin}
+		sig, err := i.SignWithContext("detailed", rand.Reader, msg)
+		if err != nil {
+			t.Fatalf("SignWithContext() error: %v\n", err)
+		}
+
+		w.OK = true
+		res, err := pub.VerifyDetailed("detailed", msg, sig)
+		if err != nil || res != w {
+			t.Logf("%s VerifyDetailed() = %+v, %v\n", K2S[keytype], res, err)
+			t.Fail()
+		}
+		res, err = i.VerifyDetailed("detailed", msg, sig)
+		if err != nil || res != w {
+			t.Logf("%s IdentityKey VerifyDetailed() = %+v, %v\n", K2S[keytype], res, err)
+			t.Fail()
+		}
+
+		// a bad signature still tells the scheme it was checked with.
+		w.OK = false
+		sig[len(sig)-1] ^= 0x01
+		res, err = pub.VerifyDetailed("detailed", msg, sig)
+		if err == nil || res != w {
+			t.Logf("%s VerifyDetailed(bad signature) = %+v, %v\n", K2S[keytype], res, err)
+			t.Fail()
+		}
+	}
+}
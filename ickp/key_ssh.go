@@ -0,0 +1,38 @@
+package ickp
+
+import (
+	"crypto/rand"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ToSSHSigner returns an ssh.Signer backed by the identity key, RSA keys
+// only sign with rsa-sha2-512 / rsa-sha2-256, never SHA-1. Its signatures
+// are rate limited, reported to the KeyHook and fail once the key is
+// destroyed or expired, like SignWithContext ones.
+func (i *IdentityKey) ToSSHSigner() (ssh.Signer, error) {
+	priv, err := i.signer()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromSigner(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.keyType == KEYRSA {
+		return ssh.NewSignerWithAlgorithms(signer.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256})
+	}
+	return signer, nil
+}
+
+// SignSSHCertificate signs cert with the identity key acting as the SSH CA,
+// setting its nonce, SignatureKey and Signature. It counts against the
+// WithRateLimit limit.
+func (i *IdentityKey) SignSSHCertificate(cert *ssh.Certificate) error {
+	signer, err := i.ToSSHSigner()
+	if err != nil {
+		return err
+	}
+	return cert.SignCert(rand.Reader, signer)
+}
@@ -0,0 +1,236 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const pemSSHSignature = "SSH SIGNATURE"
+
+var sshSigMagic = []byte("SSHSIG")
+
+// OpenSSH refuses to sign or verify without a namespace.
+var errEmptySSHSigNamespace = errors.New("empty SSH signature namespace")
+
+// sshSigBlob is the OpenSSH PROTOCOL.sshsig signature, after the magic.
+type sshSigBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSigSignedData returns what the SSH signature is actually computed on.
+func sshSigSignedData(namespace, hashAlg string, message []byte) ([]byte, error) {
+	var h []byte
+	switch hashAlg {
+	case "sha256":
+		sum := sha256.Sum256(message)
+		h = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(message)
+		h = sum[:]
+	default:
+		return nil, errors.New("unsupported SSH signature hash")
+	}
+
+	data := ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{namespace, "", hashAlg, h})
+	return append(append([]byte(nil), sshSigMagic...), data...), nil
+}
+
+// signer returns a crypto.Signer backed by the identity key, see keySigner.
+func (i *IdentityKey) signer() (crypto.Signer, error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if !i.initialized() {
+		return nil, ErrUninitialized
+	}
+	err = i.fipsCheckKey()
+	if err != nil {
+		return nil, err
+	}
+	return keySigner{i}, nil
+}
+
+// keySigner is the crypto.Signer handed to crypto/tls, x/crypto/ssh...
+// rather than the private key itself: every signature goes through the same
+// checks as SignWithContext (Destroy, expiry, FIPS mode, WithRateLimit) and
+// is reported to the KeyHook.
+type keySigner struct {
+	i *IdentityKey
+}
+
+func (s keySigner) Public() crypto.PublicKey {
+	return s.i.public()
+}
+
+func (s keySigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hook := getKeyHook()
+	if hook == nil {
+		return s.sign(rand, digest, opts)
+	}
+
+	start := time.Now()
+	sig, err := s.sign(rand, digest, opts)
+	fp, alg := s.i.hookArgs()
+	hook.OnSign(fp, alg, time.Since(start))
+	return sig, err
+}
+
+func (s keySigner) sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	i := s.i
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if !i.initialized() {
+		return nil, ErrUninitialized
+	}
+	err = i.fipsCheckKey()
+	if err == nil {
+		err = fipsCheckRand(rand)
+	}
+	if err == nil {
+		err = fipsCheckHash(opts.HashFunc())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i.limiter != nil && !i.limiter.Allow() {
+		return nil, ErrRateLimited
+	}
+
+	switch i.keyType {
+	case KEYRSA:
+		return i.rsa.Sign(rand, digest, opts)
+	case KEYECDSA:
+		return i.ecdsa.Sign(rand, digest, opts)
+	case KEYEC25519:
+		return i.ec25519.Priv.Sign(rand, digest, opts)
+	}
+	return i.custom.Sign(rand, digest, opts)
+}
+
+// SignSSHSig signs message in the armored OpenSSH signature format under
+// SSHSigNamespace, the signature verifies with ssh-keygen -Y verify.
+func (i *IdentityKey) SignSSHSig(rand io.Reader, message []byte) ([]byte, error) {
+	return i.SignSSHSigWithNamespace(rand, SSHSigNamespace, message)
+}
+
+// SignSSHSigWithNamespace is SignSSHSig under namespace rather than
+// SSHSigNamespace, i.e. "git" next to "file" signatures in one program.
+func (i *IdentityKey) SignSSHSigWithNamespace(rand io.Reader, namespace string, message []byte) ([]byte, error) {
+	if len(namespace) == 0 {
+		return nil, errEmptySSHSigNamespace
+	}
+	signer, err := i.ToSSHSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := sshSigSignedData(namespace, "sha512", message)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig *ssh.Signature
+	if i.keyType == KEYRSA {
+		// OpenSSH refuses SHA-1 RSA signatures here.
+		sig, err = signer.(ssh.AlgorithmSigner).SignWithAlgorithm(rand, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := ssh.Marshal(sshSigBlob{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  pemSSHSignature,
+		Bytes: append(append([]byte(nil), sshSigMagic...), blob...),
+	}), nil
+}
+
+// VerifySSHSig checks sshSig is an armored OpenSSH signature of message
+// (i.e. from ssh-keygen -Y sign) made under SSHSigNamespace with the
+// private key matching this public key.
+func (p *IdentityPublicKey) VerifySSHSig(message, sshSig []byte) error {
+	return p.VerifySSHSigWithNamespace(SSHSigNamespace, message, sshSig)
+}
+
+// VerifySSHSigWithNamespace is VerifySSHSig under namespace rather than
+// SSHSigNamespace.
+func (p *IdentityPublicKey) VerifySSHSigWithNamespace(namespace string, message, sshSig []byte) error {
+	if len(namespace) == 0 {
+		return errEmptySSHSigNamespace
+	}
+	block, _ := pem.Decode(normalizeNewlines(sshSig))
+	if block == nil || block.Type != pemSSHSignature || !bytes.HasPrefix(block.Bytes, sshSigMagic) {
+		return errors.New("no SSH SIGNATURE found")
+	}
+
+	var blob sshSigBlob
+	err := ssh.Unmarshal(block.Bytes[len(sshSigMagic):], &blob)
+	if err != nil {
+		return err
+	}
+	if blob.Version != 1 {
+		return errors.New("unsupported SSH signature version")
+	}
+	if blob.Namespace != namespace {
+		return errors.New("SSH signature namespace mismatch")
+	}
+
+	cpub, err := p.CryptoPublicKey()
+	if err != nil {
+		return err
+	}
+	sshPub, err := ssh.NewPublicKey(cpub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sshPub.Marshal(), blob.PublicKey) {
+		return errors.New("SSH signature made by another key")
+	}
+
+	var sig ssh.Signature
+	err = ssh.Unmarshal(blob.Signature, &sig)
+	if err != nil {
+		return err
+	}
+	if sig.Format == ssh.KeyAlgoRSA {
+		return errors.New("SHA-1 RSA SSH signatures are not supported")
+	}
+
+	data, err := sshSigSignedData(blob.Namespace, blob.HashAlgorithm, message)
+	if err != nil {
+		return err
+	}
+	return sshPub.Verify(data, &sig)
+}
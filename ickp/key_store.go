@@ -0,0 +1,72 @@
+package ickp
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+)
+
+// KeyStore saves and loads identity keys by name, FileKeyStore is the key
+// files one and MemoryKeyStore keeps them in memory, i.e. for tests.
+type KeyStore interface {
+	Save(name string, k *IdentityKey, passwd []byte) error
+	Load(name string, passwd []byte) (*IdentityKey, error)
+}
+
+// FileKeyStore stores the keys as Dir/<name> / Dir/<name>.pub key files.
+type FileKeyStore struct {
+	Dir string
+}
+
+func (s FileKeyStore) Save(name string, k *IdentityKey, passwd []byte) error {
+	return k.ToKeyFiles(filepath.Join(s.Dir, name), passwd)
+}
+
+func (s FileKeyStore) Load(name string, passwd []byte) (*IdentityKey, error) {
+	return LoadIdentityKey(filepath.Join(s.Dir, name), passwd)
+}
+
+// MemoryKeyStore keeps the keys in memory, encrypted with the passphrase
+// exactly like the private key files, so a wrong passphrase fails the same.
+// The zero value is ready to use and it is safe for concurrent use.
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (s *MemoryKeyStore) Save(name string, k *IdentityKey, passwd []byte) error {
+	buf := new(bytes.Buffer)
+	err := k.PrivToPKIX(buf, passwd)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string][]byte)
+	}
+	s.keys[name] = buf.Bytes()
+	return nil
+}
+
+func (s *MemoryKeyStore) Load(name string, passwd []byte) (*IdentityKey, error) {
+	s.mu.Lock()
+	blob, ok := s.keys[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errors.New("no such key: " + name)
+	}
+
+	i := new(IdentityKey)
+	err := i.PKIXToPriv(bytes.NewReader(blob), passwd)
+	if err == nil {
+		err = i.Validate()
+	}
+	if err != nil {
+		i.wipe()
+		return nil, err
+	}
+	return i, nil
+}
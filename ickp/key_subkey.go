@@ -0,0 +1,53 @@
+package ickp
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/sha3"
+)
+
+const subkeyInfoPrefix = "ic-subkey:"
+
+// DeriveSubkey deterministically derives a child EC25519 identity key from
+// the master key and label: the same master and label always give the same
+// subkey. The child seed is HKDF-SHA3-256(master seed, info: "ic-subkey:" ||
+// label), the subkey holds no master material and the master cannot be
+// recovered from it.
+func (i *IdentityKey) DeriveSubkey(label string) (*IdentityKey, error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if i.keyType != KEYEC25519 || i.ec25519 == nil {
+		return nil, errors.New("subkeys can only be derived from EC25519 keys")
+	}
+
+	seed := make([]byte, ed25519.SeedSize)
+	kdf := hkdf.New(sha3.New256, i.ec25519.Priv.Seed(), nil, []byte(subkeyInfoPrefix+label))
+	_, err = io.ReadFull(kdf, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := new(IdentityKey)
+	sub.keyType = KEYEC25519
+	sub.ec25519 = new(Ed25519PrivateKey)
+	sub.ec25519.Priv = ed25519.NewKeyFromSeed(seed)
+	sub.ec25519.Pub = sub.ec25519.Priv.Public().(ed25519.PublicKey)
+
+	// we don't need the seed anymore.
+	for k := range seed {
+		seed[k] = 0
+	}
+
+	err = sub.setOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
@@ -0,0 +1,121 @@
+package ickp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// sumLabel keys the key files HMAC apart from any other use of the
+// passphrase derived key.
+const sumLabel = "ic-key-files-sum"
+
+// ErrKeyFilesDigest is returned by VerifyKeyFilesDigest when the key files
+// (or the passphrase) do not match the ".sum" file.
+var ErrKeyFilesDigest = errors.New("key files digest mismatch")
+
+// keyFilesMAC is the HMAC-SHA3-256 of both key files contents, length
+// prefixed, with a key derived from passwd.
+func keyFilesMAC(passwd, salt []byte, params KDFParams, pub, priv []byte) []byte {
+	kdfKey := deriveKey(passwd, salt, &params)
+	defer wipeBytes(kdfKey)
+
+	sub := hmac.New(sha3.New256, kdfKey)
+	sub.Write([]byte(sumLabel))
+	macKey := sub.Sum(nil)
+	defer wipeBytes(macKey)
+
+	var size [8]byte
+	mac := hmac.New(sha3.New256, macKey)
+	binary.BigEndian.PutUint64(size[:], uint64(len(pub)))
+	mac.Write(size[:])
+	mac.Write(pub)
+	binary.BigEndian.PutUint64(size[:], uint64(len(priv)))
+	mac.Write(size[:])
+	mac.Write(priv)
+	return mac.Sum(nil)
+}
+
+func readKeyFiles(prefix string) (pub, priv []byte, err error) {
+	pub, err = ioutil.ReadFile(prefix + ".pub")
+	if err != nil {
+		return
+	}
+	priv, err = ioutil.ReadFile(prefix)
+	return
+}
+
+// WriteKeyFilesWithDigest is ToKeyFiles also writing prefix.sum, holding an
+// HMAC of both key files keyed with a passphrase derived key (Argon2id with
+// DefaultKDFParams), that VerifyKeyFilesDigest checks.
+// The file is a single line: <KDF-Info> <hex salt> <hex HMAC>
+// Argon2id not being FIPS approved, it fails with ErrNotFIPSApproved in FIPS
+// mode.
+func (i *IdentityKey) WriteKeyFilesWithDigest(prefix string, passwd []byte) error {
+	if FIPSMode() {
+		return ErrNotFIPSApproved
+	}
+	err := i.ToKeyFiles(prefix, passwd)
+	if err != nil {
+		return err
+	}
+
+	pub, priv, err := readKeyFiles(prefix)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, argon2SaltSize)
+	_, err = io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return err
+	}
+
+	params := DefaultKDFParams
+	mac := keyFilesMAC(passwd, salt, params, pub, priv)
+	line := fmt.Sprintf("%s %s %s\n", params, hex.EncodeToString(salt), hex.EncodeToString(mac))
+	return ioutil.WriteFile(prefix+".sum", []byte(line), 0600)
+}
+
+// VerifyKeyFilesDigest checks prefix and prefix.pub against the digest
+// written in prefix.sum by WriteKeyFilesWithDigest. It returns
+// ErrKeyFilesDigest when either file changed since, or when passwd is not
+// the one they were written with.
+func VerifyKeyFilesDigest(prefix string, passwd []byte) error {
+	if FIPSMode() {
+		return ErrNotFIPSApproved
+	}
+	sum, err := ioutil.ReadFile(prefix + ".sum")
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(sum))
+	if len(fields) != 3 {
+		return errors.New("invalid key files digest")
+	}
+	params, ok := parseKDFInfo(fields[0])
+	salt, errSalt := hex.DecodeString(fields[1])
+	expected, errMac := hex.DecodeString(fields[2])
+	if !ok || !params.withinLimits() || errSalt != nil || errMac != nil || len(salt) != argon2SaltSize {
+		return errors.New("invalid key files digest")
+	}
+
+	pub, priv, err := readKeyFiles(prefix)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(keyFilesMAC(passwd, salt, params, pub, priv), expected) {
+		return ErrKeyFilesDigest
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package ickp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyFilesDigest(t *testing.T) {
+	defer ResetDefaults()
+	DefaultKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+
+	dir, err := ioutil.TempDir("", "ickp")
+	if err != nil {
+		t.Fatalf("TempDir() error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	prefix := filepath.Join(dir, "id")
+	passwd := []byte("passphrase")
+
+	i, _ := NewIdentityKey(KEYEC25519)
+	err = i.WriteKeyFilesWithDigest(prefix, passwd)
+	if err != nil {
+		t.Fatalf("WriteKeyFilesWithDigest() error: %v\n", err)
+	}
+
+	err = VerifyKeyFilesDigest(prefix, passwd)
+	if err != nil {
+		t.Fatalf("VerifyKeyFilesDigest() error: %v\n", err)
+	}
+
+	err = VerifyKeyFilesDigest(prefix, []byte("wrong"))
+	if err != ErrKeyFilesDigest {
+		t.Logf("VerifyKeyFilesDigest() wrong passphrase error: %v\n", err)
+		t.Fail()
+	}
+
+	for _, name := range []string{prefix, prefix + ".pub"} {
+		orig, _ := ioutil.ReadFile(name)
+		flipped := append([]byte(nil), orig...)
+		flipped[len(flipped)/2] ^= 0x01
+		ioutil.WriteFile(name, flipped, 0600)
+
+		err = VerifyKeyFilesDigest(prefix, passwd)
+		if err != ErrKeyFilesDigest {
+			t.Logf("VerifyKeyFilesDigest() %s bit flip error: %v\n", filepath.Base(name), err)
+			t.Fail()
+		}
+		ioutil.WriteFile(name, orig, 0600)
+	}
+}
@@ -0,0 +1,233 @@
+package ickp
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"io"
+
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SignShare is one additive share of an EC25519 identity key, all the n
+// shares are needed to sign: the secret scalar is the sum of the shares.
+// The resulting signatures are standard Ed25519 ones, VerifyWithContext with
+// the original public key checks them.
+//
+// Signing is a 2 rounds protocol between the share holders:
+//  1. each holder calls Commit and publishes the commitment,
+//  2. the commitments are combined with AggregateCommitments,
+//  3. each holder calls PartialSign with its nonce and the aggregate,
+//  4. the partial signatures are combined with CombinePartialSignatures.
+//
+// The commitments must be exchanged commit-then-reveal: every holder first
+// publishes a hash of its commitment (i.e. SHA3-256), and only reveals the
+// commitment once it has all the other hashes. Exchanging them in the
+// clear, or running several signing sessions at once, lets a holder pick
+// its commitment after seeing the others and forge signatures (ROS /
+// Wagner attacks on naive multi-party Schnorr).
+// MarshalBinary exports a share to give it to its holder, it is secret key
+// material and must be protected as such (i.e. AEADEncryptPEMBlock).
+type SignShare struct {
+	Index  int
+	N      int
+	pub    ed25519.PublicKey
+	scalar *edwards25519.Scalar
+}
+
+// signShareVersion is the MarshalBinary format version.
+const signShareVersion = 1
+
+// signShareBin is the MarshalBinary encoding of a SignShare.
+type signShareBin struct {
+	Version int
+	Index   int
+	N       int
+	Pub     []byte
+	Scalar  []byte
+}
+
+// MarshalBinary encodes the share (DER), secret scalar included.
+func (s *SignShare) MarshalBinary() ([]byte, error) {
+	if s.scalar == nil || len(s.pub) != ed25519.PublicKeySize {
+		return nil, ErrUninitialized
+	}
+	return asn1.Marshal(signShareBin{
+		Version: signShareVersion,
+		Index:   s.Index,
+		N:       s.N,
+		Pub:     s.pub,
+		Scalar:  s.scalar.Bytes(),
+	})
+}
+
+// UnmarshalBinary decodes a MarshalBinary share.
+func (s *SignShare) UnmarshalBinary(data []byte) error {
+	var bin signShareBin
+	rest, err := asn1.Unmarshal(data, &bin)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 || bin.Version != signShareVersion || bin.N < 2 || bin.Index < 1 || bin.Index > bin.N {
+		return errors.New("invalid signing key share")
+	}
+	if len(bin.Pub) != ed25519.PublicKeySize {
+		return errors.New("invalid signing key share public key")
+	}
+	_, err = new(edwards25519.Point).SetBytes(bin.Pub)
+	if err != nil {
+		return errors.New("invalid signing key share public key")
+	}
+	scalar, err := edwards25519.NewScalar().SetCanonicalBytes(bin.Scalar)
+	if err != nil {
+		return errors.New("invalid signing key share scalar")
+	}
+
+	*s = SignShare{Index: bin.Index, N: bin.N, pub: ed25519.PublicKey(bin.Pub), scalar: scalar}
+	return nil
+}
+
+// SignNonce is the secret of one Commit, it must only be used once.
+type SignNonce struct {
+	Commitment []byte
+	r          *edwards25519.Scalar
+	used       bool
+}
+
+// SplitSigningKey splits an EC25519 key in n shares, k of them are needed to
+// sign. Only n-of-n (additive) sharing is supported for now, so k must be n.
+func (i *IdentityKey) SplitSigningKey(n, k int) ([]SignShare, error) {
+	unlock, err := i.lockPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if i.keyType != KEYEC25519 || i.ec25519 == nil {
+		return nil, errors.New("only EC25519 keys can be split")
+	}
+	if n < 2 || k != n {
+		return nil, errors.New("only n-of-n sharing with n >= 2 is supported")
+	}
+
+	// the Ed25519 secret scalar, as in RFC 8032.
+	h := sha512.Sum512(i.ec25519.Priv.Seed())
+	secret, err := edwards25519.NewScalar().SetBytesWithClamping(h[:32])
+	if err != nil {
+		return nil, err
+	}
+	for j := range h {
+		h[j] = 0
+	}
+
+	shares := make([]SignShare, n)
+	last := edwards25519.NewScalar().Set(secret)
+	for j := range shares {
+		shares[j] = SignShare{Index: j + 1, N: n, pub: i.ec25519.Pub}
+
+		if j == n-1 {
+			shares[j].scalar = last
+			break
+		}
+
+		shares[j].scalar, err = randomScalar(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		last.Subtract(last, shares[j].scalar)
+	}
+
+	return shares, nil
+}
+
+// Public returns the public key the combined signatures verify under.
+func (s *SignShare) Public() ed25519.PublicKey {
+	return s.pub
+}
+
+// Commit draws a fresh signing nonce, its Commitment must be sent to the
+// other share holders.
+func (s *SignShare) Commit(rand io.Reader) (*SignNonce, error) {
+	r, err := randomScalar(rand)
+	if err != nil {
+		return nil, err
+	}
+	R := new(edwards25519.Point).ScalarBaseMult(r)
+	return &SignNonce{Commitment: R.Bytes(), r: r}, nil
+}
+
+// AggregateCommitments sums the commitments of all the share holders, the
+// result is the R part of the signature.
+func AggregateCommitments(commitments [][]byte) ([]byte, error) {
+	R := edwards25519.NewIdentityPoint()
+	for _, c := range commitments {
+		P, err := new(edwards25519.Point).SetBytes(c)
+		if err != nil {
+			return nil, errors.New("invalid commitment")
+		}
+		R.Add(R, P)
+	}
+	return R.Bytes(), nil
+}
+
+// PartialSign signs msg under the ctx label (as SignWithContext) with the
+// share, R is the aggregate of all the commitments. The nonce is wiped and
+// cannot be used again.
+func (s *SignShare) PartialSign(nonce *SignNonce, R []byte, ctx string, msg []byte) ([]byte, error) {
+	if nonce == nil || nonce.used {
+		return nil, errors.New("signing nonce already used")
+	}
+	if len(R) != 32 {
+		return nil, errors.New("invalid aggregate commitment")
+	}
+
+	// k = SHA512(R || A || M), as in RFC 8032.
+	kh := sha512.New()
+	kh.Write(R)
+	kh.Write(s.pub)
+	kh.Write(contextMessage(ctx, msg))
+	k, err := edwards25519.NewScalar().SetUniformBytes(kh.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	// s_i = r_i + k * a_i
+	partial := edwards25519.NewScalar().MultiplyAdd(k, s.scalar, nonce.r)
+
+	nonce.r.Set(edwards25519.NewScalar())
+	nonce.used = true
+
+	return partial.Bytes(), nil
+}
+
+// CombinePartialSignatures builds the Ed25519 signature R || sum(s_i) out of
+// the aggregate commitment and the partial signatures of all share holders.
+func CombinePartialSignatures(R []byte, partials [][]byte) ([]byte, error) {
+	if len(R) != 32 {
+		return nil, errors.New("invalid aggregate commitment")
+	}
+
+	sum := edwards25519.NewScalar()
+	for _, p := range partials {
+		si, err := edwards25519.NewScalar().SetCanonicalBytes(p)
+		if err != nil {
+			return nil, errors.New("invalid partial signature")
+		}
+		sum.Add(sum, si)
+	}
+
+	sig := make([]byte, 0, ed25519.SignatureSize)
+	sig = append(sig, R...)
+	return append(sig, sum.Bytes()...), nil
+}
+
+// randomScalar returns a uniformly distributed scalar read from rand.
+func randomScalar(rand io.Reader) (*edwards25519.Scalar, error) {
+	var buf [64]byte
+	_, err := io.ReadFull(rand, buf[:])
+	if err != nil {
+		return nil, err
+	}
+	return edwards25519.NewScalar().SetUniformBytes(buf[:])
+}
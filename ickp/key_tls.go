@@ -0,0 +1,51 @@
+package ickp
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// ToTLSCertificate pairs the DER certificate certDER, which must be issued
+// for this identity key, with the key for use with crypto/tls. PrivateKey
+// is a crypto.Signer backed by the identity key: handshakes are rate
+// limited, reported to the KeyHook and fail once the key is destroyed or
+// expired, like SignWithContext.
+func (i *IdentityKey) ToTLSCertificate(certDER []byte) (tls.Certificate, error) {
+	priv, err := i.signer()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(i.public()) {
+		return tls.Certificate{}, errors.New("certificate does not match the identity key")
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}, nil
+}
+
+// TLSServerConfig returns a TLS 1.3 only server configuration serving
+// certDER with the identity key (see ToTLSCertificate).
+func (i *IdentityKey) TLSServerConfig(certDER []byte) (*tls.Config, error) {
+	cert, err := i.ToTLSCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+
+	// TLS 1.3 cipher suites are all AEAD and not configurable.
+	return &tls.Config{
+		Certificates:     []tls.Certificate{cert},
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}, nil
+}
@@ -0,0 +1,116 @@
+package ickp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSServerConfig(t *testing.T) {
+	for _, keytype := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		i, _ := NewIdentityKey(keytype)
+		priv, _ := i.signer()
+
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "ic.test"},
+			DNSNames:     []string{"ic.test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+		if err != nil {
+			t.Fatalf("CreateCertificate() error: %v\n", err)
+		}
+
+		config, err := i.TLSServerConfig(certDER)
+		if err != nil {
+			t.Fatalf("%s TLSServerConfig() error: %v\n", i.Type(), err)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(config.Certificates[0].Leaf)
+
+		handshake := func() (*tls.Conn, error) {
+			cliConn, srvConn := net.Pipe()
+			go func() {
+				srv := tls.Server(srvConn, config)
+				srv.Handshake()
+				srv.Close()
+			}()
+			cli := tls.Client(cliConn, &tls.Config{RootCAs: roots, ServerName: "ic.test"})
+			defer cli.Close()
+			return cli, cli.Handshake()
+		}
+		cli, err := handshake()
+		if err != nil || cli.ConnectionState().Version != tls.VersionTLS13 {
+			t.Logf("%s handshake error: %v\n", i.Type(), err)
+			t.Fail()
+		}
+
+		// a certificate for another key is refused.
+		other, _ := NewIdentityKey(keytype)
+		_, err = other.TLSServerConfig(certDER)
+		if err == nil {
+			t.Logf("%s TLSServerConfig() SHOULD fail with another key's certificate\n", i.Type())
+			t.Fail()
+		}
+
+		// the configuration follows the key, not a copy of it.
+		i.Destroy()
+		_, err = handshake()
+		if err == nil {
+			t.Logf("%s handshake SHOULD fail after Destroy()\n", i.Type())
+			t.Fail()
+		}
+	}
+}
+
+// signers handed out before the expiry must not outlive the key.
+func TestExpirySigners(t *testing.T) {
+	i, _ := NewIdentityKey(KEYECDSA)
+	priv, _ := i.signer()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ic.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error: %v\n", err)
+	}
+
+	cert, err := i.ToTLSCertificate(certDER)
+	if err != nil {
+		t.Fatalf("ToTLSCertificate() error: %v\n", err)
+	}
+	sshSigner, err := i.ToSSHSigner()
+	if err != nil {
+		t.Fatalf("ToSSHSigner() error: %v\n", err)
+	}
+
+	i.WithExpiry(50 * time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+
+	digest := make([]byte, 32)
+	_, err = cert.PrivateKey.(crypto.Signer).Sign(rand.Reader, digest, crypto.SHA256)
+	if !errors.Is(err, ErrKeyExpired) {
+		t.Logf("TLS signer SHOULD fail with ErrKeyExpired: %v\n", err)
+		t.Fail()
+	}
+	_, err = sshSigner.Sign(rand.Reader, []byte("message"))
+	if !errors.Is(err, ErrKeyExpired) {
+		t.Logf("SSH signer SHOULD fail with ErrKeyExpired: %v\n", err)
+		t.Fail()
+	}
+}
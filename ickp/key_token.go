@@ -0,0 +1,129 @@
+package ickp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// URL token key type prefixes.
+const (
+	URLTokenRSA     = "icr"
+	URLTokenECDSA   = "ice"
+	URLTokenEC25519 = "ic5"
+)
+
+var (
+	k2URLToken = map[int]string{
+		KEYRSA:     URLTokenRSA,
+		KEYECDSA:   URLTokenECDSA,
+		KEYEC25519: URLTokenEC25519,
+	}
+	urlToken2K = map[string]int{
+		URLTokenRSA:     KEYRSA,
+		URLTokenECDSA:   KEYECDSA,
+		URLTokenEC25519: KEYEC25519,
+	}
+)
+
+// PubToURLToken returns the public key line as a token that can be put in
+// URLs as is: <prefix>.<payload>.<owner>, prefix is icr, ice or ic5 for
+// RSA, ECDSA and EC25519, payload is the public key line one in unpadded
+// URL-safe base64. Only unreserved URL characters (RFC 3986) are used, no
+// percent-encoding is ever needed. See DecodeURLToken.
+func (i *IdentityKey) PubToURLToken() (string, error) {
+	keyBin, err := i.pubKeyBin()
+	if err != nil {
+		return "", err
+	}
+	if i.keyOwner == nil {
+		return "", ErrUninitialized
+	}
+	return urlToken(i.keyType, keyBin, i.keyOwner.String())
+}
+
+// PubToURLToken is the IdentityKey one, an unknown owner is written as the
+// nil UUID.
+func (p *IdentityPublicKey) PubToURLToken() (string, error) {
+	owner := p.KeyOwner
+	if len(owner) == 0 {
+		owner = nilOwner
+	}
+	return urlToken(p.KeyType, p.KeyBin, owner)
+}
+
+func urlToken(keyType int, keyBin []byte, owner string) (string, error) {
+	prefix, ok := k2URLToken[keyType]
+	if !ok {
+		return "", errors.New("no URL token for this key type")
+	}
+	if !urlTokenOwner(owner) {
+		return "", errors.New("key owner can't be put in a URL token")
+	}
+
+	line := new(bytes.Buffer)
+	err := writePublicLine(line, keyType, keyBin, owner, false)
+	if err != nil {
+		return "", err
+	}
+
+	// type, payload, owner.
+	fields := strings.Fields(line.String())
+	payload, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", err
+	}
+	return prefix + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + owner, nil
+}
+
+// DecodeURLToken parses a PubToURLToken token, the public key is checked as
+// a public key line would be.
+func DecodeURLToken(s string) (*IdentityPublicKey, error) {
+	fields := strings.Split(s, ".")
+	if len(fields) != 3 {
+		return nil, errors.New("invalid URL token")
+	}
+
+	keyType, ok := urlToken2K[fields[0]]
+	if !ok {
+		return nil, errors.New("unknown URL token type " + fields[0])
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	if !urlTokenOwner(fields[2]) {
+		return nil, errors.New("invalid URL token owner")
+	}
+
+	line := K2S[keyType] + " " + base64.StdEncoding.EncodeToString(payload) + " " + fields[2]
+	pub, err := parsePublicFile([]byte(line))
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure the key itself is usable.
+	_, err = parsePubKeyBin(pub.KeyType, pub.KeyBin)
+	if err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// urlTokenOwner reports whether owner is only made of unreserved URL
+// characters but the dot, which separates the token fields. UUIDs are.
+func urlTokenOwner(owner string) bool {
+	if len(owner) == 0 {
+		return false
+	}
+	for _, c := range owner {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	vectorInfoPrefix = "ic-test-vector:"
+	vectorContext    = "ic-test-vector"
+	vectorMessage    = "The quick brown fox jumps over the lazy dog"
+
+	// test vector RSA keys are smaller than the generated ones, finding the
+	// primes is what takes time.
+	vectorRSABits = 2048
+)
+
+// TestVector is a signature made by a deterministic key, to check the public
+// key line and signature formats do not change across releases.
+type TestVector struct {
+	Type      string `json:"type"`
+	PubLine   string `json:"pubLine"`
+	Signature []byte `json:"signature"`
+	Message   []byte `json:"message"`
+}
+
+// GenerateTestVectors derives one key per built-in key type from seed and
+// signs a fixed message with SignWithContext. The keys, and so the public
+// key lines, only depend on seed. The EC25519 signatures do too, ECDSA ones
+// (and RSA-PSS ones, depending on the Go version) are randomized and can
+// only be checked with VerifyTestVectors.
+func GenerateTestVectors(seed []byte) ([]TestVector, error) {
+	var vectors []TestVector
+
+	for _, keyType := range []int{KEYRSA, KEYECDSA, KEYEC25519} {
+		// SHAKE256(prefix || type || seed), HKDF can't output enough to
+		// search for RSA primes.
+		rd := sha3.NewShake256()
+		rd.Write([]byte(vectorInfoPrefix + K2S[keyType]))
+		rd.Write(seed)
+
+		i, err := vectorKey(keyType, rd)
+		if err != nil {
+			return nil, err
+		}
+
+		line := new(bytes.Buffer)
+		err = i.PubToPKIX(line)
+		if err != nil {
+			return nil, err
+		}
+
+		msg := []byte(vectorMessage)
+		sig, err := i.SignWithContext(vectorContext, rd, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		vectors = append(vectors, TestVector{
+			Type:      K2S[keyType],
+			PubLine:   line.String(),
+			Signature: sig,
+			Message:   msg,
+		})
+		i.Destroy()
+	}
+
+	return vectors, nil
+}
+
+// VerifyTestVectors checks every vector signature against its public key
+// line, as GenerateTestVectors made them.
+func VerifyTestVectors(vectors []TestVector) error {
+	for n, v := range vectors {
+		pub, err := parsePublicFile([]byte(v.PubLine))
+		if err != nil {
+			return fmt.Errorf("test vector %d (%s): %v", n, v.Type, err)
+		}
+		if K2S[pub.KeyType] != v.Type {
+			return fmt.Errorf("test vector %d (%s): public key is %s", n, v.Type, K2S[pub.KeyType])
+		}
+		err = pub.VerifyWithContext(vectorContext, v.Message, v.Signature)
+		if err != nil {
+			return fmt.Errorf("test vector %d (%s): %v", n, v.Type, err)
+		}
+	}
+	return nil
+}
+
+// vectorKey builds the key of keyType from rd alone, the standard library
+// key generators can't be used as they don't promise to be deterministic.
+func vectorKey(keyType int, rd io.Reader) (*IdentityKey, error) {
+	i := &IdentityKey{keyType: keyType}
+
+	switch keyType {
+	case KEYRSA:
+		k, err := vectorRSAKey(rd, vectorRSABits)
+		if err != nil {
+			return nil, err
+		}
+		i.rsa = k
+	case KEYECDSA:
+		// D in [1, N-1], the bias is irrelevant here.
+		curve := elliptic.P256()
+		buf := make([]byte, curve.Params().BitSize/8+8)
+		_, err := io.ReadFull(rd, buf)
+		if err != nil {
+			return nil, err
+		}
+		nMinusOne := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+		d := new(big.Int).Mod(new(big.Int).SetBytes(buf), nMinusOne)
+		d.Add(d, big.NewInt(1))
+
+		i.ecdsa = &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve}, D: d}
+		i.ecdsa.X, i.ecdsa.Y = curve.ScalarBaseMult(d.Bytes())
+	case KEYEC25519:
+		seed := make([]byte, ed25519.SeedSize)
+		_, err := io.ReadFull(rd, seed)
+		if err != nil {
+			return nil, err
+		}
+		i.ec25519 = new(Ed25519PrivateKey)
+		i.ec25519.Priv = ed25519.NewKeyFromSeed(seed)
+		i.ec25519.Pub = i.ec25519.Priv.Public().(ed25519.PublicKey)
+		wipeBytes(seed)
+	default:
+		return nil, errors.New("invalid key type")
+	}
+
+	err := i.setOwner()
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// vectorRSAKey is a two primes RSA key, e = 65537, with primes read from rd.
+func vectorRSAKey(rd io.Reader, bits int) (*rsa.PrivateKey, error) {
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+
+	prime := func() (*big.Int, error) {
+		buf := make([]byte, bits/16)
+		for {
+			_, err := io.ReadFull(rd, buf)
+			if err != nil {
+				return nil, err
+			}
+			// top two bits so that p*q has all its bits, odd.
+			buf[0] |= 0xc0
+			buf[len(buf)-1] |= 0x01
+			p := new(big.Int).SetBytes(buf)
+			if !p.ProbablyPrime(20) {
+				continue
+			}
+			if new(big.Int).GCD(nil, nil, e, new(big.Int).Sub(p, one)).Cmp(one) != 0 {
+				continue
+			}
+			return p, nil
+		}
+	}
+
+	p, err := prime()
+	if err != nil {
+		return nil, err
+	}
+	q, err := prime()
+	if err != nil {
+		return nil, err
+	}
+	if p.Cmp(q) == 0 {
+		return nil, errors.New("identical RSA primes")
+	}
+	return rsaKeyFromPrimes(p, q, int(e.Int64()))
+}
@@ -0,0 +1,53 @@
+package ickp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/vectors.golden.json is GenerateTestVectors([]byte("ic test
+// vectors")), it must keep verifying.
+func TestVectorsGolden(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "vectors.golden.json"))
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v\n", err)
+	}
+	var golden []TestVector
+	err = json.Unmarshal(data, &golden)
+	if err != nil {
+		t.Fatalf("json.Unmarshal() error: %v\n", err)
+	}
+
+	err = VerifyTestVectors(golden)
+	if err != nil {
+		t.Fatalf("VerifyTestVectors() error: %v\n", err)
+	}
+
+	vectors, err := GenerateTestVectors([]byte("ic test vectors"))
+	if err != nil {
+		t.Fatalf("GenerateTestVectors() error: %v\n", err)
+	}
+	if len(vectors) != len(golden) {
+		t.Fatalf("GenerateTestVectors() returned %d vectors\n", len(vectors))
+	}
+	for n, v := range vectors {
+		g := golden[n]
+		if v.Type != g.Type || v.PubLine != g.PubLine || !bytes.Equal(v.Message, g.Message) {
+			t.Logf("%s vector differs from the golden one:\n%s", v.Type, v.PubLine)
+			t.Fail()
+		}
+		if v.Type == KeyEC25519Str && !bytes.Equal(v.Signature, g.Signature) {
+			t.Logf("%s signature differs from the golden one\n", v.Type)
+			t.Fail()
+		}
+	}
+
+	golden[0].Signature[0] ^= 0x01
+	if VerifyTestVectors(golden) == nil {
+		t.Logf("VerifyTestVectors() SHOULD fail with a corrupted signature\n")
+		t.Fail()
+	}
+}
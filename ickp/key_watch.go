@@ -0,0 +1,82 @@
+package ickp
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/unix4fun/ic/icutl"
+)
+
+// keyFilesState is what we look at to decide the key files changed.
+type keyFilesState struct {
+	privMod  time.Time
+	privSize int64
+	pubMod   time.Time
+	pubSize  int64
+}
+
+func statKeyFiles(prefix string) (st keyFilesState, err error) {
+	privInfo, err := os.Stat(prefix)
+	if err != nil {
+		return
+	}
+	pubInfo, err := os.Stat(prefix + ".pub")
+	if err != nil {
+		return
+	}
+
+	st.privMod, st.privSize = privInfo.ModTime(), privInfo.Size()
+	st.pubMod, st.pubSize = pubInfo.ModTime(), pubInfo.Size()
+	return
+}
+
+// WatchKeyFiles polls prefix / prefix.pub and reloads the identity key when
+// they change, calling onReload with the newly loaded key.
+// A change is only acted upon once the files stay identical for a whole
+// polling interval, so that a key being written (or renamed in place) is not
+// loaded half way. Reload failures are logged and the previous key is kept.
+// It blocks until ctx is done and returns ctx.Err().
+func WatchKeyFiles(ctx context.Context, prefix string, passwd []byte, onReload func(*IdentityKey)) error {
+	// a missing file is a state like any other, we wait for it to appear.
+	current, _ := statKeyFiles(prefix)
+	pending := current
+
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		st, err := statKeyFiles(prefix)
+		if err != nil {
+			// files being replaced, let's wait for them to come back.
+			continue
+		}
+
+		if st == current {
+			pending = st
+			continue
+		}
+
+		// changed since last tick, wait until it settles.
+		if st != pending {
+			pending = st
+			continue
+		}
+
+		current = st
+		i, err := LoadIdentityKey(prefix, passwd)
+		if err != nil {
+			icutl.DebugLog.Printf("WatchKeyFiles(%s) reload error: %v", prefix, err)
+			continue
+		}
+
+		icutl.DebugLog.Printf("WatchKeyFiles(%s) key reloaded", prefix)
+		onReload(i)
+	}
+}
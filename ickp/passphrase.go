@@ -0,0 +1,136 @@
+package ickp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ErrPassphraseMismatch is returned by PromptNewPassphrase when the two
+// entered passphrases differ.
+var ErrPassphraseMismatch = errors.New("passphrases do not match")
+
+// PassphraseSource provides the passphrase used to derive the AEAD key
+// protecting private keys.
+type PassphraseSource interface {
+	Passphrase() ([]byte, error)
+}
+
+// PassphraseBytes is a literal passphrase.
+type PassphraseBytes []byte
+
+func (p PassphraseBytes) Passphrase() ([]byte, error) {
+	return []byte(p), nil
+}
+
+// PassphraseFile is the path of a key file, its whole content is used as the
+// passphrase, as is: key files may be random binary data, so a trailing
+// newline is not stripped and is part of the passphrase (printf, not echo).
+type PassphraseFile string
+
+func (p PassphraseFile) Passphrase() ([]byte, error) {
+	return ioutil.ReadFile(string(p))
+}
+
+// PassphraseEnv is the name of the environment variable holding the
+// passphrase, an unset or empty variable is an error.
+type PassphraseEnv string
+
+func (p PassphraseEnv) Passphrase() ([]byte, error) {
+	passwd := os.Getenv(string(p))
+	if len(passwd) == 0 {
+		return nil, fmt.Errorf("passphrase variable %s is not set", string(p))
+	}
+	return []byte(passwd), nil
+}
+
+// PassphraseFunc is a callback returning the passphrase, i.e. a prompt.
+type PassphraseFunc func() ([]byte, error)
+
+func (p PassphraseFunc) Passphrase() ([]byte, error) {
+	return p()
+}
+
+// PrivToPKIXSource is PrivToPKIX with the passphrase taken from src, the
+// written PEM is the same.
+func (i *IdentityKey) PrivToPKIXSource(wr io.Writer, src PassphraseSource) error {
+	passwd, err := src.Passphrase()
+	if err != nil {
+		return err
+	}
+	return i.PrivToPKIX(wr, passwd)
+}
+
+// FromKeyFilesSource is FromKeyFiles with the passphrase taken from src.
+func (i *IdentityKey) FromKeyFilesSource(prefix string, src PassphraseSource) error {
+	passwd, err := src.Passphrase()
+	if err != nil {
+		return err
+	}
+	return i.FromKeyFiles(prefix, passwd)
+}
+
+// passphraseLines returns the function reading the passphrase lines from in.
+// A terminal is read with echo disabled, anything else (i.e. a pipe) is read
+// line by line, without the line ending: `echo pw | cmd` works.
+func passphraseLines(in io.Reader, out io.Writer) func() ([]byte, error) {
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return func() ([]byte, error) {
+			line, err := term.ReadPassword(int(f.Fd()))
+			fmt.Fprintln(out)
+			return line, err
+		}
+	}
+
+	// one buffered reader for all the lines.
+	rd := bufio.NewReader(in)
+	return func() ([]byte, error) {
+		line, err := rd.ReadString('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	}
+}
+
+// ReadPassphrase asks for the passphrase on out and reads it from in, with
+// echo disabled when in is a terminal, a single line otherwise.
+// When in is not a terminal it may be read past that line.
+func ReadPassphrase(in io.Reader, out io.Writer) ([]byte, error) {
+	fmt.Fprint(out, "Enter passphrase: ")
+	return passphraseLines(in, out)()
+}
+
+// PromptNewPassphrase asks for a new passphrase twice on out and reads them
+// from in, with echo disabled when in is a terminal. It fails with
+// ErrPassphraseMismatch when they differ.
+func PromptNewPassphrase(in io.Reader, out io.Writer) ([]byte, error) {
+	readLine := passphraseLines(in, out)
+
+	fmt.Fprint(out, "Enter new passphrase: ")
+	first, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprint(out, "Confirm new passphrase: ")
+	second, err := readLine()
+	if err != nil {
+		wipeBytes(first)
+		return nil, err
+	}
+	defer wipeBytes(second)
+
+	if !bytes.Equal(first, second) {
+		wipeBytes(first)
+		return nil, ErrPassphraseMismatch
+	}
+	return first, nil
+}
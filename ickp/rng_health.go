@@ -0,0 +1,63 @@
+package ickp
+
+import (
+	"errors"
+	"io"
+)
+
+// Continuous health tests from NIST SP 800-90B (4.4), run over a sample of
+// the randomness source before key generation.
+// Cutoffs are computed for an assumed min-entropy of H = 4 bits per byte
+// (half of the ideal) and a false positive probability of 2^-20:
+//
+//	repetition count cutoff:   1 + ceil(20 / H)                    = 6
+//	adaptive proportion cutoff: 1 + CRITBINOM(512, 2^-H, 1 - 2^-20) = 62
+const (
+	rngSampleSize = 1024
+	rngRCTCutoff  = 6
+	rngAPTWindow  = 512
+	rngAPTCutoff  = 62
+)
+
+// ErrRNGHealth is returned when the randomness source fails the health tests.
+var ErrRNGHealth = errors.New("randomness source failed health tests")
+
+// CheckRNGHealth reads a sample from rnd and runs the repetition count and
+// adaptive proportion tests on it, returning ErrRNGHealth if the source
+// looks stuck.
+func CheckRNGHealth(rnd io.Reader) error {
+	sample := make([]byte, rngSampleSize)
+	_, err := io.ReadFull(rnd, sample)
+	if err != nil {
+		return err
+	}
+
+	// repetition count test
+	count := 1
+	for k := 1; k < len(sample); k++ {
+		if sample[k] == sample[k-1] {
+			count++
+			if count >= rngRCTCutoff {
+				return ErrRNGHealth
+			}
+		} else {
+			count = 1
+		}
+	}
+
+	// adaptive proportion test
+	for w := 0; w+rngAPTWindow <= len(sample); w += rngAPTWindow {
+		window := sample[w : w+rngAPTWindow]
+		count = 0
+		for _, b := range window {
+			if b == window[0] {
+				count++
+			}
+		}
+		if count >= rngAPTCutoff {
+			return ErrRNGHealth
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package ickp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Sealed stream format, all integers are big endian:
+//
+//	header: "ICS1" || time u32 || memory u32 || threads u8 || salt[16] || nonce prefix[7]
+//	frame:  plaintext length u32 || AES-256-GCM(plaintext)
+//
+// The key is Argon2id(passwd, salt) with the header parameters, the header is
+// the additional data of every frame. The nonce of frame n is
+// prefix || u32(n) || final, where final is 1 for the last frame (possibly
+// empty) and 0 otherwise, so frames can neither be reordered nor dropped,
+// and a truncated stream is detected.
+const (
+	sealMagic       = "ICS1"
+	sealPrefixSize  = 7
+	sealHeaderSize  = len(sealMagic) + 4 + 4 + 1 + argon2SaltSize + sealPrefixSize
+	sealFrameSize   = 64 * 1024
+	sealFrameHeader = 4
+)
+
+var errSealTruncated = errors.New("sealed stream truncated")
+
+// sealState is what both ends of a sealed stream share.
+type sealState struct {
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+}
+
+func newSealState(header, passwd []byte) (*sealState, error) {
+	if len(header) != sealHeaderSize || string(header[:len(sealMagic)]) != sealMagic {
+		return nil, errors.New("not a sealed stream")
+	}
+
+	off := len(sealMagic)
+	params := KDFParams{
+		Time:    binary.BigEndian.Uint32(header[off:]),
+		Memory:  binary.BigEndian.Uint32(header[off+4:]),
+		Threads: header[off+8],
+	}
+	off += 9
+	_, ok := parseKDFInfo(params.String())
+	if !ok || !params.withinLimits() {
+		return nil, errors.New("invalid sealed stream KDF parameters")
+	}
+
+	salt := header[off : off+argon2SaltSize]
+	aesraw, err := aes.NewCipher(deriveKey(passwd, salt, &params))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(aesraw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealState{
+		aead:   aead,
+		header: header,
+		prefix: header[off+argon2SaltSize:],
+	}, nil
+}
+
+// nonce returns the next frame nonce, it fails rather than wrap around.
+func (s *sealState) nonce(final bool) ([]byte, error) {
+	if s.counter == ^uint32(0) {
+		return nil, errors.New("sealed stream too long")
+	}
+
+	nonce := make([]byte, 0, s.aead.NonceSize())
+	nonce = append(nonce, s.prefix...)
+	nonce = append(nonce, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(nonce[sealPrefixSize:], s.counter)
+	if final {
+		nonce = append(nonce, 1)
+	} else {
+		nonce = append(nonce, 0)
+	}
+	s.counter++
+	return nonce, nil
+}
+
+type sealWriter struct {
+	w      io.Writer
+	state  *sealState
+	buf    []byte
+	closed bool
+}
+
+// SealStream returns a writer encrypting everything written to it into w,
+// in frames, with a key derived from passwd (DefaultKDFParams). Close must
+// be called to write the final frame, it does not close w.
+func SealStream(w io.Writer, passwd []byte) (io.WriteCloser, error) {
+	header := make([]byte, 0, sealHeaderSize)
+	header = append(header, sealMagic...)
+	header = append(header, 0, 0, 0, 0, 0, 0, 0, 0, DefaultKDFParams.Threads)
+	binary.BigEndian.PutUint32(header[len(sealMagic):], DefaultKDFParams.Time)
+	binary.BigEndian.PutUint32(header[len(sealMagic)+4:], DefaultKDFParams.Memory)
+
+	random := make([]byte, argon2SaltSize+sealPrefixSize)
+	_, err := io.ReadFull(rand.Reader, random)
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, random...)
+
+	state, err := newSealState(header, passwd)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealWriter{w: w, state: state, buf: make([]byte, 0, sealFrameSize)}, nil
+}
+
+func (sw *sealWriter) Write(p []byte) (n int, err error) {
+	if sw.closed {
+		return 0, errors.New("write on closed sealed stream")
+	}
+
+	for len(p) > 0 {
+		// keep the last (possibly full) frame for Close, to flag it final.
+		if len(sw.buf) == sealFrameSize {
+			err = sw.writeFrame(false)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		c := copy(sw.buf[len(sw.buf):cap(sw.buf)], p)
+		sw.buf = sw.buf[:len(sw.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (sw *sealWriter) writeFrame(final bool) error {
+	nonce, err := sw.state.nonce(final)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, sealFrameHeader, sealFrameHeader+len(sw.buf)+sw.state.aead.Overhead())
+	binary.BigEndian.PutUint32(frame, uint32(len(sw.buf)))
+	frame = sw.state.aead.Seal(frame, nonce, sw.buf, sw.state.header)
+
+	sw.buf = sw.buf[:0]
+	_, err = sw.w.Write(frame)
+	return err
+}
+
+// Close writes the final frame.
+func (sw *sealWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.writeFrame(true)
+}
+
+type openReader struct {
+	r     io.Reader
+	state *sealState
+	plain *bytes.Reader
+	done  bool
+	err   error
+}
+
+// OpenReader returns a reader decrypting the SealStream sealed data read from
+// r, frame by frame. A stream missing its final frame gives an error, never
+// io.EOF, and so does any data following the final frame.
+func OpenReader(r io.Reader, passwd []byte) (io.ReadCloser, error) {
+	header := make([]byte, sealHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, errors.New("not a sealed stream")
+	}
+
+	state, err := newSealState(header, passwd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openReader{r: r, state: state, plain: bytes.NewReader(nil)}, nil
+}
+
+func (or *openReader) Read(p []byte) (int, error) {
+	for or.plain.Len() == 0 {
+		if or.err != nil {
+			return 0, or.err
+		}
+		if or.done {
+			return 0, io.EOF
+		}
+		or.err = or.readFrame()
+	}
+	return or.plain.Read(p)
+}
+
+func (or *openReader) readFrame() error {
+	var lenBuf [sealFrameHeader]byte
+	_, err := io.ReadFull(or.r, lenBuf[:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errSealTruncated
+	}
+	if err != nil {
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(lenBuf[:])
+	if size > sealFrameSize {
+		return errors.New("invalid sealed stream frame")
+	}
+
+	frame := make([]byte, int(size)+or.state.aead.Overhead())
+	_, err = io.ReadFull(or.r, frame)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errSealTruncated
+	}
+	if err != nil {
+		return err
+	}
+
+	// a non final frame can't be short, try final first then.
+	final := size < sealFrameSize
+	nonce, err := or.state.nonce(final)
+	if err != nil {
+		return err
+	}
+	plain, err := or.state.aead.Open(nil, nonce, frame, or.state.header)
+	if err != nil && !final {
+		nonce[len(nonce)-1] = 1
+		final = true
+		plain, err = or.state.aead.Open(nil, nonce, frame, or.state.header)
+	}
+	if err != nil {
+		return errors.New("sealed stream authentication failed")
+	}
+
+	if final {
+		// nothing may follow the final frame.
+		var extra [1]byte
+		n, _ := io.ReadFull(or.r, extra[:])
+		if n > 0 {
+			return errors.New("data after the sealed stream final frame")
+		}
+		or.done = true
+	}
+
+	or.plain.Reset(plain)
+	return nil
+}
+
+// Close releases the reader, it does not close the underlying reader.
+func (or *openReader) Close() error {
+	or.plain.Reset(nil)
+	or.err = errors.New("read on closed sealed stream")
+	return nil
+}
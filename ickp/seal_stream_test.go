@@ -0,0 +1,55 @@
+package ickp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSealStream(t *testing.T) {
+	passwd := []byte("passphrase")
+
+	for _, size := range []int{0, 10, sealFrameSize, 2*sealFrameSize + 10} {
+		msg := bytes.Repeat([]byte{'a'}, size)
+
+		sealed := new(bytes.Buffer)
+		w, err := SealStream(sealed, passwd)
+		if err != nil {
+			t.Fatalf("SealStream() error: %v\n", err)
+		}
+		_, err = w.Write(msg)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			t.Fatalf("SealStream() write error: %v\n", err)
+		}
+
+		r, err := OpenReader(bytes.NewReader(sealed.Bytes()), passwd)
+		if err != nil {
+			t.Fatalf("OpenReader() error: %v\n", err)
+		}
+		plain, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(plain, msg) {
+			t.Logf("%d bytes: OpenReader() read error: %v\n", size, err)
+			t.Fail()
+		}
+
+		// dropping the final frame (or any part of it) must not look like EOF.
+		for _, cut := range []int{1, sealFrameHeader + 16} {
+			if sealed.Len()-cut < sealHeaderSize {
+				continue
+			}
+			r, err := OpenReader(bytes.NewReader(sealed.Bytes()[:sealed.Len()-cut]), passwd)
+			if err != nil {
+				t.Fatalf("OpenReader() error: %v\n", err)
+			}
+			_, err = io.Copy(ioutil.Discard, r)
+			if err == nil {
+				t.Logf("%d bytes: truncated stream SHOULD fail\n", size)
+				t.Fail()
+			}
+		}
+	}
+}
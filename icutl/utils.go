@@ -1,11 +1,14 @@
 // +build go1.5
+
 package icutl
 
 import (
 	"bytes"
+	"compress/gzip"
 	"compress/zlib"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/sha3" // sha3 is now here.
 	"io"
@@ -123,6 +126,58 @@ func DecompressData(in []byte) (out []byte, err error) {
 	return out, nil
 }
 
+// ErrDecompressTooLarge is returned by DecompressDataLimit when the data
+// inflates to more than the limit.
+var ErrDecompressTooLarge = errors.New("decompressed data too large")
+
+// DecompressDataLimit is DecompressData refusing to inflate more than max
+// bytes, it protects against compression bombs.
+func DecompressDataLimit(in []byte, max int64) (out []byte, err error) {
+	if len(in) == 0 {
+		return nil, &AcError{Value: -1, Msg: "DecompressDataLimit() invalid input: ", Err: err}
+	}
+
+	plain, err := zlib.NewReader(bytes.NewReader(in))
+	if err != nil {
+		return nil, &AcError{Value: -2, Msg: "DecompressDataLimit().zlib.NewReader(): ", Err: err}
+	}
+	defer plain.Close()
+
+	out, err = ioutil.ReadAll(io.LimitReader(plain, max+1))
+	if err != nil {
+		return nil, &AcError{Value: -3, Msg: "DecompressDataLimit().ioutil().ReadAll(): ", Err: err}
+	}
+	if int64(len(out)) > max {
+		return nil, ErrDecompressTooLarge
+	}
+
+	return out, nil
+}
+
+// GunzipDataLimit is DecompressDataLimit for gzip (RFC 1952) compressed
+// data, i.e. data wrapped by a transport.
+func GunzipDataLimit(in []byte, max int64) (out []byte, err error) {
+	if len(in) == 0 {
+		return nil, &AcError{Value: -1, Msg: "GunzipDataLimit() invalid input: ", Err: err}
+	}
+
+	plain, err := gzip.NewReader(bytes.NewReader(in))
+	if err != nil {
+		return nil, &AcError{Value: -2, Msg: "GunzipDataLimit().gzip.NewReader(): ", Err: err}
+	}
+	defer plain.Close()
+
+	out, err = ioutil.ReadAll(io.LimitReader(plain, max+1))
+	if err != nil {
+		return nil, &AcError{Value: -3, Msg: "GunzipDataLimit().ioutil().ReadAll(): ", Err: err}
+	}
+	if int64(len(out)) > max {
+		return nil, ErrDecompressTooLarge
+	}
+
+	return out, nil
+}
+
 // XXX should len be uint32 or uint64 instead?
 func GetRandomBytes(size int) (out []byte, err error) {
 	newRnd := make([]byte, size)
@@ -2,6 +2,7 @@ package icutl
 
 import (
 	"bytes"
+	"compress/gzip"
 	cr "crypto/rand"
 	"math/rand"
 	"testing"
@@ -91,3 +92,41 @@ func TestCompressDecompressDataError(t *testing.T) {
 // BASE64 TESTS
 //
 //
+
+func TestDecompressDataLimit(t *testing.T) {
+	o, err := CompressData(make([]byte, 1024))
+	if err != nil {
+		t.Fatalf("CompressData() error: %v\n", err)
+	}
+
+	oo, err := DecompressDataLimit(o, 1024)
+	if err != nil || len(oo) != 1024 {
+		t.Logf("DecompressDataLimit() error: %v [%d]\n", err, len(oo))
+		t.Fail()
+	}
+
+	_, err = DecompressDataLimit(o, 1023)
+	if err != ErrDecompressTooLarge {
+		t.Logf("DecompressDataLimit() SHOULD fail with ErrDecompressTooLarge: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestGunzipDataLimit(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	gz.Write(make([]byte, 1024))
+	gz.Close()
+
+	oo, err := GunzipDataLimit(buf.Bytes(), 1024)
+	if err != nil || len(oo) != 1024 {
+		t.Logf("GunzipDataLimit() error: %v [%d]\n", err, len(oo))
+		t.Fail()
+	}
+
+	_, err = GunzipDataLimit(buf.Bytes(), 1023)
+	if err != ErrDecompressTooLarge {
+		t.Logf("GunzipDataLimit() SHOULD fail with ErrDecompressTooLarge: %v\n", err)
+		t.Fail()
+	}
+}